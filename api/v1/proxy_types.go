@@ -28,8 +28,189 @@ type ProxySpec struct {
 
 	// +optional
 	Template ProxyPod `json:"template,omitempty"`
+
+	// TransitPort configures the allocation of transit ports of the tunnels.
+	// +optional
+	TransitPort ProxyTransitPort `json:"transitPort,omitempty"`
+
+	// Service configures the Service which has the ports of all tunnels of the proxy.
+	// +optional
+	Service ProxyService `json:"service,omitempty"`
+
+	// NetworkPolicy configures the NetworkPolicy of the proxy pods.
+	// +optional
+	NetworkPolicy ProxyNetworkPolicy `json:"networkPolicy,omitempty"`
+
+	// TLSPassthrough configures the listener shared by the tunnels of spec.tlsPassthrough.
+	// +optional
+	TLSPassthrough ProxyTLSPassthrough `json:"tlsPassthrough,omitempty"`
+
+	// AllowedSourceRanges is the default of spec.allowedSourceRanges of the tunnels.
+	// If not set, any client is allowed.
+	// +optional
+	AllowedSourceRanges []string `json:"allowedSourceRanges,omitempty"`
+
+	// AccessLog is the default of spec.accessLog of the tunnels.
+	// If not set, no access log is written.
+	// +optional
+	AccessLog *AccessLog `json:"accessLog,omitempty"`
+
+	// Timeouts is the default of spec.timeouts of the tunnels.
+	// +optional
+	Timeouts *TunnelTimeouts `json:"timeouts,omitempty"`
+
+	// Keepalive is the default of spec.keepalive of the tunnels.
+	// +optional
+	Keepalive *TunnelKeepalive `json:"keepalive,omitempty"`
+
+	// DNS is the default of spec.dns of the tunnels.
+	// +optional
+	DNS *TunnelDNS `json:"dns,omitempty"`
+
+	// Addresses of the DNS servers to resolve the hosts of the tunnels, such as 10.0.0.2 or 10.0.0.2:53.
+	// If not set, the DNS servers of the pod are used.
+	// +optional
+	DNSResolvers []string `json:"dnsResolvers,omitempty"`
+
+	// If true, the proxy listens on both IPv4 and IPv6,
+	// and the Services of the proxy and tunnels prefer dual-stack.
+	// The cluster must support dual-stack networking.
+	// +optional
+	DualStack bool `json:"dualStack,omitempty"`
+}
+
+// ProxyService defines the Service of a proxy
+type ProxyService struct {
+	// If true, the controller creates a Service named ktunnels-proxy-NAME,
+	// so that a single port-forward to the Service opens all tunnels.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// ProxyNetworkPolicy defines the NetworkPolicy of a proxy
+type ProxyNetworkPolicy struct {
+	// If true, the controller creates a NetworkPolicy named ktunnels-proxy-NAME.
+	// It allows ingress only to the transit ports, TLS passthrough port,
+	// and the admin port from the namespaces of --admin-ingress-namespaces flag of the controller,
+	// and egress only to the destination ports of the tunnels, DNS, the access log collectors and the xDS server.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+}
+
+// ProxyTLSPassthrough defines the listener shared by the tunnels of TLS passthrough.
+type ProxyTLSPassthrough struct {
+	// If true, the proxy routes a TLS connection on the port to a tunnel by the server name (SNI),
+	// so that the tunnels of spec.tlsPassthrough share a single port.
+	// +optional
+	Enabled bool `json:"enabled,omitempty"`
+
+	// Port of the proxy pods for the shared listener.
+	// It is never allocated as a transit port.
+	// Default to 8443.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Port *int32 `json:"port,omitempty"`
+}
+
+// AccessLog defines the access logs of tunnels.
+// An entry is written when a connection to a tunnel is closed.
+type AccessLog struct {
+	// Stdout writes the access logs to the standard output of the proxy.
+	// +optional
+	Stdout *StdoutAccessLog `json:"stdout,omitempty"`
+
+	// Collector sends the access logs to a collector via gRPC.
+	// +optional
+	Collector *AccessLogCollector `json:"collector,omitempty"`
+}
+
+// StdoutAccessLog defines the access logs written to the standard output.
+type StdoutAccessLog struct {
+	// Format of an entry.
+	// Default to JSON.
+	// +optional
+	Format AccessLogFormat `json:"format,omitempty"`
+
+	// Format string of a Text entry, using the command operators of Envoy such as %DOWNSTREAM_REMOTE_ADDRESS%.
+	// The tunnel name is available as %UPSTREAM_CLUSTER%.
+	// Default to a format with the tunnel name, namespace, downstream address, upstream host, bytes and duration.
+	// +optional
+	TextFormat string `json:"textFormat,omitempty"`
+
+	// Fields of a JSON entry, using the command operators of Envoy as the values.
+	// They are added to the default fields of the tunnel name, namespace, downstream address, upstream host, bytes and duration.
+	// +optional
+	JSONFormat map[string]string `json:"jsonFormat,omitempty"`
 }
 
+// AccessLogFormat represents the format of an access log entry.
+// +kubebuilder:validation:Enum=JSON;Text
+type AccessLogFormat string
+
+const (
+	AccessLogFormatJSON AccessLogFormat = "JSON"
+	AccessLogFormatText AccessLogFormat = "Text"
+)
+
+// AccessLogCollector defines the collector of the access logs.
+type AccessLogCollector struct {
+	// Address of the collector, such as otel-collector.monitoring.svc:4317.
+	// +kubebuilder:validation:MinLength=1
+	Address string `json:"address"`
+
+	// Protocol of the collector.
+	// OpenTelemetry sends the logs via OTLP/gRPC.
+	// GRPC sends the logs via the gRPC access log service of Envoy.
+	// Default to OpenTelemetry.
+	// +optional
+	Protocol AccessLogCollectorProtocol `json:"protocol,omitempty"`
+}
+
+// AccessLogCollectorProtocol represents the protocol of an access log collector.
+// +kubebuilder:validation:Enum=OpenTelemetry;GRPC
+type AccessLogCollectorProtocol string
+
+const (
+	AccessLogCollectorProtocolOpenTelemetry AccessLogCollectorProtocol = "OpenTelemetry"
+	AccessLogCollectorProtocolGRPC          AccessLogCollectorProtocol = "GRPC"
+)
+
+// ProxyTransitPort defines the allocation of transit ports
+// +kubebuilder:validation:XValidation:rule="!has(self.min) || !has(self.max) || self.min <= self.max",message="min must be less than or equal to max"
+type ProxyTransitPort struct {
+	// Minimum port of the range.
+	// Default to the range given by the controller flag.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Min *int32 `json:"min,omitempty"`
+
+	// Maximum port of the range.
+	// Default to the range given by the controller flag.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	Max *int32 `json:"max,omitempty"`
+
+	// Strategy to allocate a transit port.
+	// Random picks an available port randomly.
+	// Hash picks a port from the hash of the tunnel namespace and name,
+	// so that a recreated tunnel gets the same port.
+	// Default to Random.
+	// +optional
+	Strategy TransitPortStrategy `json:"strategy,omitempty"`
+}
+
+// TransitPortStrategy is a strategy to allocate a transit port.
+// +kubebuilder:validation:Enum=Random;Hash
+type TransitPortStrategy string
+
+const (
+	TransitPortStrategyRandom TransitPortStrategy = "Random"
+	TransitPortStrategyHash   TransitPortStrategy = "Hash"
+)
+
 // ProxyPod defines the desired state of a Pod
 type ProxyPod struct {
 	// +optional
@@ -44,6 +225,19 @@ type ProxyPodSpec struct {
 	// +optional
 	Envoy        ProxyEnvoy        `json:"envoy,omitempty"`
 	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// DNS policy of the pod.
+	// Set None to use only dnsConfig.
+	// +optional
+	DNSPolicy corev1.DNSPolicy `json:"dnsPolicy,omitempty"`
+
+	// DNS parameters of the pod, merged with the DNS policy.
+	// +optional
+	DNSConfig *corev1.PodDNSConfig `json:"dnsConfig,omitempty"`
+
+	// Entries of /etc/hosts of the pod.
+	// +optional
+	HostAliases []corev1.HostAlias `json:"hostAliases,omitempty"`
 }
 
 // ProxyEnvoy defines the desired state of an Envoy container
@@ -63,11 +257,60 @@ type ProxyEnvoy struct {
 type ProxyStatus struct {
 	// Ready becomes true when the owned Deployment is ready
 	Ready bool `json:"ready,omitempty"`
+
+	// The generation observed by proxy controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the proxy.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// TransitPortPool represents the usage of transit ports.
+	// +optional
+	TransitPortPool *ProxyTransitPortPool `json:"transitPortPool,omitempty"`
+}
+
+// ProxyTransitPortPool represents the usage of transit ports
+type ProxyTransitPortPool struct {
+	// Number of the allocated ports in the range.
+	Allocated int32 `json:"allocated"`
+
+	// Number of the ports in the range.
+	Capacity int32 `json:"capacity"`
 }
 
+// Condition types of Proxy.
+const (
+	// ProxyConditionReady is true when the proxy is available.
+	ProxyConditionReady = "Ready"
+	// ProxyConditionDeploymentReady is true when all replicas of the owned Deployment are ready.
+	ProxyConditionDeploymentReady = "DeploymentReady"
+	// ProxyConditionPortPoolExhausted is true when a tunnel could not get a transit port.
+	ProxyConditionPortPoolExhausted = "PortPoolExhausted"
+)
+
+// Condition reasons of Proxy.
+const (
+	ProxyReasonReady              = "Ready"
+	ProxyReasonNotReady           = "NotReady"
+	ProxyReasonDeploymentReady    = "DeploymentReady"
+	ProxyReasonDeploymentNotReady = "DeploymentNotReady"
+	ProxyReasonReconcileError     = "ReconcileError"
+	ProxyReasonPortPoolExhausted  = "PortPoolExhausted"
+	ProxyReasonPortPoolAvailable  = "PortPoolAvailable"
+)
+
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 //+kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+//+kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
+//+kubebuilder:printcolumn:name="Allocated",type=integer,JSONPath=`.status.transitPortPool.allocated`,priority=1
+//+kubebuilder:printcolumn:name="Capacity",type=integer,JSONPath=`.status.transitPortPool.capacity`,priority=1
 
 // Proxy is the Schema for the proxies API
 type Proxy struct {
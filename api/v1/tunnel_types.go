@@ -17,6 +17,9 @@ limitations under the License.
 package v1
 
 import (
+	"slices"
+	"time"
+
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
@@ -24,13 +27,392 @@ import (
 // TunnelSpec defines the desired state of Tunnel
 type TunnelSpec struct {
 	// Destination hostname of this tunnel.
+	// Either host or destinations must be set.
+	// +optional
 	Host string `json:"host,omitempty"`
 
+	// Destination hosts of this tunnel, such as the old and new hosts during a migration.
+	// If set, host is ignored.
+	// The connections are balanced by the weights among the destinations of the highest priority,
+	// and a destination refusing connections is ejected for a while.
+	// +optional
+	Destinations []TunnelDestination `json:"destinations,omitempty"`
+
 	// Destination port of this tunnel.
+	// Either port or ports must be set.
+	// +optional
 	Port int32 `json:"port,omitempty"`
 
+	// Destination ports of this tunnel.
+	// If set, port is ignored and a transit port is allocated for each entry.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	Ports []TunnelPort `json:"ports,omitempty"`
+
+	// Transit port of the proxy for port.
+	// If set, the proxy controller allocates this port instead of an automatically allocated one.
+	// If the port is requested by an older tunnel or out of the range of the proxy,
+	// no port is allocated and the PortAllocated condition shows the reason.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	TransitPort *int32 `json:"transitPort,omitempty"`
+
 	// Proxy resource to register.
 	Proxy corev1.LocalObjectReference `json:"proxy,omitempty"`
+
+	// Application protocol of the destination.
+	// If set, the proxy parses the protocol and exposes the statistics such as the number of queries.
+	// PostgreSQL and MySQL require the contrib image of Envoy.
+	// Redis does not support AUTH, SELECT, Pub/Sub, blocking commands and transactions,
+	// and cannot be set with the idle timeout, max connection duration and keepalive.
+	// Default to TCP.
+	// +optional
+	Protocol TunnelProtocol `json:"protocol,omitempty"`
+
+	// TLS origination to the destination.
+	// If set, the proxy connects to the destination with TLS,
+	// so that a client can connect to the tunnel with plaintext.
+	// +optional
+	TLS *TunnelTLS `json:"tls,omitempty"`
+
+	// If true, this tunnel shares the TLS passthrough listener of the proxy instead of a transit port.
+	// The proxy routes a TLS connection to this tunnel by the server name (SNI) matching the hosts of the destinations,
+	// so that a client connecting with the real hostname can verify the certificate of the destination.
+	// spec.tlsPassthrough of the proxy must be enabled.
+	// +optional
+	TLSPassthrough bool `json:"tlsPassthrough,omitempty"`
+
+	// HTTP mode of this tunnel.
+	// If set, the proxy handles HTTP requests instead of forwarding the bytes,
+	// so that the destination receives its own hostname in the Host header.
+	// Set tls to connect to the destination with HTTPS.
+	// +optional
+	HTTP *TunnelHTTP `json:"http,omitempty"`
+
+	// CIDRs of the clients allowed to connect to this tunnel, such as 10.0.0.0/8.
+	// Set 127.0.0.1/32 to allow only kubectl port-forward to the proxy pod.
+	// If not set, spec.allowedSourceRanges of the proxy is used.
+	// If neither is set, any client is allowed.
+	// +optional
+	AllowedSourceRanges []string `json:"allowedSourceRanges,omitempty"`
+
+	// Access logs of this tunnel.
+	// If set, it overrides spec.accessLog of the proxy.
+	// Set {} to disable the access logs of this tunnel.
+	// +optional
+	AccessLog *AccessLog `json:"accessLog,omitempty"`
+
+	// Timeouts of the connections to this tunnel.
+	// Each field takes precedence over spec.timeouts of the proxy.
+	// +optional
+	Timeouts *TunnelTimeouts `json:"timeouts,omitempty"`
+
+	// TCP keepalive of the connections to the destination.
+	// Each field takes precedence over spec.keepalive of the proxy.
+	// +optional
+	Keepalive *TunnelKeepalive `json:"keepalive,omitempty"`
+
+	// Active health checking of the destinations.
+	// If set, the UpstreamReachable condition shows the result.
+	// +optional
+	HealthCheck *TunnelHealthCheck `json:"healthCheck,omitempty"`
+
+	// DNS resolution of spec.host.
+	// Each field takes precedence over spec.dns of the proxy.
+	// If spec.host is an IP address, it is not resolved.
+	// +optional
+	DNS *TunnelDNS `json:"dns,omitempty"`
+
+	// Time when this tunnel expires.
+	// If both expiresAt and ttl are set, the earlier one is used.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Duration until this tunnel expires since the creation, such as 8h.
+	// +optional
+	TTL *metav1.Duration `json:"ttl,omitempty"`
+
+	// Action when this tunnel expires.
+	// In both cases, the proxy stops routing this tunnel and the service is deleted.
+	// Retain keeps this tunnel with the Expired condition, so that you can extend the deadline.
+	// Delete deletes this tunnel.
+	// Default to Retain.
+	// +optional
+	ExpiryPolicy TunnelExpiryPolicy `json:"expiryPolicy,omitempty"`
+}
+
+// TunnelExpiryPolicy represents the action when a tunnel expires.
+// +kubebuilder:validation:Enum=Retain;Delete
+type TunnelExpiryPolicy string
+
+const (
+	TunnelExpiryPolicyRetain TunnelExpiryPolicy = "Retain"
+	TunnelExpiryPolicyDelete TunnelExpiryPolicy = "Delete"
+)
+
+// TunnelTimeouts defines the timeouts of the connections to a tunnel.
+type TunnelTimeouts struct {
+	// Timeout to connect to the destination.
+	// Default to 30s.
+	// +optional
+	Connect *metav1.Duration `json:"connect,omitempty"`
+
+	// Duration after which a connection is closed if no data is sent or received.
+	// Set 0s to disable it.
+	// Default to 1h.
+	// +optional
+	Idle *metav1.Duration `json:"idle,omitempty"`
+
+	// Maximum duration of a connection.
+	// If not set, a connection is never closed by the duration.
+	// +optional
+	MaxConnectionDuration *metav1.Duration `json:"maxConnectionDuration,omitempty"`
+}
+
+// TunnelKeepalive defines the TCP keepalive of the connections to the destination.
+// If set, the proxy enables TCP keepalive, using the system defaults for the omitted fields.
+type TunnelKeepalive struct {
+	// Duration of idle before the first keepalive probe is sent, in seconds or longer.
+	// +optional
+	Time *metav1.Duration `json:"time,omitempty"`
+
+	// Interval between the keepalive probes, in seconds or longer.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Number of unacknowledged probes before the connection is dropped.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Probes *int32 `json:"probes,omitempty"`
+}
+
+// TunnelHealthCheck defines the active health checking of the destinations.
+type TunnelHealthCheck struct {
+	// Interval between the health checks.
+	// Default to 10s.
+	// +optional
+	Interval *metav1.Duration `json:"interval,omitempty"`
+
+	// Timeout of a health check.
+	// Default to 5s.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+
+	// Number of consecutive failures before a destination is marked unhealthy.
+	// Default to 3.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	UnhealthyThreshold *int32 `json:"unhealthyThreshold,omitempty"`
+
+	// Number of consecutive successes before a destination is marked healthy.
+	// Default to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	HealthyThreshold *int32 `json:"healthyThreshold,omitempty"`
+
+	// If set, the proxy sends an HTTP request to the path and expects a 2xx response.
+	// Otherwise, the proxy checks if a TCP connection is established.
+	// +optional
+	HTTPPath string `json:"httpPath,omitempty"`
+}
+
+// TunnelDNS defines the DNS resolution of the destination host.
+type TunnelDNS struct {
+	// IP address family to resolve the host.
+	// Default to V4Only.
+	// +optional
+	LookupFamily DNSLookupFamily `json:"lookupFamily,omitempty"`
+
+	// Logical connects to the first resolved address, which is suitable for a large DNS-based service.
+	// Strict balances the connections across all resolved addresses.
+	// Default to Logical.
+	// +optional
+	Resolution DNSResolution `json:"resolution,omitempty"`
+
+	// Interval to resolve the host.
+	// Default to 5s.
+	// +optional
+	RefreshRate *metav1.Duration `json:"refreshRate,omitempty"`
+
+	// If true, the TTL of the DNS records is used as the refresh rate.
+	// +optional
+	RespectTTL *bool `json:"respectTTL,omitempty"`
+}
+
+// TunnelProtocol represents the application protocol of a destination.
+// +kubebuilder:validation:Enum=TCP;PostgreSQL;MySQL;Redis;MongoDB
+type TunnelProtocol string
+
+const (
+	TunnelProtocolTCP        TunnelProtocol = "TCP"
+	TunnelProtocolPostgreSQL TunnelProtocol = "PostgreSQL"
+	TunnelProtocolMySQL      TunnelProtocol = "MySQL"
+	TunnelProtocolRedis      TunnelProtocol = "Redis"
+	TunnelProtocolMongoDB    TunnelProtocol = "MongoDB"
+)
+
+// DNSLookupFamily represents the IP address family to resolve a host.
+// V4Preferred resolves IPv4 and falls back to IPv6.
+// Auto resolves IPv6 and falls back to IPv4.
+// All resolves both.
+// +kubebuilder:validation:Enum=V4Only;V6Only;V4Preferred;Auto;All
+type DNSLookupFamily string
+
+const (
+	DNSLookupFamilyV4Only      DNSLookupFamily = "V4Only"
+	DNSLookupFamilyV6Only      DNSLookupFamily = "V6Only"
+	DNSLookupFamilyV4Preferred DNSLookupFamily = "V4Preferred"
+	DNSLookupFamilyAuto        DNSLookupFamily = "Auto"
+	DNSLookupFamilyAll         DNSLookupFamily = "All"
+)
+
+// DNSResolution represents the type of service discovery of a destination.
+// +kubebuilder:validation:Enum=Logical;Strict
+type DNSResolution string
+
+const (
+	DNSResolutionLogical DNSResolution = "Logical"
+	DNSResolutionStrict  DNSResolution = "Strict"
+)
+
+// TunnelTLS defines the TLS settings to connect to the destination.
+type TunnelTLS struct {
+	// Server name for SNI and verification of the server certificate.
+	// Default to the host.
+	// +optional
+	ServerName string `json:"serverName,omitempty"`
+
+	// Secret containing the CA bundle in the key of ca.crt.
+	// Default to the system CA bundle of the proxy image.
+	// +optional
+	CASecretRef *corev1.LocalObjectReference `json:"caSecretRef,omitempty"`
+
+	// Secret containing the client certificate in the keys of tls.crt and tls.key.
+	// If set, the proxy presents the client certificate for mutual TLS.
+	// +optional
+	ClientCertificateSecretRef *corev1.LocalObjectReference `json:"clientCertificateSecretRef,omitempty"`
+
+	// If true, the server certificate is not verified.
+	// +optional
+	InsecureSkipVerify bool `json:"insecureSkipVerify,omitempty"`
+}
+
+// Keys of the TLS secrets.
+const (
+	TLSSecretKeyCA          = "ca.crt"
+	TLSSecretKeyCertificate = corev1.TLSCertKey
+	TLSSecretKeyPrivateKey  = corev1.TLSPrivateKeyKey
+)
+
+// GetSecretNames returns the names of the secrets referenced by the TLS settings and HTTP headers.
+func (t *Tunnel) GetSecretNames() []string {
+	var names []string
+	add := func(name string) {
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	if t.Spec.TLS != nil {
+		if t.Spec.TLS.CASecretRef != nil {
+			add(t.Spec.TLS.CASecretRef.Name)
+		}
+		if t.Spec.TLS.ClientCertificateSecretRef != nil {
+			add(t.Spec.TLS.ClientCertificateSecretRef.Name)
+		}
+	}
+	if t.Spec.HTTP != nil {
+		for _, header := range t.Spec.HTTP.RequestHeaders {
+			if header.SecretKeyRef != nil {
+				add(header.SecretKeyRef.Name)
+			}
+		}
+	}
+	return names
+}
+
+// TunnelHTTP defines the HTTP mode of a tunnel.
+type TunnelHTTP struct {
+	// Host header sent to the destination.
+	// Default to the host of the first destination, with the port if it is not the default port.
+	// +optional
+	HostRewrite string `json:"hostRewrite,omitempty"`
+
+	// Headers added to each request, such as an API key.
+	// A header of the same name in the request is overwritten.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	RequestHeaders []TunnelHTTPHeader `json:"requestHeaders,omitempty"`
+}
+
+// TunnelHTTPHeader represents a header added to each request.
+// Either value or secretKeyRef must be set.
+type TunnelHTTPHeader struct {
+	// Name of the header.
+	// +kubebuilder:validation:MinLength=1
+	Name string `json:"name"`
+
+	// Value of the header.
+	// +optional
+	Value string `json:"value,omitempty"`
+
+	// Key of a Secret in the namespace of the proxy, which contains the value of the header.
+	// The value is not written to the ConfigMap of the proxy.
+	// +optional
+	SecretKeyRef *corev1.SecretKeySelector `json:"secretKeyRef,omitempty"`
+}
+
+// TunnelDestination represents a destination host of a tunnel.
+type TunnelDestination struct {
+	// Destination hostname.
+	// +kubebuilder:validation:MinLength=1
+	Host string `json:"host"`
+
+	// Relative weight of the connections among the destinations of the same priority.
+	// Default to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+
+	// Priority of this destination, where 0 is the highest.
+	// The connections are sent to a lower priority only when the destinations of higher priorities are unavailable.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+}
+
+// TunnelPort represents a destination port of a tunnel.
+type TunnelPort struct {
+	// Name of this port.
+	// This is used as the port name of the service.
+	// +kubebuilder:validation:MaxLength=15
+	// +kubebuilder:validation:Pattern=`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`
+	Name string `json:"name"`
+
+	// Destination port.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Transit port of the proxy for this port.
+	// If set, the proxy controller allocates this port instead of an automatically allocated one.
+	// If the port is requested by an older tunnel or out of the range of the proxy,
+	// no port is allocated and the PortAllocated condition shows the reason.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	TransitPort *int32 `json:"transitPort,omitempty"`
+}
+
+// TunnelTransitPort represents a transit port allocated for a destination port.
+type TunnelTransitPort struct {
+	// Name of the destination port.
+	Name string `json:"name"`
+
+	// Transit port of the proxy.
+	TransitPort int32 `json:"transitPort"`
 }
 
 // TunnelStatus defines the observed state of Tunnel
@@ -40,17 +422,129 @@ type TunnelStatus struct {
 	// +optional
 	TransitPort *int32 `json:"transitPort,omitempty"`
 
+	// Transit ports of the proxy, corresponding to spec.ports.
+	// This value is automatically set by proxy controller. Do not set this manually.
+	// +listType=map
+	// +listMapKey=name
+	// +optional
+	TransitPorts []TunnelTransitPort `json:"transitPorts,omitempty"`
+
 	// True if the service is created.
 	// +optional
 	Ready bool `json:"ready,omitempty"`
+
+	// The generation observed by tunnel controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Conditions represent the latest available observations of the tunnel.
+	// +listType=map
+	// +listMapKey=type
+	// +patchStrategy=merge
+	// +patchMergeKey=type
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty" patchStrategy:"merge" patchMergeKey:"type"`
+
+	// Time when this tunnel expires, computed from spec.expiresAt and spec.ttl.
+	// +optional
+	ExpiresAt *metav1.Time `json:"expiresAt,omitempty"`
+
+	// Remaining time until this tunnel expires, such as 2d, 5h or 30m.
+	// It is rounded down to the unit, and updated by tunnel controller when the value changes.
+	// +optional
+	Remaining string `json:"remaining,omitempty"`
+
+	// Traffic statistics of this tunnel.
+	// This value is periodically updated by proxy controller.
+	// +optional
+	Traffic *TunnelTraffic `json:"traffic,omitempty"`
+}
+
+// Condition types of Tunnel.
+const (
+	// TunnelConditionReady is true when the tunnel is available via the service.
+	TunnelConditionReady = "Ready"
+	// TunnelConditionProxyFound is true when the referenced proxy exists.
+	TunnelConditionProxyFound = "ProxyFound"
+	// TunnelConditionPortAllocated is true when a transit port is allocated by proxy controller.
+	TunnelConditionPortAllocated = "PortAllocated"
+	// TunnelConditionServiceReady is true when the service is reconciled.
+	TunnelConditionServiceReady = "ServiceReady"
+	// TunnelConditionProxyReady is true when the referenced proxy is ready.
+	TunnelConditionProxyReady = "ProxyReady"
+	// TunnelConditionPolicyDenied is true when the destination is denied by a TunnelPolicy.
+	// The proxy does not route the tunnel while it is denied.
+	TunnelConditionPolicyDenied = "PolicyDenied"
+	// TunnelConditionUpstreamReachable is true when a destination passes the health check from the proxy.
+	// It is set only if the health check is enabled.
+	TunnelConditionUpstreamReachable = "UpstreamReachable"
+	// TunnelConditionExpired is true when the tunnel is expired.
+	// The proxy does not route the tunnel after it is expired.
+	TunnelConditionExpired = "Expired"
+)
+
+// Condition reasons of Tunnel.
+const (
+	TunnelReasonReady                  = "Ready"
+	TunnelReasonProxyFound             = "ProxyFound"
+	TunnelReasonProxyNotFound          = "ProxyNotFound"
+	TunnelReasonPortAllocated          = "PortAllocated"
+	TunnelReasonPortNotAllocated       = "PortNotAllocated"
+	TunnelReasonPortPoolExhausted      = "PortPoolExhausted"
+	TunnelReasonTransitPortConflict    = "TransitPortConflict"
+	TunnelReasonTransitPortOutOfRange  = "TransitPortOutOfRange"
+	TunnelReasonServiceReady           = "ServiceReady"
+	TunnelReasonServiceError           = "ServiceError"
+	TunnelReasonProxyReady             = "ProxyReady"
+	TunnelReasonProxyNotReady          = "ProxyNotReady"
+	TunnelReasonPolicyDenied           = "PolicyDenied"
+	TunnelReasonPolicyAllowed          = "PolicyAllowed"
+	TunnelReasonExpired                = "Expired"
+	TunnelReasonNotExpired             = "NotExpired"
+	TunnelReasonUpstreamReachable      = "UpstreamReachable"
+	TunnelReasonUpstreamUnreachable    = "UpstreamUnreachable"
+	TunnelReasonTLSPassthrough         = "TLSPassthrough"
+	TunnelReasonTLSPassthroughDisabled = "TLSPassthroughDisabled"
+	TunnelReasonServerNameConflict     = "ServerNameConflict"
+)
+
+// TunnelTraffic represents the traffic statistics of a tunnel.
+// The values are summed over the pods of the proxy, and reset when a pod is restarted.
+type TunnelTraffic struct {
+	// Number of the active connections.
+	// It is not available for a tunnel of TLS passthrough, because the listener is shared by the tunnels.
+	// +optional
+	ActiveConnections *int64 `json:"activeConnections,omitempty"`
+
+	// Total number of the connections.
+	TotalConnections int64 `json:"totalConnections"`
+
+	// Total bytes received from the clients.
+	ReceivedBytes int64 `json:"receivedBytes"`
+
+	// Total bytes sent to the clients.
+	SentBytes int64 `json:"sentBytes"`
+
+	// Total number of the connections denied by spec.allowedSourceRanges.
+	// +optional
+	DeniedConnections int64 `json:"deniedConnections,omitempty"`
+
+	// Last time when the tunnel was observed in use.
+	// +optional
+	LastConnectionTime *metav1.Time `json:"lastConnectionTime,omitempty"`
 }
 
 //+kubebuilder:object:root=true
 //+kubebuilder:subresource:status
 // +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.ready`
+// +kubebuilder:printcolumn:name="Reason",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].reason`
 // +kubebuilder:printcolumn:name="Host",type=string,JSONPath=`.spec.host`
 // +kubebuilder:printcolumn:name="Port",type=integer,JSONPath=`.spec.port`
 // +kubebuilder:printcolumn:name="Proxy",type=string,JSONPath=`.spec.proxy.name`
+// +kubebuilder:printcolumn:name="Remaining",type=string,JSONPath=`.status.remaining`
+// +kubebuilder:printcolumn:name="Expires At",type=string,JSONPath=`.status.expiresAt`,priority=1
+// +kubebuilder:printcolumn:name="Connections",type=integer,JSONPath=`.status.traffic.activeConnections`,priority=1
+// +kubebuilder:printcolumn:name="Last Connection",type=date,JSONPath=`.status.traffic.lastConnectionTime`,priority=1
 
 // Tunnel is the Schema for the tunnels API
 type Tunnel struct {
@@ -78,6 +572,104 @@ type TunnelList struct {
 	Items           []Tunnel `json:"items"`
 }
 
+// AnnotationKeyDefaultProxy is the annotation of a namespace to set the default proxy of tunnels.
+const AnnotationKeyDefaultProxy = "ktunnels.int128.github.io/default-proxy"
+
+// SinglePortName is the name of the port when spec.port is used.
+const SinglePortName = "proxy"
+
+// IsMultiPort returns true if spec.ports is set.
+func (t *Tunnel) IsMultiPort() bool {
+	return len(t.Spec.Ports) > 0
+}
+
+// GetPorts returns the destination ports.
+// If spec.ports is not set, it returns spec.port with the name of SinglePortName.
+func (t *Tunnel) GetPorts() []TunnelPort {
+	if t.IsMultiPort() {
+		return t.Spec.Ports
+	}
+	return []TunnelPort{{Name: SinglePortName, Port: t.Spec.Port, TransitPort: t.Spec.TransitPort}}
+}
+
+// GetDestinations returns the destination hosts.
+// If spec.destinations is not set, it returns spec.host.
+func (t *Tunnel) GetDestinations() []TunnelDestination {
+	if len(t.Spec.Destinations) > 0 {
+		return t.Spec.Destinations
+	}
+	return []TunnelDestination{{Host: t.Spec.Host}}
+}
+
+// GetTransitPort returns the transit port of the destination port.
+// It returns nil if the transit port is not allocated.
+func (t *Tunnel) GetTransitPort(name string) *int32 {
+	if !t.IsMultiPort() {
+		if name != SinglePortName {
+			return nil
+		}
+		return t.Status.TransitPort
+	}
+	for _, transitPort := range t.Status.TransitPorts {
+		if transitPort.Name == name {
+			p := transitPort.TransitPort
+			return &p
+		}
+	}
+	return nil
+}
+
+// SetTransitPort sets the transit port of the destination port.
+// If nil is given, the transit port is removed.
+func (t *Tunnel) SetTransitPort(name string, transitPort *int32) {
+	if !t.IsMultiPort() {
+		t.Status.TransitPort = transitPort
+		return
+	}
+	transitPorts := make([]TunnelTransitPort, 0, len(t.Status.TransitPorts)+1)
+	for _, p := range t.Status.TransitPorts {
+		if p.Name != name {
+			transitPorts = append(transitPorts, p)
+		}
+	}
+	if transitPort != nil {
+		transitPorts = append(transitPorts, TunnelTransitPort{Name: name, TransitPort: *transitPort})
+	}
+	t.Status.TransitPorts = transitPorts
+}
+
+// IsTransitPortAllocated returns true if the transit ports are allocated for all destination ports.
+func (t *Tunnel) IsTransitPortAllocated() bool {
+	for _, port := range t.GetPorts() {
+		if t.GetTransitPort(port.Name) == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// GetExpirationTime returns the time when the tunnel expires.
+// It returns nil if neither spec.expiresAt nor spec.ttl is set.
+func (t *Tunnel) GetExpirationTime() *metav1.Time {
+	var expiresAt *metav1.Time
+	if t.Spec.ExpiresAt != nil {
+		expiresAt = t.Spec.ExpiresAt.DeepCopy()
+	}
+	if t.Spec.TTL != nil {
+		deadline := metav1.NewTime(t.CreationTimestamp.Add(t.Spec.TTL.Duration))
+		if expiresAt == nil || deadline.Before(expiresAt) {
+			expiresAt = &deadline
+		}
+	}
+	return expiresAt
+}
+
+// IsExpired returns true if the tunnel is expired at the time.
+func (t *Tunnel) IsExpired(now time.Time) bool {
+	expiresAt := t.GetExpirationTime()
+	return expiresAt != nil && !now.Before(expiresAt.Time)
+}
+
 func init() {
 	SchemeBuilder.Register(&Tunnel{}, &TunnelList{})
 }
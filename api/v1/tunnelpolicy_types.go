@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TunnelPolicySpec defines the destinations which tunnels are allowed to connect to.
+type TunnelPolicySpec struct {
+	// Namespaces to which this policy applies.
+	// If not set, this policy applies to all namespaces.
+	// +optional
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+
+	// Destinations which tunnels are allowed to connect to.
+	// If set, a destination must match any rule of the policies which apply to the namespace.
+	// If no policy has allow rules, any destination is allowed unless it is denied.
+	// +optional
+	Allow []TunnelPolicyRule `json:"allow,omitempty"`
+
+	// Destinations which tunnels are denied to connect to.
+	// A deny rule takes precedence over allow rules.
+	// +optional
+	Deny []TunnelPolicyRule `json:"deny,omitempty"`
+}
+
+// TunnelPolicyRule represents a set of destinations.
+// A destination matches the rule if both the host and port match.
+type TunnelPolicyRule struct {
+	// Glob patterns of the destination hostname, such as *.staging.example.com.
+	// A leading wildcard label matches one or more labels, and other wildcards match within a label.
+	// If neither hosts nor cidrs is set, any host matches.
+	// +optional
+	Hosts []string `json:"hosts,omitempty"`
+
+	// CIDRs of the destination IP address, such as 10.0.0.0/8.
+	// A hostname is resolved by the controller and checked again periodically.
+	// It matches a deny rule if any address is in the CIDRs,
+	// and matches an allow rule only if all addresses are in the CIDRs.
+	// If a hostname cannot be resolved, it is denied by a deny rule with CIDRs.
+	// If a CIDR is invalid, all tunnels to which the policy applies are denied.
+	// +optional
+	CIDRs []string `json:"cidrs,omitempty"`
+
+	// Ranges of the destination port.
+	// If not set, any port matches.
+	// +optional
+	Ports []TunnelPolicyPortRange `json:"ports,omitempty"`
+}
+
+// TunnelPolicyPortRange represents a range of ports.
+// +kubebuilder:validation:XValidation:rule="!has(self.endPort) || self.port <= self.endPort",message="endPort must be greater than or equal to port"
+type TunnelPolicyPortRange struct {
+	// First port of the range.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	Port int32 `json:"port"`
+
+	// Last port of the range.
+	// If not set, the range consists of port only.
+	// +kubebuilder:validation:Minimum=1
+	// +kubebuilder:validation:Maximum=65535
+	// +optional
+	EndPort *int32 `json:"endPort,omitempty"`
+}
+
+//+kubebuilder:object:root=true
+//+kubebuilder:resource:scope=Cluster
+
+// TunnelPolicy is the Schema for the tunnelpolicies API.
+// It restricts the destinations of tunnels in the cluster.
+type TunnelPolicy struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// metadata is a standard object metadata
+	// +optional
+	metav1.ObjectMeta `json:"metadata,omitzero"`
+
+	// spec defines the destinations which tunnels are allowed to connect to
+	// +required
+	Spec TunnelPolicySpec `json:"spec"`
+}
+
+//+kubebuilder:object:root=true
+
+// TunnelPolicyList contains a list of TunnelPolicy
+type TunnelPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitzero"`
+	Items           []TunnelPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&TunnelPolicy{}, &TunnelPolicyList{})
+}
@@ -17,18 +17,28 @@ limitations under the License.
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"flag"
+	"net"
 	"os"
+	"slices"
+	"strings"
+	"time"
 
 	// Import all Kubernetes client auth plugins (e.g. Azure, GCP, OIDC, etc.)
 	// to ensure that exec-entrypoint and run can make use of them.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/labels"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/selection"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 	"sigs.k8s.io/controller-runtime/pkg/metrics/filters"
@@ -37,6 +47,10 @@ import (
 
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	"github.com/int128/ktunnels/internal/controller"
+	"github.com/int128/ktunnels/internal/envoy"
+	"github.com/int128/ktunnels/internal/transit"
+	webhookv1 "github.com/int128/ktunnels/internal/webhook/v1"
+	"github.com/int128/ktunnels/internal/xds"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -61,6 +75,13 @@ func main() {
 	var probeAddr string
 	var secureMetrics bool
 	var enableHTTP2 bool
+	var xdsAddr, xdsAdvertiseAddr string
+	var tunnelStatsInterval time.Duration
+	var transitPortRange string
+	var adminIngressNamespaces string
+	var enableWebhooks bool
+	var defaultProxyName string
+	var createDefaultProxy bool
 	var tlsOpts []func(*tls.Config)
 	flag.StringVar(&metricsAddr, "metrics-bind-address", "0", "The address the metrics endpoint binds to. "+
 		"Use :8443 for HTTPS or :8080 for HTTP, or leave as 0 to disable the metrics service.")
@@ -79,6 +100,27 @@ func main() {
 	flag.StringVar(&metricsCertKey, "metrics-cert-key", "tls.key", "The name of the metrics server key file.")
 	flag.BoolVar(&enableHTTP2, "enable-http2", false,
 		"If set, HTTP/2 will be enabled for the metrics and webhook servers")
+	flag.StringVar(&xdsAddr, "xds-bind-address", "0", "The address the xDS server binds to. "+
+		"Use :18000 to serve the Envoy configuration via xDS, "+
+		"or leave as 0 to write the Envoy configuration into ConfigMap instead.")
+	flag.StringVar(&xdsAdvertiseAddr, "xds-advertise-address", "",
+		"The address of the xDS server which Envoy connects to, e.g. ktunnels-xds-service.ktunnels-system.svc:18000. "+
+			"Required if the xDS server is enabled.")
+	flag.DurationVar(&tunnelStatsInterval, "tunnel-stats-interval", time.Minute,
+		"The interval to collect the traffic statistics and health of tunnels from the proxy pods. "+
+			"Set 0 to disable the collection.")
+	flag.StringVar(&transitPortRange, "transit-port-range", transit.DefaultRange.String(),
+		"The range of transit ports in the form of MIN-MAX, if a proxy does not specify it.")
+	flag.StringVar(&adminIngressNamespaces, "admin-ingress-namespaces", "ktunnels-system",
+		"The comma-separated namespaces allowed to connect to the admin port of the proxy pods by the NetworkPolicy, "+
+			"such as the namespaces of the controller and monitoring. Set empty to deny them all.")
+	flag.BoolVar(&enableWebhooks, "enable-webhooks", false,
+		"If set, the admission webhooks are served. The webhook certificate is required.")
+	flag.StringVar(&defaultProxyName, "default-proxy-name", "",
+		"The name of the default proxy, if a tunnel does not specify it and the namespace does not have the annotation "+
+			ktunnelsv1.AnnotationKeyDefaultProxy+".")
+	flag.BoolVar(&createDefaultProxy, "create-default-proxy", false,
+		"If set, the default proxy is created when a tunnel references it for the first time.")
 	opts := zap.Options{
 		Development: true,
 	}
@@ -87,6 +129,12 @@ func main() {
 
 	ctrl.SetLogger(zap.New(zap.UseFlagOptions(&opts)))
 
+	defaultTransitPortRange, err := transit.ParseRange(transitPortRange)
+	if err != nil {
+		setupLog.Error(err, "Invalid flag", "flag", "transit-port-range")
+		os.Exit(1)
+	}
+
 	// if the enable-http2 flag is false (the default), http/2 should be disabled
 	// due to its vulnerabilities. More specifically, disabling http/2 will
 	// prevent from being vulnerable to the HTTP/2 Stream Cancellation and
@@ -154,6 +202,13 @@ func main() {
 		metricsServerOptions.KeyName = metricsCertKey
 	}
 
+	proxyPodRequirement, err := labels.NewRequirement(envoy.PodLabelKeyOfProxy, selection.Exists, nil)
+	if err != nil {
+		setupLog.Error(err, "Failed to create a label selector")
+		os.Exit(1)
+	}
+	proxyPodSelector := labels.NewSelector().Add(*proxyPodRequirement)
+
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:                 scheme,
 		Metrics:                metricsServerOptions,
@@ -161,6 +216,12 @@ func main() {
 		HealthProbeBindAddress: probeAddr,
 		LeaderElection:         enableLeaderElection,
 		LeaderElectionID:       "a39e7441.int128.github.io",
+		Cache: cache.Options{
+			ByObject: map[client.Object]cache.ByObject{
+				// watch only the pods of proxies to collect the statistics
+				&corev1.Pod{}: {Label: proxyPodSelector},
+			},
+		},
 		// LeaderElectionReleaseOnCancel defines if the leader should step down voluntarily
 		// when the Manager ends. This requires the binary to immediately end when the
 		// Manager is stopped, otherwise, this setting is unsafe. Setting this significantly
@@ -178,20 +239,71 @@ func main() {
 		os.Exit(1)
 	}
 
+	var xdsServer *xds.Server
+	if xdsAddr != "0" {
+		if xdsAdvertiseAddr == "" {
+			setupLog.Error(nil, "--xds-advertise-address is required if the xDS server is enabled")
+			os.Exit(1)
+		}
+		xdsServer = xds.NewServer(xdsAddr, xdsAdvertiseAddr, mgr.GetClient(), envoy.PodLabelKeyOfProxy)
+		if err := mgr.Add(xdsServer); err != nil {
+			setupLog.Error(err, "Failed to add the xDS server")
+			os.Exit(1)
+		}
+	}
+
+	if err := controller.SetupFieldIndexes(context.Background(), mgr); err != nil {
+		setupLog.Error(err, "Failed to set up field indexes")
+		os.Exit(1)
+	}
 	if err = (&controller.ProxyReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorder("proxy-controller"),
+		XDSServer: xdsServer,
+		APIReader: mgr.GetAPIReader(),
+		Resolver:  net.DefaultResolver,
+
+		DefaultTransitPortRange: defaultTransitPortRange,
+		AdminIngressNamespaces: slices.DeleteFunc(strings.Split(adminIngressNamespaces, ","), func(namespace string) bool {
+			return namespace == ""
+		}),
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "Failed to create controller", "controller", "Proxy")
 		os.Exit(1)
 	}
 	if err = (&controller.TunnelReconciler{
-		Client: mgr.GetClient(),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Scheme:    mgr.GetScheme(),
+		Recorder:  mgr.GetEventRecorder("tunnel-controller"),
+		APIReader: mgr.GetAPIReader(),
+
+		CreateDefaultProxy: createDefaultProxy,
+		DefaultProxyName:   defaultProxyName,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "Failed to create controller", "controller", "Tunnel")
 		os.Exit(1)
 	}
+	if tunnelStatsInterval > 0 {
+		if err := mgr.Add(&controller.StatsCollector{
+			Client:   mgr.GetClient(),
+			Recorder: mgr.GetEventRecorder("stats-collector"),
+			Interval: tunnelStatsInterval,
+		}); err != nil {
+			setupLog.Error(err, "Failed to add the stats collector")
+			os.Exit(1)
+		}
+	}
+	if enableWebhooks {
+		if err := webhookv1.SetupProxyWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "Failed to create webhook", "webhook", "Proxy")
+			os.Exit(1)
+		}
+		if err := webhookv1.SetupTunnelWebhookWithManager(mgr, defaultProxyName); err != nil {
+			setupLog.Error(err, "Failed to create webhook", "webhook", "Tunnel")
+			os.Exit(1)
+		}
+	}
 	// +kubebuilder:scaffold:builder
 
 	if err := mgr.AddHealthzCheck("healthz", healthz.Ping); err != nil {
@@ -0,0 +1,32 @@
+package controller
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// setCondition sets the condition of the object.
+// If the status or reason is changed, it records an event to the object.
+// It returns true if the condition is changed.
+func setCondition(recorder events.EventRecorder, obj client.Object, conditions *[]metav1.Condition, condition metav1.Condition) bool {
+	condition.ObservedGeneration = obj.GetGeneration()
+	var previous metav1.Condition
+	if c := meta.FindStatusCondition(*conditions, condition.Type); c != nil {
+		previous = *c
+	}
+	if !meta.SetStatusCondition(conditions, condition) {
+		return false
+	}
+	if previous.Status == condition.Status && previous.Reason == condition.Reason {
+		return true
+	}
+	eventType := corev1.EventTypeNormal
+	if condition.Status != metav1.ConditionTrue {
+		eventType = corev1.EventTypeWarning
+	}
+	recorder.Eventf(obj, nil, eventType, condition.Reason, condition.Type, "%s", condition.Message)
+	return true
+}
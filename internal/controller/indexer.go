@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+)
+
+const (
+	proxyNameKey  = ".spec.proxy.name"
+	secretNameKey = ".spec.secretNames"
+)
+
+// SetupFieldIndexes registers the field indexes shared by the controllers.
+// This must be called before the controllers are set up.
+func SetupFieldIndexes(ctx context.Context, mgr ctrl.Manager) error {
+	if err := mgr.GetFieldIndexer().IndexField(ctx,
+		&ktunnelsv1.Tunnel{},
+		proxyNameKey,
+		mapTunnelToProxyName,
+	); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx,
+		&ktunnelsv1.Tunnel{},
+		secretNameKey,
+		mapTunnelToSecretNames,
+	); err != nil {
+		return err
+	}
+	return nil
+}
+
+func mapTunnelToProxyName(obj client.Object) []string {
+	tunnel, ok := obj.(*ktunnelsv1.Tunnel)
+	if !ok {
+		return nil
+	}
+	return []string{tunnel.Spec.Proxy.Name}
+}
+
+func mapTunnelToSecretNames(obj client.Object) []string {
+	tunnel, ok := obj.(*ktunnelsv1.Tunnel)
+	if !ok {
+		return nil
+	}
+	return tunnel.GetSecretNames()
+}
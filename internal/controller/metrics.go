@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"github.com/int128/ktunnels/internal/transit"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var (
+	transitPortsAllocated = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ktunnels_proxy_transit_ports_allocated",
+		Help: "Number of the transit ports allocated in the range of the proxy.",
+	}, []string{"namespace", "proxy"})
+
+	transitPortsCapacity = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ktunnels_proxy_transit_ports_capacity",
+		Help: "Number of the transit ports in the range of the proxy.",
+	}, []string{"namespace", "proxy"})
+
+	transitPortsExhaustedTunnels = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "ktunnels_proxy_transit_ports_exhausted_tunnels",
+		Help: "Number of the tunnels waiting for a transit port of the proxy.",
+	}, []string{"namespace", "proxy"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(transitPortsAllocated, transitPortsCapacity, transitPortsExhaustedTunnels)
+}
+
+func setTransitPortPoolMetrics(proxyKey types.NamespacedName, allocation transit.Result) {
+	transitPortsAllocated.WithLabelValues(proxyKey.Namespace, proxyKey.Name).Set(float64(allocation.Allocated))
+	transitPortsCapacity.WithLabelValues(proxyKey.Namespace, proxyKey.Name).Set(float64(allocation.Capacity))
+	transitPortsExhaustedTunnels.WithLabelValues(proxyKey.Namespace, proxyKey.Name).Set(float64(allocation.CountErrors(transit.ErrPortPoolExhausted)))
+}
+
+func deleteTransitPortPoolMetrics(proxyKey types.NamespacedName) {
+	transitPortsAllocated.DeleteLabelValues(proxyKey.Namespace, proxyKey.Name)
+	transitPortsCapacity.DeleteLabelValues(proxyKey.Namespace, proxyKey.Name)
+	transitPortsExhaustedTunnels.DeleteLabelValues(proxyKey.Namespace, proxyKey.Name)
+}
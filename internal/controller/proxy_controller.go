@@ -18,15 +18,29 @@ package controller
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
+	"time"
 
+	resourcev3 "github.com/envoyproxy/go-control-plane/pkg/resource/v3"
 	"github.com/int128/ktunnels/internal/envoy"
+	"github.com/int128/ktunnels/internal/policy"
 	"github.com/int128/ktunnels/internal/transit"
+	"github.com/int128/ktunnels/internal/xds"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/utils/ptr"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/client"
@@ -38,22 +52,48 @@ import (
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 )
 
-const (
-	proxyNameKey = ".spec.proxy.name"
-)
-
 // ProxyReconciler reconciles a Proxy object
 type ProxyReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder events.EventRecorder
+
+	// XDSServer serves the Envoy configuration if set.
+	// Otherwise, the configuration is written into the ConfigMap.
+	XDSServer *xds.Server
+
+	// APIReader reads the Secrets without the cache.
+	// The controller watches only the metadata of Secrets.
+	APIReader client.Reader
+
+	// DefaultTransitPortRange is the range of transit ports if a proxy does not specify it.
+	// If zero, transit.DefaultRange is used.
+	DefaultTransitPortRange transit.Range
+
+	// AdminIngressNamespaces are the namespaces allowed to connect to the admin port by the NetworkPolicy,
+	// such as the namespaces of the controller and monitoring.
+	AdminIngressNamespaces []string
+
+	// Resolver resolves the hostnames of the tunnels to check the CIDRs of the tunnel policies.
+	Resolver policy.Resolver
 }
 
+// policyRecheckInterval is the interval to check the tunnel policies again,
+// because the address of a hostname may be changed.
+const policyRecheckInterval = time.Minute
+
 //+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=proxies,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=proxies/status,verbs=get;update;patch
 //+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=proxies/finalizers,verbs=update
+//+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=tunnelpolicies,verbs=get;list;watch
 
 //+kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch;create;update;patch;delete
 //+kubebuilder:rbac:groups=apps,resources=deployments,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=pods,verbs=get;list;watch
+//+kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get;list;watch
+//+kubebuilder:rbac:groups=networking.k8s.io,resources=networkpolicies,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -62,6 +102,12 @@ func (r *ProxyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 
 	var proxy ktunnelsv1.Proxy
 	if err := r.Get(ctx, req.NamespacedName, &proxy); err != nil {
+		if apierrors.IsNotFound(err) {
+			deleteTransitPortPoolMetrics(req.NamespacedName)
+			if r.XDSServer != nil {
+				r.XDSServer.ClearSnapshot(envoy.NodeID(req.NamespacedName))
+			}
+		}
 		return ctrl.Result{}, client.IgnoreNotFound(err)
 	}
 	if !proxy.DeletionTimestamp.IsZero() {
@@ -83,106 +129,596 @@ func (r *ProxyReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl
 		mutableTunnels[i] = &tunnelList.Items[i]
 	}
 
-	if err := r.reconcileTunnels(ctx, mutableTunnels); err != nil {
+	policyErrors, policyRecheck, err := r.checkTunnelPolicies(ctx, proxy, mutableTunnels)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	// a denied or expired tunnel is not routed by the proxy
+	now := time.Now()
+	allowedTunnels := slices.DeleteFunc(slices.Clone(mutableTunnels), func(tunnel *ktunnelsv1.Tunnel) bool {
+		return policyErrors[tunnel] != nil || tunnel.IsExpired(now)
+	})
+	serverNameErrors := envoy.TLSPassthroughConflictsOf(allowedTunnels)
+	allocation, err := r.reconcileTunnels(ctx, proxy, mutableTunnels, allowedTunnels, policyErrors, serverNameErrors)
+	if err != nil {
 		return ctrl.Result{}, err
 	}
 	log.Info("successfully reconciled the tunnels")
 
-	if err := r.reconcileConfigMap(ctx, proxy, mutableTunnels); err != nil {
+	proxyPatch := client.MergeFrom(proxy.DeepCopy())
+	r.reconcileTransitPortPool(&proxy, allocation)
+	deployment, reconcileErr := r.reconcileProxy(ctx, proxy, allowedTunnels)
+	if reconcileErr != nil {
+		r.setCondition(&proxy, ktunnelsv1.ProxyConditionReady, metav1.ConditionFalse, ktunnelsv1.ProxyReasonReconcileError,
+			fmt.Sprintf("Unable to reconcile the proxy: %s", reconcileErr))
+	} else {
+		r.reconcileProxyConditions(&proxy, deployment)
+	}
+	proxy.Status.ObservedGeneration = proxy.Generation
+	proxy.Status.Ready = meta.IsStatusConditionTrue(proxy.Status.Conditions, ktunnelsv1.ProxyConditionReady)
+	if err := r.Status().Patch(ctx, &proxy, proxyPatch); err != nil {
+		log.Error(err, "unable to update the proxy status")
 		return ctrl.Result{}, err
 	}
+	if reconcileErr != nil {
+		return ctrl.Result{}, reconcileErr
+	}
+	log.Info("successfully reconciled the proxy status")
+
+	// reconcile again when the next tunnel expires
+	requeueAfter := untilNextExpiration(mutableTunnels, now)
+	if policyRecheck && (requeueAfter == 0 || policyRecheckInterval < requeueAfter) {
+		requeueAfter = policyRecheckInterval
+	}
+	return ctrl.Result{RequeueAfter: requeueAfter}, nil
+}
+
+// untilNextExpiration returns the duration until the earliest tunnel expires.
+// An already expired tunnel is ignored.
+// It returns zero if no tunnel will expire.
+func untilNextExpiration(tunnels []*ktunnelsv1.Tunnel, now time.Time) time.Duration {
+	var next time.Duration
+	for _, tunnel := range tunnels {
+		expiresAt := tunnel.GetExpirationTime()
+		if expiresAt == nil || !now.Before(expiresAt.Time) {
+			continue
+		}
+		if d := expiresAt.Sub(now); next == 0 || d < next {
+			next = d
+		}
+	}
+	return next
+}
+
+// reconcileProxy reconciles the resources of the proxy.
+func (r *ProxyReconciler) reconcileProxy(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (*appsv1.Deployment, error) {
+	log := crlog.FromContext(ctx)
+
+	cm, err := r.reconcileConfigMap(ctx, proxy, mutableTunnels)
+	if err != nil {
+		return nil, err
+	}
 	log.Info("successfully reconciled the config map")
 
-	deployment, err := r.reconcileDeployment(ctx, proxy)
+	if err := r.reconcileSecret(ctx, proxy, mutableTunnels); err != nil {
+		return nil, err
+	}
+	log.Info("successfully reconciled the secret")
+
+	if r.XDSServer != nil {
+		if err := r.reconcileSnapshot(ctx, proxy, mutableTunnels); err != nil {
+			return nil, err
+		}
+		log.Info("successfully reconciled the snapshot")
+	}
+
+	deployment, err := r.reconcileDeployment(ctx, proxy, computeBootstrapHash(cm))
 	if err != nil {
-		return ctrl.Result{}, err
+		return nil, err
 	}
 	log.Info("successfully reconciled the deployment")
 
-	proxyPatch := client.MergeFrom(proxy.DeepCopy())
-	proxy.Status.Ready = deployment.Status.Replicas == deployment.Status.ReadyReplicas
-	if err := r.Status().Patch(ctx, &proxy, proxyPatch); err != nil {
-		log.Error(err, "unable to update the proxy status")
-		return ctrl.Result{}, err
+	if err := r.reconcileProxyService(ctx, proxy, mutableTunnels); err != nil {
+		return nil, err
+	}
+	log.Info("successfully reconciled the service")
+
+	if err := r.reconcileProxyNetworkPolicy(ctx, proxy, mutableTunnels); err != nil {
+		return nil, err
+	}
+	log.Info("successfully reconciled the network policy")
+	return deployment, nil
+}
+
+// reconcileProxyNetworkPolicy reconciles the NetworkPolicy of the proxy pods.
+// If it is disabled, the NetworkPolicy owned by the proxy is deleted.
+func (r *ProxyReconciler) reconcileProxyNetworkPolicy(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	npKey := types.NamespacedName{Namespace: proxy.Namespace, Name: fmt.Sprintf("ktunnels-proxy-%s", proxy.Name)}
+	log := crlog.FromContext(ctx, "networkPolicy", npKey)
+
+	var np networkingv1.NetworkPolicy
+	if err := r.Get(ctx, npKey, &np); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to fetch the network policy")
+			return err
+		}
+		if !proxy.Spec.NetworkPolicy.Enabled {
+			return nil
+		}
+		np, err := r.newNetworkPolicy(npKey, proxy, mutableTunnels)
+		if err != nil {
+			log.Error(err, "unable to generate a network policy")
+			return err
+		}
+		if err := ctrl.SetControllerReference(&proxy, &np, r.Scheme); err != nil {
+			log.Error(err, "unable to set a controller reference")
+			return err
+		}
+		if err := r.Create(ctx, &np); err != nil {
+			log.Error(err, "unable to create a network policy")
+			return err
+		}
+		log.Info("created a network policy")
+		return nil
+	}
+
+	if !proxy.Spec.NetworkPolicy.Enabled {
+		if !metav1.IsControlledBy(&np, &proxy) {
+			return nil
+		}
+		if err := r.Delete(ctx, &np); err != nil {
+			log.Error(err, "unable to delete the network policy")
+			return client.IgnoreNotFound(err)
+		}
+		log.Info("deleted the network policy")
+		return nil
+	}
+
+	npTemplate, err := r.newNetworkPolicy(npKey, proxy, mutableTunnels)
+	if err != nil {
+		log.Error(err, "unable to generate a network policy")
+		return err
+	}
+	npPatch := client.MergeFrom(np.DeepCopy())
+	np.Spec = npTemplate.Spec
+	if err := ctrl.SetControllerReference(&proxy, &np, r.Scheme); err != nil {
+		log.Error(err, "unable to set a controller reference")
+		return err
+	}
+	if err := r.Patch(ctx, &np, npPatch); err != nil {
+		log.Error(err, "unable to update the network policy")
+		return err
+	}
+	log.Info("updated the network policy")
+	return nil
+}
+
+func (r *ProxyReconciler) newNetworkPolicy(npKey types.NamespacedName, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (networkingv1.NetworkPolicy, error) {
+	var xdsAddress string
+	if r.XDSServer != nil {
+		xdsAddress = r.XDSServer.AdvertiseAddress
 	}
-	log.Info("successfully reconciled the the proxy status")
-	return ctrl.Result{}, nil
+	return envoy.NewNetworkPolicy(npKey, proxy, mutableTunnels, xdsAddress, r.AdminIngressNamespaces)
 }
 
-func (r *ProxyReconciler) reconcileTunnels(ctx context.Context, mutableTunnels []*ktunnelsv1.Tunnel) error {
+// reconcileProxyService reconciles the Service which has the ports of all tunnels.
+// If it is disabled, the Service owned by the proxy is deleted.
+func (r *ProxyReconciler) reconcileProxyService(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	svcKey := types.NamespacedName{Namespace: proxy.Namespace, Name: fmt.Sprintf("ktunnels-proxy-%s", proxy.Name)}
+	log := crlog.FromContext(ctx, "service", svcKey)
+
+	var svc corev1.Service
+	if err := r.Get(ctx, svcKey, &svc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			log.Error(err, "unable to fetch the service")
+			return err
+		}
+		if !proxy.Spec.Service.Enabled {
+			return nil
+		}
+		svc, conflicts := envoy.NewProxyService(svcKey, proxy, mutableTunnels)
+		r.recordServicePortConflicts(&proxy, conflicts)
+		if err := ctrl.SetControllerReference(&proxy, &svc, r.Scheme); err != nil {
+			log.Error(err, "unable to set a controller reference")
+			return err
+		}
+		if err := r.Create(ctx, &svc); err != nil {
+			log.Error(err, "unable to create a service")
+			return err
+		}
+		log.Info("created a service")
+		return nil
+	}
+
+	if !proxy.Spec.Service.Enabled {
+		if !metav1.IsControlledBy(&svc, &proxy) {
+			return nil
+		}
+		if err := r.Delete(ctx, &svc); err != nil {
+			log.Error(err, "unable to delete the service")
+			return client.IgnoreNotFound(err)
+		}
+		log.Info("deleted the service")
+		return nil
+	}
+
+	svcTemplate, conflicts := envoy.NewProxyService(svcKey, proxy, mutableTunnels)
+	r.recordServicePortConflicts(&proxy, conflicts)
+	svcPatch := client.MergeFrom(svc.DeepCopy())
+	svc.Spec.Ports = svcTemplate.Spec.Ports
+	svc.Spec.Selector = svcTemplate.Spec.Selector
+	svc.Spec.IPFamilyPolicy = svcTemplate.Spec.IPFamilyPolicy
+	if err := ctrl.SetControllerReference(&proxy, &svc, r.Scheme); err != nil {
+		log.Error(err, "unable to set a controller reference")
+		return err
+	}
+	if err := r.Patch(ctx, &svc, svcPatch); err != nil {
+		log.Error(err, "unable to update the service")
+		return err
+	}
+	log.Info("updated the service")
+	return nil
+}
+
+func (r *ProxyReconciler) recordServicePortConflicts(proxy *ktunnelsv1.Proxy, conflicts []string) {
+	if len(conflicts) == 0 {
+		return
+	}
+	r.Recorder.Eventf(proxy, nil, corev1.EventTypeWarning, "ServicePortConflict", "Reconcile",
+		"Ports of the service are skipped due to conflict: %s", strings.Join(conflicts, ", "))
+}
+
+func (r *ProxyReconciler) reconcileProxyConditions(proxy *ktunnelsv1.Proxy, deployment *appsv1.Deployment) {
+	desiredReplicas := ptr.Deref(deployment.Spec.Replicas, 1)
+	message := fmt.Sprintf("%d of %d replicas are ready", deployment.Status.ReadyReplicas, desiredReplicas)
+	if deployment.Status.ReadyReplicas >= desiredReplicas && deployment.Status.Replicas == deployment.Status.ReadyReplicas {
+		r.setCondition(proxy, ktunnelsv1.ProxyConditionDeploymentReady, metav1.ConditionTrue, ktunnelsv1.ProxyReasonDeploymentReady, message)
+		r.setCondition(proxy, ktunnelsv1.ProxyConditionReady, metav1.ConditionTrue, ktunnelsv1.ProxyReasonReady,
+			fmt.Sprintf("Deployment %s is ready", deployment.Name))
+		return
+	}
+	r.setCondition(proxy, ktunnelsv1.ProxyConditionDeploymentReady, metav1.ConditionFalse, ktunnelsv1.ProxyReasonDeploymentNotReady, message)
+	r.setCondition(proxy, ktunnelsv1.ProxyConditionReady, metav1.ConditionFalse, ktunnelsv1.ProxyReasonNotReady,
+		fmt.Sprintf("Deployment %s is not ready", deployment.Name))
+}
+
+func (r *ProxyReconciler) setCondition(proxy *ktunnelsv1.Proxy, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	setCondition(r.Recorder, proxy, &proxy.Status.Conditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// reconcileTunnels allocates the transit ports to the allowed tunnels,
+// and releases the transit ports of the denied or expired tunnels.
+func (r *ProxyReconciler) reconcileTunnels(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels, allowedTunnels []*ktunnelsv1.Tunnel, policyErrors, serverNameErrors map[*ktunnelsv1.Tunnel]error) (transit.Result, error) {
 	log := crlog.FromContext(ctx)
 
-	allocatedTunnels := transit.AllocatePort(mutableTunnels)
-	if len(allocatedTunnels) == 0 {
+	allocation := transit.AllocatePort(allowedTunnels, r.transitPortOptions(proxy))
+	if len(allocation.Changed) == 0 {
 		log.Info("all tunnels are already allocated")
-		return nil
 	}
-	for _, tunnel := range allocatedTunnels {
-		// only transitPort should be changed
+	transitPortChanged := make(map[*ktunnelsv1.Tunnel]bool)
+	for _, tunnel := range allocation.Changed {
+		transitPortChanged[tunnel] = true
+	}
+	for _, tunnel := range mutableTunnels {
+		routed := slices.Contains(allowedTunnels, tunnel)
+		if !routed && (tunnel.Status.TransitPort != nil || tunnel.Status.TransitPorts != nil) {
+			log.Info("releasing the transit ports of the tunnel", "tunnel", tunnel.Name)
+			tunnel.Status.TransitPort = nil
+			tunnel.Status.TransitPorts = nil
+			transitPortChanged[tunnel] = true
+		}
+		allocationErr := allocation.Errors[tunnel]
+		if allocationErr != nil {
+			log.Info("unable to allocate a transit port", "tunnel", tunnel.Name, "error", allocationErr.Error())
+		}
+		if serverNameErr := serverNameErrors[tunnel]; serverNameErr != nil {
+			log.Info("tunnel conflicts with another tunnel by the server name", "tunnel", tunnel.Name, "error", serverNameErr.Error())
+		}
+		portChanged := r.reconcilePortAllocatedCondition(tunnel, routed, allocationErr, serverNameErrors[tunnel])
+		policyChanged := r.reconcilePolicyDeniedCondition(tunnel, policyErrors[tunnel])
+		if !transitPortChanged[tunnel] && !portChanged && !policyChanged {
+			continue
+		}
+		// only transitPort, PortAllocated and PolicyDenied condition should be changed
 		if err := r.Status().Update(ctx, tunnel); err != nil {
 			log.Error(err, "unable to update the tunnel", "tunnel", tunnel.Name)
-			return err
+			return transit.Result{}, err
 		}
 		log.Info("updated the tunnel", "tunnel", tunnel.Name)
 	}
-	return nil
+	return allocation, nil
 }
 
-func (r *ProxyReconciler) reconcileConfigMap(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+// checkTunnelPolicies returns the errors of the tunnels denied by the tunnel policies.
+// It also returns true if the policies should be checked again, because a hostname is resolved.
+func (r *ProxyReconciler) checkTunnelPolicies(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (map[*ktunnelsv1.Tunnel]error, bool, error) {
+	log := crlog.FromContext(ctx)
+
+	var policyList ktunnelsv1.TunnelPolicyList
+	if err := r.List(ctx, &policyList); err != nil {
+		log.Error(err, "unable to fetch tunnel policies")
+		return nil, false, err
+	}
+	if len(policyList.Items) == 0 {
+		return nil, false, nil
+	}
+	var namespace corev1.Namespace
+	if err := r.APIReader.Get(ctx, types.NamespacedName{Name: proxy.Namespace}, &namespace); err != nil {
+		log.Error(err, "unable to fetch the namespace")
+		return nil, false, err
+	}
+	policyErrors := make(map[*ktunnelsv1.Tunnel]error)
+	for _, tunnel := range mutableTunnels {
+		if err := policy.Check(ctx, r.Resolver, policyList.Items, namespace, tunnel); err != nil {
+			log.Info("tunnel is denied by the policy", "tunnel", tunnel.Name, "error", err.Error())
+			policyErrors[tunnel] = err
+		}
+	}
+	return policyErrors, policy.HasCIDRs(policyList.Items), nil
+}
+
+// reconcilePolicyDeniedCondition sets the PolicyDenied condition of the tunnel.
+// The condition is not added to a tunnel which has never been denied.
+func (r *ProxyReconciler) reconcilePolicyDeniedCondition(tunnel *ktunnelsv1.Tunnel, policyErr error) bool {
+	if policyErr != nil {
+		return setCondition(r.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+			Type:    ktunnelsv1.TunnelConditionPolicyDenied,
+			Status:  metav1.ConditionTrue,
+			Reason:  ktunnelsv1.TunnelReasonPolicyDenied,
+			Message: fmt.Sprintf("Tunnel is denied: %s", policyErr),
+		})
+	}
+	if meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPolicyDenied) == nil {
+		return false
+	}
+	return setCondition(r.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+		Type:    ktunnelsv1.TunnelConditionPolicyDenied,
+		Status:  metav1.ConditionFalse,
+		Reason:  ktunnelsv1.TunnelReasonPolicyAllowed,
+		Message: "Tunnel is allowed by the tunnel policies",
+	})
+}
+
+// reconcileTransitPortPool reflects the usage of transit ports to the status and metrics.
+func (r *ProxyReconciler) reconcileTransitPortPool(proxy *ktunnelsv1.Proxy, allocation transit.Result) {
+	proxy.Status.TransitPortPool = &ktunnelsv1.ProxyTransitPortPool{
+		Allocated: allocation.Allocated,
+		Capacity:  allocation.Capacity,
+	}
+	setTransitPortPoolMetrics(types.NamespacedName{Namespace: proxy.Namespace, Name: proxy.Name}, allocation)
+
+	message := fmt.Sprintf("%d of %d transit ports are allocated", allocation.Allocated, allocation.Capacity)
+	if exhausted := allocation.CountErrors(transit.ErrPortPoolExhausted); exhausted > 0 {
+		r.setCondition(proxy, ktunnelsv1.ProxyConditionPortPoolExhausted, metav1.ConditionTrue, ktunnelsv1.ProxyReasonPortPoolExhausted,
+			fmt.Sprintf("%s, and %d tunnel(s) are waiting for a transit port", message, exhausted))
+		return
+	}
+	r.setCondition(proxy, ktunnelsv1.ProxyConditionPortPoolExhausted, metav1.ConditionFalse, ktunnelsv1.ProxyReasonPortPoolAvailable, message)
+}
+
+// transitPortOptions returns the options to allocate transit ports of the proxy.
+// The TLS passthrough port is reserved in addition to the ports of Envoy admin.
+func (r *ProxyReconciler) transitPortOptions(proxy ktunnelsv1.Proxy) transit.Options {
+	portRange := r.DefaultTransitPortRange
+	if portRange == (transit.Range{}) {
+		portRange = transit.DefaultRange
+	}
+	spec := proxy.Spec.TransitPort
+	portRange.Min = ptr.Deref(spec.Min, portRange.Min)
+	portRange.Max = ptr.Deref(spec.Max, portRange.Max)
+	reservedPorts := envoy.ReservedPorts()
+	if tlsPassthroughPort := envoy.TLSPassthroughPortOf(proxy); tlsPassthroughPort != nil {
+		reservedPorts = append(reservedPorts, *tlsPassthroughPort)
+	}
+	return transit.Options{
+		Range:         portRange,
+		Strategy:      spec.Strategy,
+		ReservedPorts: reservedPorts,
+	}
+}
+
+// reconcilePortAllocatedCondition sets the PortAllocated condition of the tunnel.
+// A denied or expired tunnel is not routed, and no transit port is allocated.
+// A TLS passthrough tunnel needs no transit port, but it is not routed if the server name conflicts with another tunnel.
+func (r *ProxyReconciler) reconcilePortAllocatedCondition(tunnel *ktunnelsv1.Tunnel, routed bool, allocationErr, serverNameErr error) bool {
+	condition := metav1.Condition{
+		Type:    ktunnelsv1.TunnelConditionPortAllocated,
+		Status:  metav1.ConditionFalse,
+		Reason:  ktunnelsv1.TunnelReasonPortNotAllocated,
+		Message: "No transit port is available",
+	}
+	switch {
+	case !routed:
+		condition.Message = "No transit port is allocated to a denied or expired tunnel"
+	case tunnel.Spec.TLSPassthrough && serverNameErr != nil:
+		condition.Reason = ktunnelsv1.TunnelReasonServerNameConflict
+		condition.Message = fmt.Sprintf("Unable to route the server name: %s", serverNameErr)
+	case tunnel.Spec.TLSPassthrough:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ktunnelsv1.TunnelReasonTLSPassthrough
+		condition.Message = "No transit port is needed for the TLS passthrough"
+	case errors.Is(allocationErr, transit.ErrTransitPortConflict):
+		condition.Reason = ktunnelsv1.TunnelReasonTransitPortConflict
+		condition.Message = fmt.Sprintf("Unable to allocate the requested transit port: %s", allocationErr)
+	case errors.Is(allocationErr, transit.ErrTransitPortOutOfRange):
+		condition.Reason = ktunnelsv1.TunnelReasonTransitPortOutOfRange
+		condition.Message = fmt.Sprintf("Unable to allocate the requested transit port: %s", allocationErr)
+	case errors.Is(allocationErr, transit.ErrPortPoolExhausted):
+		condition.Reason = ktunnelsv1.TunnelReasonPortPoolExhausted
+		condition.Message = fmt.Sprintf("Unable to allocate a transit port: %s", allocationErr)
+	case tunnel.IsTransitPortAllocated():
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = ktunnelsv1.TunnelReasonPortAllocated
+		condition.Message = "Transit ports are allocated"
+		if !tunnel.IsMultiPort() {
+			condition.Message = fmt.Sprintf("Transit port %d is allocated", *tunnel.Status.TransitPort)
+		}
+	}
+	return setCondition(r.Recorder, tunnel, &tunnel.Status.Conditions, condition)
+}
+
+func (r *ProxyReconciler) reconcileConfigMap(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (*corev1.ConfigMap, error) {
 	cmKey := types.NamespacedName{Namespace: proxy.Namespace, Name: fmt.Sprintf("ktunnels-proxy-%s", proxy.Name)}
 	log := crlog.FromContext(ctx, "configMap", cmKey)
 
+	cmTemplate, err := r.newConfigMap(cmKey, proxy, mutableTunnels)
+	if err != nil {
+		log.Error(err, "unable to generate a config map")
+		return nil, err
+	}
+
 	var cm corev1.ConfigMap
 	if err := r.Get(ctx, cmKey, &cm); err != nil {
 		if apierrors.IsNotFound(err) {
-			cm, err := envoy.NewConfigMap(cmKey, mutableTunnels)
-			if err != nil {
-				log.Error(err, "unable to generate a config map")
-				return err
-			}
+			cm := cmTemplate
 			if err := ctrl.SetControllerReference(&proxy, &cm, r.Scheme); err != nil {
 				log.Error(err, "unable to set a controller reference")
-				return err
+				return nil, err
 			}
 			if err := r.Create(ctx, &cm); err != nil {
 				log.Error(err, "unable to create a config map")
-				return err
+				return nil, err
 			}
 			log.Info("created a config map")
-			return nil
+			return &cm, nil
 		}
 
 		log.Error(err, "unable to fetch the config map")
-		return err
+		return nil, err
 	}
 
-	cmTemplate, err := envoy.NewConfigMap(cmKey, mutableTunnels)
-	if err != nil {
-		log.Error(err, "unable to generate a config map")
-		return err
-	}
 	cmPatch := client.MergeFrom(cm.DeepCopy())
 	cm.Data = cmTemplate.Data
 	if err := ctrl.SetControllerReference(&proxy, &cm, r.Scheme); err != nil {
 		log.Error(err, "unable to set a controller reference")
-		return err
+		return nil, err
 	}
 	if err := r.Patch(ctx, &cm, cmPatch); err != nil {
 		log.Error(err, "unable to update the config map")
-		return err
+		return nil, err
 	}
 	log.Info("updated the config map")
+	return &cm, nil
+}
+
+func (r *ProxyReconciler) newConfigMap(cmKey types.NamespacedName, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (corev1.ConfigMap, error) {
+	if r.XDSServer != nil {
+		nodeID := envoy.NodeID(types.NamespacedName{Namespace: proxy.Namespace, Name: proxy.Name})
+		return envoy.NewADSConfigMap(cmKey, nodeID, r.XDSServer.AdvertiseAddress)
+	}
+	return envoy.NewConfigMap(cmKey, proxy, mutableTunnels)
+}
+
+func (r *ProxyReconciler) reconcileSnapshot(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	nodeID := envoy.NodeID(types.NamespacedName{Namespace: proxy.Namespace, Name: proxy.Name})
+	log := crlog.FromContext(ctx, "nodeID", nodeID)
+
+	snapshot, err := envoy.NewSnapshot(proxy, mutableTunnels)
+	if err != nil {
+		log.Error(err, "unable to generate a snapshot")
+		return err
+	}
+	if err := r.XDSServer.SetSnapshot(ctx, nodeID, snapshot); err != nil {
+		log.Error(err, "unable to set the snapshot")
+		return err
+	}
+	log.Info("set the snapshot", "version", snapshot.GetVersion(resourcev3.ClusterType))
+	return nil
+}
+
+// reconcileSecret reconciles the Secret mounted into the proxy pods.
+// It contains the SDS file and the keys of the Secrets referenced by the tunnels.
+func (r *ProxyReconciler) reconcileSecret(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	secretKey := types.NamespacedName{Namespace: proxy.Namespace, Name: fmt.Sprintf("ktunnels-proxy-%s", proxy.Name)}
+	log := crlog.FromContext(ctx, "secret", secretKey)
+
+	secrets, err := r.getSecrets(ctx, proxy, mutableTunnels)
+	if err != nil {
+		return err
+	}
+	secretTemplate, err := envoy.NewSecret(secretKey, mutableTunnels, secrets)
+	if err != nil {
+		log.Error(err, "unable to generate a secret")
+		return err
+	}
+
+	// the cache has only the metadata of Secrets
+	var secret corev1.Secret
+	if err := r.APIReader.Get(ctx, secretKey, &secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			secret := secretTemplate
+			if err := ctrl.SetControllerReference(&proxy, &secret, r.Scheme); err != nil {
+				log.Error(err, "unable to set a controller reference")
+				return err
+			}
+			if err := r.Create(ctx, &secret); err != nil {
+				log.Error(err, "unable to create a secret")
+				return err
+			}
+			log.Info("created a secret")
+			return nil
+		}
+
+		log.Error(err, "unable to fetch the secret")
+		return err
+	}
+	if !metav1.IsControlledBy(&secret, &proxy) {
+		// do not overwrite a Secret created by the user
+		r.Recorder.Eventf(&proxy, nil, corev1.EventTypeWarning, "SecretConflict", "Reconcile",
+			"Secret %s already exists and is not owned by the proxy", secretKey.Name)
+		return fmt.Errorf("secret %s is not owned by the proxy", secretKey.Name)
+	}
+
+	secretPatch := client.MergeFrom(secret.DeepCopy())
+	secret.Data = secretTemplate.Data
+	if err := r.Patch(ctx, &secret, secretPatch); err != nil {
+		log.Error(err, "unable to update the secret")
+		return err
+	}
+	log.Info("updated the secret")
 	return nil
 }
 
-func (r *ProxyReconciler) reconcileDeployment(ctx context.Context, proxy ktunnelsv1.Proxy) (*appsv1.Deployment, error) {
+// getSecrets returns the Secrets referenced by the tunnels.
+// A missing Secret is skipped and recorded as an event.
+func (r *ProxyReconciler) getSecrets(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) (map[string]*corev1.Secret, error) {
+	log := crlog.FromContext(ctx)
+	secrets := make(map[string]*corev1.Secret)
+	for _, name := range envoy.SecretNamesOf(mutableTunnels) {
+		var secret corev1.Secret
+		if err := r.APIReader.Get(ctx, types.NamespacedName{Namespace: proxy.Namespace, Name: name}, &secret); err != nil {
+			if apierrors.IsNotFound(err) {
+				log.Info("no such secret", "secret", name)
+				r.Recorder.Eventf(&proxy, nil, corev1.EventTypeWarning, "SecretNotFound", "Reconcile",
+					"Secret %s referenced by the tunnel(s) is not found", name)
+				continue
+			}
+			log.Error(err, "unable to fetch the secret", "secret", name)
+			return nil, err
+		}
+		secrets[name] = &secret
+	}
+	return secrets, nil
+}
+
+func computeBootstrapHash(cm *corev1.ConfigMap) string {
+	h := sha256.Sum256([]byte(cm.Data["bootstrap.json"]))
+	return hex.EncodeToString(h[:])[:16]
+}
+
+func (r *ProxyReconciler) reconcileDeployment(ctx context.Context, proxy ktunnelsv1.Proxy, bootstrapHash string) (*appsv1.Deployment, error) {
 	deploymentKey := types.NamespacedName{Namespace: proxy.Namespace, Name: fmt.Sprintf("ktunnels-proxy-%s", proxy.Name)}
 	log := crlog.FromContext(ctx, "deployment", deploymentKey)
 
 	var deployment appsv1.Deployment
 	if err := r.Get(ctx, deploymentKey, &deployment); err != nil {
 		if apierrors.IsNotFound(err) {
-			deployment := envoy.NewDeployment(deploymentKey, proxy)
+			deployment := envoy.NewDeployment(deploymentKey, proxy, bootstrapHash)
 			if err := ctrl.SetControllerReference(&proxy, &deployment, r.Scheme); err != nil {
 				log.Error(err, "unable to set a controller reference")
 				return nil, err
@@ -199,7 +735,7 @@ func (r *ProxyReconciler) reconcileDeployment(ctx context.Context, proxy ktunnel
 		return nil, err
 	}
 
-	deploymentTemplate := envoy.NewDeployment(deploymentKey, proxy)
+	deploymentTemplate := envoy.NewDeployment(deploymentKey, proxy, bootstrapHash)
 	deploymentPatch := client.MergeFrom(deployment.DeepCopy())
 	deployment.Spec = deploymentTemplate.Spec
 	if err := ctrl.SetControllerReference(&proxy, &deployment, r.Scheme); err != nil {
@@ -216,18 +752,13 @@ func (r *ProxyReconciler) reconcileDeployment(ctx context.Context, proxy ktunnel
 
 // SetupWithManager sets up the controller with the Manager.
 func (r *ProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	if err := mgr.GetFieldIndexer().IndexField(context.Background(),
-		&ktunnelsv1.Tunnel{},
-		proxyNameKey,
-		mapTunnelToProxyName,
-	); err != nil {
-		return err
-	}
-
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&ktunnelsv1.Proxy{}).
 		Owns(&corev1.ConfigMap{}).
 		Owns(&appsv1.Deployment{}).
+		Owns(&corev1.Service{}).
+		Owns(&networkingv1.NetworkPolicy{}).
+		Owns(&corev1.Secret{}, builder.OnlyMetadata).
 		Watches(
 			// watch tunnel(s) of a proxy
 			// https://book.kubebuilder.io/reference/watching-resources/externally-managed.html
@@ -235,15 +766,79 @@ func (r *ProxyReconciler) SetupWithManager(mgr ctrl.Manager) error {
 			handler.EnqueueRequestsFromMapFunc(mapTunnelToReconcileRequest),
 			builder.WithPredicates(predicate.ResourceVersionChangedPredicate{}),
 		).
+		Watches(
+			// watch the tunnel policies which may apply to all proxies
+			&ktunnelsv1.TunnelPolicy{},
+			handler.EnqueueRequestsFromMapFunc(r.mapTunnelPolicyToProxyRequests),
+		).
+		Watches(
+			// watch the labels of the namespace which may be selected by the tunnel policies
+			&corev1.Namespace{},
+			handler.EnqueueRequestsFromMapFunc(r.mapNamespaceToProxyRequests),
+			builder.OnlyMetadata,
+			builder.WithPredicates(predicate.LabelChangedPredicate{}),
+		).
+		Watches(
+			// watch secret(s) referenced by tunnel(s) to update the certificates
+			&corev1.Secret{},
+			handler.EnqueueRequestsFromMapFunc(r.mapSecretToProxyRequests),
+			builder.OnlyMetadata,
+		).
 		Complete(r)
 }
 
-func mapTunnelToProxyName(obj client.Object) []string {
-	tunnel, ok := obj.(*ktunnelsv1.Tunnel)
-	if !ok {
+func (r *ProxyReconciler) mapSecretToProxyRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := crlog.FromContext(ctx)
+	var tunnelList ktunnelsv1.TunnelList
+	if err := r.List(ctx, &tunnelList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{secretNameKey: obj.GetName()},
+	); err != nil {
+		log.Error(err, "unable to fetch tunnels", "secret", client.ObjectKeyFromObject(obj))
 		return nil
 	}
-	return []string{tunnel.Spec.Proxy.Name}
+	var requests []reconcile.Request
+	for _, tunnel := range tunnelList.Items {
+		request := reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Spec.Proxy.Name},
+		}
+		if !slices.Contains(requests, request) {
+			requests = append(requests, request)
+		}
+	}
+	return requests
+}
+
+func (r *ProxyReconciler) mapTunnelPolicyToProxyRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := crlog.FromContext(ctx)
+	var proxyList ktunnelsv1.ProxyList
+	if err := r.List(ctx, &proxyList); err != nil {
+		log.Error(err, "unable to fetch proxies", "tunnelPolicy", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, proxy := range proxyList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: proxy.Namespace, Name: proxy.Name},
+		})
+	}
+	return requests
+}
+
+func (r *ProxyReconciler) mapNamespaceToProxyRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := crlog.FromContext(ctx)
+	var proxyList ktunnelsv1.ProxyList
+	if err := r.List(ctx, &proxyList, client.InNamespace(obj.GetName())); err != nil {
+		log.Error(err, "unable to fetch proxies", "namespace", obj.GetName())
+		return nil
+	}
+	var requests []reconcile.Request
+	for _, proxy := range proxyList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: proxy.Namespace, Name: proxy.Name},
+		})
+	}
+	return requests
 }
 
 func mapTunnelToReconcileRequest(_ context.Context, obj client.Object) []reconcile.Request {
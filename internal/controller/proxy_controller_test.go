@@ -11,6 +11,9 @@ import (
 	. "github.com/onsi/gomega"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -101,6 +104,7 @@ var _ = Describe("Proxy controller", func() {
 					Namespace: tunnel.Namespace,
 				}, &tunnel)).Should(Succeed())
 				g.Expect(tunnel.Status.TransitPort).ShouldNot(BeNil())
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPortAllocated)).Should(BeTrue())
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 
@@ -128,6 +132,8 @@ var _ = Describe("Proxy controller", func() {
 					Namespace: proxy.Namespace,
 				}, &proxy)).Should(Succeed())
 				g.Expect(proxy.Status.Ready).Should(BeTrue())
+				g.Expect(proxy.Status.ObservedGeneration).Should(Equal(proxy.Generation))
+				g.Expect(meta.IsStatusConditionTrue(proxy.Status.Conditions, ktunnelsv1.ProxyConditionDeploymentReady)).Should(BeTrue())
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 	})
@@ -206,4 +212,367 @@ var _ = Describe("Proxy controller", func() {
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 	})
+	Context("When a Tunnel with TLS is added", func() {
+		It("Should copy the secrets into the Secret of the proxy", func(ctx context.Context) {
+			By("Creating a secret")
+			caSecret := corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "database-ca",
+					Namespace: "default",
+				},
+				Data: map[string][]byte{"ca.crt": []byte("CA")},
+			}
+			Expect(k8sClient.Create(ctx, &caSecret)).Should(Succeed())
+
+			By("Creating a tunnel")
+			tunnel2 := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "tls-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "tls-database.staging",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+					TLS: &ktunnelsv1.TunnelTLS{
+						CASecretRef: &corev1.LocalObjectReference{Name: "database-ca"},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel2)).Should(Succeed())
+
+			By("Verifying the Secret of the proxy is updated")
+			Eventually(func(g Gomega) {
+				var secret corev1.Secret
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      "ktunnels-proxy-" + proxy.Name,
+					Namespace: "default",
+				}, &secret)).Should(Succeed())
+				g.Expect(secret.Data).Should(HaveKeyWithValue("database-ca_ca.crt", []byte("CA")))
+				g.Expect(secret.Data).Should(HaveKey("sds.json"))
+			}).Should(Succeed())
+
+			By("Verifying the Deployment mounts the Secret of the proxy")
+			var deployment appsv1.Deployment
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      "ktunnels-proxy-" + proxy.Name,
+				Namespace: "default",
+			}, &deployment)).Should(Succeed())
+			volumes := deployment.Spec.Template.Spec.Volumes
+			Expect(volumes).Should(HaveLen(2))
+			Expect(volumes[1].Secret.SecretName).Should(Equal("ktunnels-proxy-" + proxy.Name))
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When the Proxy has a transit port range", func() {
+		It("Should allocate a transit port in the range", func(ctx context.Context) {
+			By("Updating the Proxy")
+			proxyPatch := client.MergeFrom(proxy.DeepCopy())
+			proxy.Spec.TransitPort = ktunnelsv1.ProxyTransitPort{
+				Min:      ptr.To[int32](40000),
+				Max:      ptr.To[int32](40000),
+				Strategy: ktunnelsv1.TransitPortStrategyHash,
+			}
+			Expect(k8sClient.Patch(ctx, &proxy, proxyPatch)).Should(Succeed())
+
+			By("Creating a tunnel")
+			tunnel2 := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "redis-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "redis.staging",
+					Port:  6379,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel2)).Should(Succeed())
+
+			By("Verifying the transit port")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      tunnel2.Name,
+					Namespace: tunnel2.Namespace,
+				}, &tunnel2)).Should(Succeed())
+				g.Expect(tunnel2.Status.TransitPort).Should(Equal(ptr.To[int32](40000)))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When the transit port pool is exhausted", func() {
+		It("Should set the PortPoolExhausted condition", func(ctx context.Context) {
+			By("Updating the Proxy")
+			proxyPatch := client.MergeFrom(proxy.DeepCopy())
+			proxy.Spec.TransitPort = ktunnelsv1.ProxyTransitPort{
+				Min: ptr.To[int32](40001),
+				Max: ptr.To[int32](40001),
+			}
+			Expect(k8sClient.Patch(ctx, &proxy, proxyPatch)).Should(Succeed())
+
+			By("Creating tunnels")
+			var tunnels []*ktunnelsv1.Tunnel
+			for range 2 {
+				tunnel := &ktunnelsv1.Tunnel{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName: "redis-",
+						Namespace:    "default",
+					},
+					Spec: ktunnelsv1.TunnelSpec{
+						Host:  "redis.staging",
+						Port:  6379,
+						Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+					},
+				}
+				Expect(k8sClient.Create(ctx, tunnel)).Should(Succeed())
+				tunnels = append(tunnels, tunnel)
+			}
+
+			By("Verifying the status of Proxy")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      proxy.Name,
+					Namespace: proxy.Namespace,
+				}, &proxy)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionTrue(proxy.Status.Conditions, ktunnelsv1.ProxyConditionPortPoolExhausted)).Should(BeTrue())
+				g.Expect(proxy.Status.TransitPortPool).Should(Equal(&ktunnelsv1.ProxyTransitPortPool{Allocated: 1, Capacity: 1}))
+			}).Should(Succeed())
+
+			By("Verifying the status of Tunnels")
+			Eventually(func(g Gomega) {
+				var reasons []string
+				for _, tunnel := range tunnels {
+					g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+						Name:      tunnel.Name,
+						Namespace: tunnel.Namespace,
+					}, tunnel)).Should(Succeed())
+					condition := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPortAllocated)
+					g.Expect(condition).ShouldNot(BeNil())
+					reasons = append(reasons, condition.Reason)
+				}
+				g.Expect(reasons).Should(ConsistOf(ktunnelsv1.TunnelReasonPortAllocated, ktunnelsv1.TunnelReasonPortPoolExhausted))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a Tunnel requests a transit port", func() {
+		It("Should allocate the requested port", func(ctx context.Context) {
+			By("Creating tunnels")
+			var tunnels []*ktunnelsv1.Tunnel
+			for range 2 {
+				tunnel := &ktunnelsv1.Tunnel{
+					ObjectMeta: metav1.ObjectMeta{
+						GenerateName: "redis-",
+						Namespace:    "default",
+					},
+					Spec: ktunnelsv1.TunnelSpec{
+						Host:        "redis.staging",
+						Port:        6379,
+						TransitPort: ptr.To[int32](20001),
+						Proxy:       corev1.LocalObjectReference{Name: proxy.Name},
+					},
+				}
+				Expect(k8sClient.Create(ctx, tunnel)).Should(Succeed())
+				tunnels = append(tunnels, tunnel)
+			}
+
+			By("Verifying the status of Tunnels")
+			Eventually(func(g Gomega) {
+				var transitPorts []*int32
+				var reasons []string
+				for _, tunnel := range tunnels {
+					g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+						Name:      tunnel.Name,
+						Namespace: tunnel.Namespace,
+					}, tunnel)).Should(Succeed())
+					condition := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPortAllocated)
+					g.Expect(condition).ShouldNot(BeNil())
+					transitPorts = append(transitPorts, tunnel.Status.TransitPort)
+					reasons = append(reasons, condition.Reason)
+				}
+				g.Expect(transitPorts).Should(ConsistOf(ptr.To[int32](20001), BeNil()))
+				g.Expect(reasons).Should(ConsistOf(ktunnelsv1.TunnelReasonPortAllocated, ktunnelsv1.TunnelReasonTransitPortConflict))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When the Service of Proxy is enabled", func() {
+		It("Should create a Service with the ports of all tunnels", func(ctx context.Context) {
+			By("Updating the Proxy")
+			proxyPatch := client.MergeFrom(proxy.DeepCopy())
+			proxy.Spec.Service.Enabled = true
+			Expect(k8sClient.Patch(ctx, &proxy, proxyPatch)).Should(Succeed())
+
+			By("Creating a tunnel")
+			tunnel2 := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "redis-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "redis.staging",
+					Port:  6379,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel2)).Should(Succeed())
+
+			By("Verifying the Service")
+			Eventually(func(g Gomega) {
+				var svc corev1.Service
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      "ktunnels-proxy-" + proxy.Name,
+					Namespace: "default",
+				}, &svc)).Should(Succeed())
+				var portNames []string
+				for _, port := range svc.Spec.Ports {
+					portNames = append(portNames, port.Name)
+				}
+				g.Expect(portNames).Should(ConsistOf(tunnel.Name, tunnel2.Name))
+			}).Should(Succeed())
+
+			By("Deleting the tunnel")
+			Expect(k8sClient.Delete(ctx, &tunnel2)).Should(Succeed())
+
+			By("Verifying the Service")
+			Eventually(func(g Gomega) {
+				var svc corev1.Service
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      "ktunnels-proxy-" + proxy.Name,
+					Namespace: "default",
+				}, &svc)).Should(Succeed())
+				g.Expect(svc.Spec.Ports).Should(HaveLen(1))
+				g.Expect(svc.Spec.Ports[0].Name).Should(Equal(tunnel.Name))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When the NetworkPolicy of Proxy is enabled", func() {
+		It("Should create a NetworkPolicy for the ports of tunnels", func(ctx context.Context) {
+			By("Updating the Proxy")
+			proxyPatch := client.MergeFrom(proxy.DeepCopy())
+			proxy.Spec.NetworkPolicy.Enabled = true
+			Expect(k8sClient.Patch(ctx, &proxy, proxyPatch)).Should(Succeed())
+
+			By("Waiting for the transit port")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&tunnel), &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.TransitPort).ShouldNot(BeNil())
+			}).Should(Succeed())
+
+			By("Verifying the NetworkPolicy")
+			npKey := types.NamespacedName{Name: "ktunnels-proxy-" + proxy.Name, Namespace: "default"}
+			Eventually(func(g Gomega) {
+				var np networkingv1.NetworkPolicy
+				g.Expect(k8sClient.Get(ctx, npKey, &np)).Should(Succeed())
+				g.Expect(np.Spec.PodSelector.MatchLabels).Should(Equal(map[string]string{
+					envoy.PodLabelKeyOfProxy: proxy.Name,
+				}))
+				g.Expect(np.Spec.Ingress).Should(HaveLen(2))
+				var ingressPorts []int32
+				for _, port := range np.Spec.Ingress[0].Ports {
+					ingressPorts = append(ingressPorts, port.Port.IntVal)
+				}
+				g.Expect(ingressPorts).Should(ConsistOf(*tunnel.Status.TransitPort))
+				g.Expect(np.Spec.Ingress[1].From).Should(HaveLen(1))
+				g.Expect(np.Spec.Ingress[1].From[0].NamespaceSelector.MatchExpressions[0].Values).Should(Equal([]string{"ktunnels-system"}))
+				g.Expect(np.Spec.Ingress[1].Ports[0].Port.IntVal).Should(Equal(int32(envoy.AdminPort)))
+				var egressPorts []int32
+				for _, rule := range np.Spec.Egress {
+					for _, port := range rule.Ports {
+						egressPorts = append(egressPorts, port.Port.IntVal)
+					}
+				}
+				g.Expect(egressPorts).Should(ContainElements(int32(5432), int32(53)))
+			}).Should(Succeed())
+
+			By("Disabling the NetworkPolicy")
+			proxyPatch = client.MergeFrom(proxy.DeepCopy())
+			proxy.Spec.NetworkPolicy.Enabled = false
+			Expect(k8sClient.Patch(ctx, &proxy, proxyPatch)).Should(Succeed())
+
+			By("Verifying the NetworkPolicy is deleted")
+			Eventually(func(g Gomega) {
+				var np networkingv1.NetworkPolicy
+				err := k8sClient.Get(ctx, npKey, &np)
+				g.Expect(apierrors.IsNotFound(err)).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a TunnelPolicy denies the destination", func() {
+		It("Should set the PolicyDenied condition", func(ctx context.Context) {
+			By("Creating a TunnelPolicy")
+			tunnelPolicy := ktunnelsv1.TunnelPolicy{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "restricted-"},
+				Spec: ktunnelsv1.TunnelPolicySpec{
+					Deny: []ktunnelsv1.TunnelPolicyRule{{Hosts: []string{"*.restricted"}}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnelPolicy)).Should(Succeed())
+
+			By("Creating a tunnel")
+			tunnel2 := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "payment-db-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "payment-db.restricted",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel2)).Should(Succeed())
+			tunnel2Key := types.NamespacedName{Name: tunnel2.Name, Namespace: tunnel2.Namespace}
+
+			By("Verifying the tunnel is denied")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnel2Key, &tunnel2)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionTrue(tunnel2.Status.Conditions, ktunnelsv1.TunnelConditionPolicyDenied)).Should(BeTrue())
+				ready := meta.FindStatusCondition(tunnel2.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(ready).ShouldNot(BeNil())
+				g.Expect(ready.Reason).Should(Equal(ktunnelsv1.TunnelReasonPolicyDenied))
+				g.Expect(tunnel2.Status.TransitPort).Should(BeNil())
+			}).Should(Succeed())
+
+			By("Verifying the ConfigMap does not contain the tunnel")
+			Eventually(func(g Gomega) {
+				var cm corev1.ConfigMap
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      "ktunnels-proxy-" + proxy.Name,
+					Namespace: "default",
+				}, &cm)).Should(Succeed())
+				g.Expect(cm.Data["cds.json"]).Should(ContainSubstring(tunnel.Name))
+				g.Expect(cm.Data["cds.json"]).ShouldNot(ContainSubstring(tunnel2.Name))
+			}).Should(Succeed())
+
+			By("Deleting the TunnelPolicy")
+			Expect(k8sClient.Delete(ctx, &tunnelPolicy)).Should(Succeed())
+
+			By("Verifying the tunnel is allowed")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnel2Key, &tunnel2)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionFalse(tunnel2.Status.Conditions, ktunnelsv1.TunnelConditionPolicyDenied)).Should(BeTrue())
+				g.Expect(tunnel2.Status.TransitPort).ShouldNot(BeNil())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+})
+
+var _ = Describe("untilNextExpiration", func() {
+	It("Should ignore an expired tunnel", func() {
+		now := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+		newTunnel := func(expiresAt time.Time) *ktunnelsv1.Tunnel {
+			return &ktunnelsv1.Tunnel{Spec: ktunnelsv1.TunnelSpec{ExpiresAt: &metav1.Time{Time: expiresAt}}}
+		}
+		tunnels := []*ktunnelsv1.Tunnel{
+			newTunnel(now.Add(-time.Minute)),
+			newTunnel(now.Add(2 * time.Hour)),
+			newTunnel(now.Add(time.Hour)),
+			{},
+		}
+		Expect(untilNextExpiration(tunnels, now)).Should(Equal(time.Hour))
+		Expect(untilNextExpiration(tunnels[:1], now)).Should(BeZero())
+	})
 })
@@ -0,0 +1,274 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/int128/ktunnels/internal/envoy"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/events"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+)
+
+// statsTimeout is the timeout to collect the statistics or health from a proxy pod.
+const statsTimeout = 5 * time.Second
+
+// StatsCollector periodically collects the traffic statistics and health of tunnels from the proxy pods.
+// It runs outside of the reconciliation, so that an unresponsive pod does not block the proxy controller.
+// It implements manager.Runnable.
+type StatsCollector struct {
+	client.Client
+	Recorder events.EventRecorder
+
+	// Interval is the interval to collect the traffic statistics and health of tunnels.
+	Interval time.Duration
+
+	httpClient *http.Client
+}
+
+// Start collects the statistics every interval until the context is canceled.
+func (c *StatsCollector) Start(ctx context.Context) error {
+	log := crlog.FromContext(ctx).WithName("stats-collector")
+	ctx = crlog.IntoContext(ctx, log)
+	if c.httpClient == nil {
+		c.httpClient = &http.Client{Timeout: statsTimeout}
+	}
+	ticker := time.NewTicker(c.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := c.collect(ctx); err != nil {
+				log.Error(err, "unable to collect the statistics")
+			}
+		}
+	}
+}
+
+// NeedLeaderElection returns true, because only the leader updates the status of tunnels.
+func (c *StatsCollector) NeedLeaderElection() bool {
+	return true
+}
+
+// collect collects the statistics and health of the tunnels of all proxies.
+func (c *StatsCollector) collect(ctx context.Context) error {
+	var proxyList ktunnelsv1.ProxyList
+	if err := c.List(ctx, &proxyList); err != nil {
+		return fmt.Errorf("unable to list the proxies: %w", err)
+	}
+	for _, proxy := range proxyList.Items {
+		log := crlog.FromContext(ctx, "proxy", client.ObjectKeyFromObject(&proxy))
+		ctx := crlog.IntoContext(ctx, log)
+		var tunnelList ktunnelsv1.TunnelList
+		if err := c.List(ctx, &tunnelList,
+			client.InNamespace(proxy.Namespace),
+			client.MatchingFields{proxyNameKey: proxy.Name},
+		); err != nil {
+			log.Error(err, "unable to fetch tunnels")
+			continue
+		}
+		mutableTunnels := make([]*ktunnelsv1.Tunnel, len(tunnelList.Items))
+		for i := range tunnelList.Items {
+			mutableTunnels[i] = &tunnelList.Items[i]
+		}
+		if err := c.reconcileTrafficStats(ctx, proxy, mutableTunnels); err != nil {
+			continue
+		}
+		if err := c.reconcileUpstreamHealth(ctx, proxy, mutableTunnels); err != nil {
+			continue
+		}
+	}
+	return nil
+}
+
+// listRunningPods returns the running pods of the proxy.
+func (c *StatsCollector) listRunningPods(ctx context.Context, proxy ktunnelsv1.Proxy) ([]corev1.Pod, error) {
+	log := crlog.FromContext(ctx)
+	var podList corev1.PodList
+	if err := c.List(ctx, &podList,
+		client.InNamespace(proxy.Namespace),
+		client.MatchingLabels{envoy.PodLabelKeyOfProxy: proxy.Name},
+	); err != nil {
+		log.Error(err, "unable to fetch the pods")
+		return nil, err
+	}
+	return slices.DeleteFunc(podList.Items, func(pod corev1.Pod) bool {
+		return pod.Status.Phase != corev1.PodRunning || pod.Status.PodIP == ""
+	}), nil
+}
+
+func adminURLOf(pod corev1.Pod) string {
+	return fmt.Sprintf("http://%s:%d", pod.Status.PodIP, envoy.AdminPort)
+}
+
+func (c *StatsCollector) reconcileTrafficStats(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	log := crlog.FromContext(ctx)
+
+	pods, err := c.listRunningPods(ctx, proxy)
+	if err != nil {
+		return err
+	}
+	var collected int
+	statsByTunnelName := make(map[string]envoy.TunnelStats)
+	for _, pod := range pods {
+		// an unreachable pod should not block the collection
+		podStats, err := c.getTunnelStats(ctx, adminURLOf(pod), mutableTunnels)
+		if err != nil {
+			log.Error(err, "unable to collect the statistics", "pod", pod.Name)
+			continue
+		}
+		for tunnelName, stats := range podStats {
+			statsByTunnelName[tunnelName] = statsByTunnelName[tunnelName].Add(stats)
+		}
+		collected++
+	}
+	if collected == 0 {
+		log.Info("no pod to collect the statistics")
+		return nil
+	}
+
+	now := metav1.Now()
+	for _, tunnel := range mutableTunnels {
+		stats := statsByTunnelName[tunnel.Name]
+		traffic := newTunnelTraffic(tunnel.Status.Traffic, stats, now)
+		if equality.Semantic.DeepEqual(tunnel.Status.Traffic, traffic) {
+			continue
+		}
+		tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+		tunnel.Status.Traffic = traffic
+		if err := c.Status().Patch(ctx, tunnel, tunnelPatch); err != nil {
+			log.Error(err, "unable to update the tunnel status", "tunnel", tunnel.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *StatsCollector) getTunnelStats(ctx context.Context, adminURL string, tunnels []*ktunnelsv1.Tunnel) (map[string]envoy.TunnelStats, error) {
+	return envoy.GetTunnelStats(ctx, c.httpClient, adminURL, tunnels)
+}
+
+// reconcileUpstreamHealth collects the health of the destinations from the proxy pods,
+// and reflects it to the UpstreamReachable condition of the tunnels.
+func (c *StatsCollector) reconcileUpstreamHealth(ctx context.Context, proxy ktunnelsv1.Proxy, mutableTunnels []*ktunnelsv1.Tunnel) error {
+	log := crlog.FromContext(ctx)
+
+	healthByTunnelName := make(map[string]envoy.TunnelHealth)
+	if slices.ContainsFunc(mutableTunnels, func(tunnel *ktunnelsv1.Tunnel) bool { return tunnel.Spec.HealthCheck != nil }) {
+		pods, err := c.listRunningPods(ctx, proxy)
+		if err != nil {
+			return err
+		}
+		for _, pod := range pods {
+			// an unreachable pod should not block the collection
+			podHealth, err := c.getTunnelHealth(ctx, adminURLOf(pod), mutableTunnels)
+			if err != nil {
+				log.Error(err, "unable to collect the health", "pod", pod.Name)
+				continue
+			}
+			for tunnelName, health := range podHealth {
+				healthByTunnelName[tunnelName] = healthByTunnelName[tunnelName].Add(health)
+			}
+		}
+	}
+
+	for _, tunnel := range mutableTunnels {
+		tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+		if !c.reconcileUpstreamReachableCondition(tunnel, healthByTunnelName[tunnel.Name]) {
+			continue
+		}
+		if err := c.Status().Patch(ctx, tunnel, tunnelPatch); err != nil {
+			log.Error(err, "unable to update the tunnel status", "tunnel", tunnel.Name)
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *StatsCollector) getTunnelHealth(ctx context.Context, adminURL string, tunnels []*ktunnelsv1.Tunnel) (map[string]envoy.TunnelHealth, error) {
+	return envoy.GetTunnelHealth(ctx, c.httpClient, adminURL, tunnels)
+}
+
+// reconcileUpstreamReachableCondition sets the UpstreamReachable condition from the health of the destinations.
+// The condition is removed if the health check is disabled,
+// and kept if no destination has been checked yet.
+// It returns true if the condition is changed.
+func (c *StatsCollector) reconcileUpstreamReachableCondition(tunnel *ktunnelsv1.Tunnel, health envoy.TunnelHealth) bool {
+	if tunnel.Spec.HealthCheck == nil {
+		return meta.RemoveStatusCondition(&tunnel.Status.Conditions, ktunnelsv1.TunnelConditionUpstreamReachable)
+	}
+	total := health.Healthy + health.Unhealthy
+	switch {
+	case total == 0:
+		return false
+	case health.Healthy == 0:
+		return setCondition(c.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+			Type:    ktunnelsv1.TunnelConditionUpstreamReachable,
+			Status:  metav1.ConditionFalse,
+			Reason:  ktunnelsv1.TunnelReasonUpstreamUnreachable,
+			Message: "Destination is unreachable from the proxy: all health checks failed",
+		})
+	case health.Unhealthy > 0:
+		return setCondition(c.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+			Type:    ktunnelsv1.TunnelConditionUpstreamReachable,
+			Status:  metav1.ConditionTrue,
+			Reason:  ktunnelsv1.TunnelReasonUpstreamReachable,
+			Message: fmt.Sprintf("Destination is reachable from the proxy: %d of %d health checks failed", health.Unhealthy, total),
+		})
+	}
+	return setCondition(c.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+		Type:    ktunnelsv1.TunnelConditionUpstreamReachable,
+		Status:  metav1.ConditionTrue,
+		Reason:  ktunnelsv1.TunnelReasonUpstreamReachable,
+		Message: "Destination is reachable from the proxy",
+	})
+}
+
+// newTunnelTraffic returns the traffic status from the current statistics.
+// The last connection time is updated when a connection is active or a new connection is observed.
+func newTunnelTraffic(previous *ktunnelsv1.TunnelTraffic, stats envoy.TunnelStats, now metav1.Time) *ktunnelsv1.TunnelTraffic {
+	traffic := &ktunnelsv1.TunnelTraffic{
+		ActiveConnections: stats.ActiveConnections,
+		TotalConnections:  stats.TotalConnections,
+		ReceivedBytes:     stats.ReceivedBytes,
+		SentBytes:         stats.SentBytes,
+		DeniedConnections: stats.DeniedConnections,
+	}
+	if previous != nil {
+		traffic.LastConnectionTime = previous.LastConnectionTime
+	}
+	if ptr.Deref(stats.ActiveConnections, 0) > 0 ||
+		(previous == nil && stats.TotalConnections > 0) ||
+		(previous != nil && stats.TotalConnections > previous.TotalConnections) {
+		traffic.LastConnectionTime = &now
+	}
+	return traffic
+}
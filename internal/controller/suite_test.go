@@ -18,6 +18,7 @@ package controller
 
 import (
 	"context"
+	"net"
 	"os"
 	"path/filepath"
 	"testing"
@@ -86,15 +87,26 @@ var _ = BeforeSuite(func() {
 	})
 	Expect(err).ToNot(HaveOccurred())
 
+	Expect(SetupFieldIndexes(ctx, k8sManager)).Should(Succeed())
+
 	err = (&ProxyReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:    k8sManager.GetClient(),
+		Scheme:    k8sManager.GetScheme(),
+		Recorder:  k8sManager.GetEventRecorder("proxy-controller"),
+		APIReader: k8sManager.GetAPIReader(),
+		Resolver:  net.DefaultResolver,
+
+		AdminIngressNamespaces: []string{"ktunnels-system"},
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
 	err = (&TunnelReconciler{
-		Client: k8sManager.GetClient(),
-		Scheme: k8sManager.GetScheme(),
+		Client:    k8sManager.GetClient(),
+		Scheme:    k8sManager.GetScheme(),
+		Recorder:  k8sManager.GetEventRecorder("tunnel-controller"),
+		APIReader: k8sManager.GetAPIReader(),
+
+		CreateDefaultProxy: true,
 	}).SetupWithManager(k8sManager)
 	Expect(err).ToNot(HaveOccurred())
 
@@ -18,15 +18,23 @@ package controller
 
 import (
 	"context"
+	"fmt"
+	"time"
 
+	"github.com/int128/ktunnels/internal/defaultproxy"
 	"github.com/int128/ktunnels/internal/envoy"
 	corev1 "k8s.io/api/core/v1"
 	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/events"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
 	crlog "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 )
@@ -34,7 +42,18 @@ import (
 // TunnelReconciler reconciles a Tunnel object
 type TunnelReconciler struct {
 	client.Client
-	Scheme *runtime.Scheme
+	Scheme   *runtime.Scheme
+	Recorder events.EventRecorder
+
+	// APIReader reads the Namespaces without the cache.
+	APIReader client.Reader
+
+	// CreateDefaultProxy creates the default proxy when a tunnel references it for the first time.
+	CreateDefaultProxy bool
+
+	// DefaultProxyName is the name of the default proxy if the namespace does not have the annotation.
+	// It is set to a tunnel without the proxy, even if the webhooks are disabled.
+	DefaultProxyName string
 }
 
 //+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=tunnels,verbs=get;list;watch;create;update;patch;delete
@@ -42,6 +61,8 @@ type TunnelReconciler struct {
 //+kubebuilder:rbac:groups=ktunnels.int128.github.io,resources=tunnels/finalizers,verbs=update
 
 //+kubebuilder:rbac:groups=core,resources=services,verbs=get;list;watch;create;update;patch;delete
+//+kubebuilder:rbac:groups=core,resources=namespaces,verbs=get
+//+kubebuilder:rbac:groups=events.k8s.io,resources=events,verbs=create;patch
 
 // Reconcile is part of the main kubernetes reconciliation loop which aims to
 // move the current state of the cluster closer to the desired state.
@@ -56,69 +77,274 @@ func (r *TunnelReconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctr
 		return ctrl.Result{}, nil
 	}
 
+	now := time.Now()
+	if tunnel.IsExpired(now) && tunnel.Spec.ExpiryPolicy == ktunnelsv1.TunnelExpiryPolicyDelete {
+		if err := r.Delete(ctx, &tunnel); err != nil {
+			log.Error(err, "unable to delete the expired tunnel")
+			return ctrl.Result{}, client.IgnoreNotFound(err)
+		}
+		log.Info("deleted the expired tunnel")
+		r.Recorder.Eventf(&tunnel, nil, corev1.EventTypeNormal, ktunnelsv1.TunnelReasonExpired, "Delete",
+			"Deleted the tunnel expired at %s", tunnel.GetExpirationTime().UTC().Format(time.RFC3339))
+		return ctrl.Result{}, nil
+	}
+
+	if tunnel.Spec.Proxy.Name == "" {
+		defaulted, err := r.setDefaultProxy(ctx, &tunnel)
+		if err != nil {
+			log.Error(err, "unable to set the default proxy")
+			return ctrl.Result{}, err
+		}
+		if defaulted {
+			// the tunnel will be reconciled again by the update
+			return ctrl.Result{}, nil
+		}
+	}
+
+	// proxy controller also updates the status, such as transitPort and PortAllocated condition
+	tunnelPatch := client.MergeFromWithOptions(tunnel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	reconcileErr := r.reconcileTunnel(ctx, &tunnel, now)
+	tunnel.Status.ObservedGeneration = tunnel.Generation
+	tunnel.Status.Ready = meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+	tunnel.Status.ExpiresAt = tunnel.GetExpirationTime()
+	var refreshAfter time.Duration
+	tunnel.Status.Remaining = ""
+	if expiresAt := tunnel.Status.ExpiresAt; expiresAt != nil && !tunnel.IsExpired(now) {
+		tunnel.Status.Remaining, refreshAfter = remainingOf(expiresAt.Sub(now))
+	}
+	if err := r.Status().Patch(ctx, &tunnel, tunnelPatch); err != nil {
+		log.Error(err, "unable to update the tunnel status")
+		return ctrl.Result{}, err
+	}
+	if reconcileErr != nil {
+		return ctrl.Result{}, reconcileErr
+	}
+	// reconcile again when the remaining time changes, or the tunnel expires
+	if expiresAt := tunnel.Status.ExpiresAt; expiresAt != nil && !tunnel.IsExpired(now) {
+		return ctrl.Result{RequeueAfter: min(refreshAfter, expiresAt.Sub(now))}, nil
+	}
+	return ctrl.Result{}, nil
+}
+
+// remainingOf returns the remaining time rounded down to days, hours or minutes,
+// and the duration until the value changes.
+// A larger unit is used if the value is 2 or more, such as 47h and 2d.
+func remainingOf(d time.Duration) (string, time.Duration) {
+	for _, u := range []struct {
+		unit   time.Duration
+		suffix string
+	}{
+		{24 * time.Hour, "d"},
+		{time.Hour, "h"},
+		{time.Minute, "m"},
+	} {
+		if d >= 2*u.unit || (u.unit == time.Minute && d >= u.unit) {
+			// a second after the value is rounded down
+			return fmt.Sprintf("%d%s", d/u.unit, u.suffix), d%u.unit + time.Second
+		}
+	}
+	return "<1m", d
+}
+
+// reconcileTunnel reconciles the service and sets the conditions of the tunnel.
+func (r *TunnelReconciler) reconcileTunnel(ctx context.Context, tunnel *ktunnelsv1.Tunnel, now time.Time) error {
+	log := crlog.FromContext(ctx)
+
 	proxyKey := types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Spec.Proxy.Name}
 	svcKey := types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name}
+
+	if r.reconcileExpiredCondition(tunnel, now) {
+		c := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionExpired)
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonExpired, c.Message)
+		if err := r.deleteServiceIfExists(ctx, svcKey); err != nil {
+			log.Error(err, "unable to delete the service")
+			return err
+		}
+		return nil
+	}
 	var proxy ktunnelsv1.Proxy
 	if err := r.Get(ctx, proxyKey, &proxy); err != nil {
 		if !apierrors.IsNotFound(err) {
 			log.Error(err, "unable to fetch the proxy", "proxy", proxyKey)
-			return ctrl.Result{}, err
+			return err
+		}
+		created, err := r.createDefaultProxy(ctx, tunnel, &proxy)
+		if err != nil {
+			log.Error(err, "unable to create the default proxy", "proxy", proxyKey)
+			return err
+		}
+		if !created {
+			// this tunnel will be reconciled when the proxy is created
+			log.Info("no such proxy", "proxy", proxyKey)
+			message := fmt.Sprintf("Proxy %s is not found", proxyKey.Name)
+			r.setCondition(tunnel, ktunnelsv1.TunnelConditionProxyFound, metav1.ConditionFalse, ktunnelsv1.TunnelReasonProxyNotFound, message)
+			r.setCondition(tunnel, ktunnelsv1.TunnelConditionProxyReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonProxyNotFound, message)
+			r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonProxyNotFound, message)
+			if err := r.deleteServiceIfExists(ctx, svcKey); err != nil {
+				log.Error(err, "unable to delete the service")
+				return err
+			}
+			return nil
 		}
+		log.Info("created the default proxy", "proxy", proxyKey)
+	}
+	r.setCondition(tunnel, ktunnelsv1.TunnelConditionProxyFound, metav1.ConditionTrue, ktunnelsv1.TunnelReasonProxyFound,
+		fmt.Sprintf("Proxy %s is found", proxy.Name))
+	proxyReady := meta.IsStatusConditionTrue(proxy.Status.Conditions, ktunnelsv1.ProxyConditionReady)
+	if proxyReady {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionProxyReady, metav1.ConditionTrue, ktunnelsv1.TunnelReasonProxyReady,
+			fmt.Sprintf("Proxy %s is ready", proxy.Name))
+	} else {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionProxyReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonProxyNotReady,
+			fmt.Sprintf("Proxy %s is not ready", proxy.Name))
+	}
 
-		log.Error(err, "no such proxy", "proxy", proxyKey)
-		tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
-		tunnel.Status.Ready = false
-		if err := r.Status().Patch(ctx, &tunnel, tunnelPatch); err != nil {
-			log.Error(err, "unable to update the tunnel status")
-			return ctrl.Result{}, err
+	if c := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPolicyDenied); c != nil && c.Status == metav1.ConditionTrue {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonPolicyDenied, c.Message)
+		if err := r.deleteServiceIfExists(ctx, svcKey); err != nil {
+			log.Error(err, "unable to delete the service")
+			return err
 		}
+		return nil
+	}
+
+	if tunnel.Spec.TLSPassthrough && envoy.TLSPassthroughPortOf(proxy) == nil {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonTLSPassthroughDisabled,
+			fmt.Sprintf("TLS passthrough is not enabled in Proxy %s", proxy.Name))
 		if err := r.deleteServiceIfExists(ctx, svcKey); err != nil {
 			log.Error(err, "unable to delete the service")
-			return ctrl.Result{}, err
+			return err
 		}
-		return ctrl.Result{}, err
+		return nil
 	}
 
-	if tunnel.Status.TransitPort == nil {
-		tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
-		tunnel.Status.Ready = false
-		if err := r.Status().Patch(ctx, &tunnel, tunnelPatch); err != nil {
-			log.Error(err, "unable to update the tunnel status")
-			return ctrl.Result{}, err
+	portAllocated := tunnel.IsTransitPortAllocated()
+	if tunnel.Spec.TLSPassthrough {
+		// no transit port is needed, but the server name may conflict with another tunnel
+		portAllocated = !meta.IsStatusConditionFalse(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPortAllocated)
+	}
+	if !portAllocated {
+		reason, message := ktunnelsv1.TunnelReasonPortNotAllocated, "Waiting for proxy controller to allocate a transit port"
+		// propagate the reason such as exhaustion of the port pool
+		if c := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionPortAllocated); c != nil && c.Status == metav1.ConditionFalse {
+			reason, message = c.Reason, c.Message
 		}
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, reason, message)
 		if err := r.deleteServiceIfExists(ctx, svcKey); err != nil {
 			log.Error(err, "unable to delete the service")
-			return ctrl.Result{}, err
+			return err
 		}
-		return ctrl.Result{}, nil
+		return nil
 	}
 
-	if err := r.reconcileService(ctx, svcKey, tunnel); err != nil {
-		tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
-		tunnel.Status.Ready = false
-		if err := r.Status().Patch(ctx, &tunnel, tunnelPatch); err != nil {
-			log.Error(err, "unable to update the tunnel status")
-			return ctrl.Result{}, err
-		}
-		return ctrl.Result{}, err
+	if err := r.reconcileService(ctx, svcKey, proxy, *tunnel); err != nil {
+		message := fmt.Sprintf("Unable to reconcile the service: %s", err)
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionServiceReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonServiceError, message)
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonServiceError, message)
+		return err
+	}
+	r.setCondition(tunnel, ktunnelsv1.TunnelConditionServiceReady, metav1.ConditionTrue, ktunnelsv1.TunnelReasonServiceReady,
+		fmt.Sprintf("Service %s is ready", svcKey.Name))
+	if !proxyReady {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonProxyNotReady,
+			fmt.Sprintf("Waiting for Proxy %s to be ready", proxy.Name))
+		return nil
 	}
+	// keep the service, so that a client can see the error of the destination
+	if c := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionUpstreamReachable); c != nil && c.Status == metav1.ConditionFalse {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionFalse, ktunnelsv1.TunnelReasonUpstreamUnreachable, c.Message)
+		return nil
+	}
+	r.setCondition(tunnel, ktunnelsv1.TunnelConditionReady, metav1.ConditionTrue, ktunnelsv1.TunnelReasonReady,
+		fmt.Sprintf("Tunnel is available via Service %s", svcKey.Name))
+	return nil
+}
 
-	tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
-	tunnel.Status.Ready = true
-	if err := r.Status().Patch(ctx, &tunnel, tunnelPatch); err != nil {
-		log.Error(err, "unable to update the tunnel status")
-		return ctrl.Result{}, err
+func (r *TunnelReconciler) setCondition(tunnel *ktunnelsv1.Tunnel, conditionType string, status metav1.ConditionStatus, reason, message string) {
+	setCondition(r.Recorder, tunnel, &tunnel.Status.Conditions, metav1.Condition{
+		Type:    conditionType,
+		Status:  status,
+		Reason:  reason,
+		Message: message,
+	})
+}
+
+// reconcileExpiredCondition sets the Expired condition of the tunnel.
+// The condition is not added to a tunnel which has never been expired.
+// It returns true if the tunnel is expired.
+func (r *TunnelReconciler) reconcileExpiredCondition(tunnel *ktunnelsv1.Tunnel, now time.Time) bool {
+	if tunnel.IsExpired(now) {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionExpired, metav1.ConditionTrue, ktunnelsv1.TunnelReasonExpired,
+			fmt.Sprintf("Tunnel is expired at %s", tunnel.GetExpirationTime().UTC().Format(time.RFC3339)))
+		return true
 	}
-	return ctrl.Result{}, nil
+	if meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionExpired) != nil {
+		r.setCondition(tunnel, ktunnelsv1.TunnelConditionExpired, metav1.ConditionFalse, ktunnelsv1.TunnelReasonNotExpired,
+			"Tunnel is not expired")
+	}
+	return false
 }
 
-func (r *TunnelReconciler) reconcileService(ctx context.Context, svcKey types.NamespacedName, tunnel ktunnelsv1.Tunnel) error {
+// setDefaultProxy sets the default proxy of the namespace to the tunnel without the proxy.
+// The webhook usually sets it on admission, but the webhooks may be disabled.
+// It returns true if the tunnel is updated.
+func (r *TunnelReconciler) setDefaultProxy(ctx context.Context, tunnel *ktunnelsv1.Tunnel) (bool, error) {
+	defaultProxyName, err := defaultproxy.NameOf(ctx, r.APIReader, tunnel.Namespace, r.DefaultProxyName)
+	if err != nil {
+		return false, err
+	}
+	if defaultProxyName == "" {
+		return false, nil
+	}
+	tunnelPatch := client.MergeFromWithOptions(tunnel.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	tunnel.Spec.Proxy.Name = defaultProxyName
+	if err := r.Patch(ctx, tunnel, tunnelPatch); err != nil {
+		return false, fmt.Errorf("unable to patch the tunnel: %w", err)
+	}
+	crlog.FromContext(ctx).Info("set the default proxy", "proxy", defaultProxyName)
+	r.Recorder.Eventf(tunnel, nil, corev1.EventTypeNormal, "ProxyDefaulted", "SetDefaultProxy",
+		"Set the default proxy %s", defaultProxyName)
+	return true, nil
+}
+
+// createDefaultProxy creates the proxy if the tunnel references the default proxy of the namespace.
+// It returns true if the proxy is created.
+func (r *TunnelReconciler) createDefaultProxy(ctx context.Context, tunnel *ktunnelsv1.Tunnel, proxy *ktunnelsv1.Proxy) (bool, error) {
+	if !r.CreateDefaultProxy {
+		return false, nil
+	}
+	defaultProxyName, err := defaultproxy.NameOf(ctx, r.APIReader, tunnel.Namespace, r.DefaultProxyName)
+	if err != nil {
+		return false, err
+	}
+	if defaultProxyName == "" || defaultProxyName != tunnel.Spec.Proxy.Name {
+		return false, nil
+	}
+	*proxy = ktunnelsv1.Proxy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: tunnel.Namespace,
+			Name:      defaultProxyName,
+		},
+	}
+	if err := r.Create(ctx, proxy); err != nil {
+		// the cache may not be synced yet
+		if apierrors.IsAlreadyExists(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	r.Recorder.Eventf(tunnel, proxy, corev1.EventTypeNormal, "ProxyCreated", "CreateProxy",
+		"Created the default proxy %s", proxy.Name)
+	return true, nil
+}
+
+func (r *TunnelReconciler) reconcileService(ctx context.Context, svcKey types.NamespacedName, proxy ktunnelsv1.Proxy, tunnel ktunnelsv1.Tunnel) error {
 	log := crlog.FromContext(ctx, "service", svcKey)
 
 	var svc corev1.Service
 	if err := r.Get(ctx, svcKey, &svc); err != nil {
 		if apierrors.IsNotFound(err) {
-			svc := envoy.NewService(svcKey, tunnel)
+			svc := envoy.NewService(svcKey, proxy, tunnel)
 			if err := ctrl.SetControllerReference(&tunnel, &svc, r.Scheme); err != nil {
 				log.Error(err, "unable to set a controller reference to service")
 				return err
@@ -135,10 +361,11 @@ func (r *TunnelReconciler) reconcileService(ctx context.Context, svcKey types.Na
 		return err
 	}
 
-	svcTemplate := envoy.NewService(svcKey, tunnel)
+	svcTemplate := envoy.NewService(svcKey, proxy, tunnel)
 	svcPatch := client.MergeFrom(svc.DeepCopy())
 	svc.Spec.Ports = svcTemplate.Spec.Ports
 	svc.Spec.Selector = svcTemplate.Spec.Selector
+	svc.Spec.IPFamilyPolicy = svcTemplate.Spec.IPFamilyPolicy
 	if err := ctrl.SetControllerReference(&tunnel, &svc, r.Scheme); err != nil {
 		log.Error(err, "unable to set a controller reference")
 		return err
@@ -169,5 +396,29 @@ func (r *TunnelReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&ktunnelsv1.Tunnel{}).
 		Owns(&corev1.Service{}).
+		Watches(
+			// watch the proxy of tunnel(s)
+			&ktunnelsv1.Proxy{},
+			handler.EnqueueRequestsFromMapFunc(r.mapProxyToTunnelRequests),
+		).
 		Complete(r)
 }
+
+func (r *TunnelReconciler) mapProxyToTunnelRequests(ctx context.Context, obj client.Object) []reconcile.Request {
+	log := crlog.FromContext(ctx)
+	var tunnelList ktunnelsv1.TunnelList
+	if err := r.List(ctx, &tunnelList,
+		client.InNamespace(obj.GetNamespace()),
+		client.MatchingFields{proxyNameKey: obj.GetName()},
+	); err != nil {
+		log.Error(err, "unable to fetch tunnels", "proxy", client.ObjectKeyFromObject(obj))
+		return nil
+	}
+	requests := make([]reconcile.Request, 0, len(tunnelList.Items))
+	for _, tunnel := range tunnelList.Items {
+		requests = append(requests, reconcile.Request{
+			NamespacedName: types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Name},
+		})
+	}
+	return requests
+}
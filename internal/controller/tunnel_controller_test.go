@@ -7,10 +7,13 @@ import (
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	. "github.com/onsi/ginkgo/v2"
 	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
@@ -25,6 +28,7 @@ var _ = Describe("Tunnel controller", func() {
 			},
 		}
 		Expect(k8sClient.Create(ctx, &proxy)).Should(Succeed())
+		makeProxyDeploymentReady(ctx, proxy)
 	})
 
 	Context("When a tunnel is created", func() {
@@ -61,6 +65,151 @@ var _ = Describe("Tunnel controller", func() {
 				}, &tunnel)).Should(Succeed())
 				g.Expect(tunnel.Status.TransitPort).ShouldNot(BeNil())
 				g.Expect(tunnel.Status.Ready).Should(BeTrue())
+				g.Expect(tunnel.Status.ObservedGeneration).Should(Equal(tunnel.Generation))
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionServiceReady)).Should(BeTrue())
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a tunnel with multiple ports is created", func() {
+		It("Should create a service with the ports", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "message-broker-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "message-broker.staging",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+						{Name: "management", Port: 15672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      tunnel.Name,
+					Namespace: tunnel.Namespace,
+				}, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.TransitPorts).Should(HaveLen(2))
+				g.Expect(tunnel.Status.Ready).Should(BeTrue())
+			}).Should(Succeed())
+
+			By("Getting the service")
+			var svc corev1.Service
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      tunnel.Name,
+				Namespace: "default",
+			}, &svc)).Should(Succeed())
+			Expect(svc.Spec.Ports).Should(HaveLen(2))
+			Expect(svc.Spec.Ports[0].Name).Should(Equal("amqp"))
+			Expect(svc.Spec.Ports[0].Port).Should(Equal(int32(5672)))
+			Expect(svc.Spec.Ports[0].TargetPort.IntVal).Should(Equal(*tunnel.GetTransitPort("amqp")))
+			Expect(svc.Spec.Ports[1].Name).Should(Equal("management"))
+			Expect(svc.Spec.Ports[1].TargetPort.IntVal).Should(Equal(*tunnel.GetTransitPort("management")))
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a TLS passthrough tunnel is created", func() {
+		var tunnel ktunnelsv1.Tunnel
+		BeforeEach(func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel = ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "payment-api-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:           "payment-api.example.com",
+					Port:           443,
+					TLSPassthrough: true,
+					Proxy:          corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		})
+
+		It("Should not be ready if the proxy does not enable it", func(ctx context.Context) {
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      tunnel.Name,
+					Namespace: tunnel.Namespace,
+				}, &tunnel)).Should(Succeed())
+				c := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(c).ShouldNot(BeNil())
+				g.Expect(c.Status).Should(Equal(metav1.ConditionFalse))
+				g.Expect(c.Reason).Should(Equal(ktunnelsv1.TunnelReasonTLSPassthroughDisabled))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should create a service to the TLS passthrough port", func(ctx context.Context) {
+			By("Enabling the TLS passthrough of the proxy")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&proxy), &proxy)).Should(Succeed())
+			proxy.Spec.TLSPassthrough.Enabled = true
+			Expect(k8sClient.Update(ctx, &proxy)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+					Name:      tunnel.Name,
+					Namespace: tunnel.Namespace,
+				}, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.TransitPort).Should(BeNil())
+				g.Expect(tunnel.Status.Ready).Should(BeTrue())
+			}).Should(Succeed())
+
+			By("Getting the service")
+			var svc corev1.Service
+			Expect(k8sClient.Get(ctx, types.NamespacedName{
+				Name:      tunnel.Name,
+				Namespace: "default",
+			}, &svc)).Should(Succeed())
+			Expect(svc.Spec.Ports).Should(HaveLen(1))
+			Expect(svc.Spec.Ports[0].Port).Should(Equal(int32(443)))
+			Expect(svc.Spec.Ports[0].TargetPort.IntVal).Should(Equal(int32(8443)))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should not be ready if the server name conflicts with another tunnel", func(ctx context.Context) {
+			By("Enabling the TLS passthrough of the proxy")
+			Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&proxy), &proxy)).Should(Succeed())
+			proxy.Spec.TLSPassthrough.Enabled = true
+			Expect(k8sClient.Update(ctx, &proxy)).Should(Succeed())
+
+			By("Creating a tunnel with the same server name")
+			// the latter in order of name is not routed
+			conflictTunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "replica-payment-api-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:           "payment-api.example.com",
+					Port:           443,
+					TLSPassthrough: true,
+					Proxy:          corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &conflictTunnel)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&conflictTunnel), &conflictTunnel)).Should(Succeed())
+				g.Expect(conflictTunnel.Status.Ready).Should(BeFalse())
+				c := meta.FindStatusCondition(conflictTunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(c).ShouldNot(BeNil())
+				g.Expect(c.Reason).Should(Equal(ktunnelsv1.TunnelReasonServerNameConflict))
+				g.Expect(c.Message).Should(ContainSubstring(tunnel.Name))
+			}).Should(Succeed())
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, client.ObjectKeyFromObject(&tunnel), &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.Ready).Should(BeTrue())
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 	})
@@ -89,6 +238,10 @@ var _ = Describe("Tunnel controller", func() {
 				}, &tunnel)).Should(Succeed())
 				g.Expect(tunnel.Status.TransitPort).Should(BeNil())
 				g.Expect(tunnel.Status.Ready).Should(BeFalse())
+				ready := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(ready).ShouldNot(BeNil())
+				g.Expect(ready.Reason).Should(Equal(ktunnelsv1.TunnelReasonProxyNotFound))
+				g.Expect(meta.IsStatusConditionFalse(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionProxyFound)).Should(BeTrue())
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 
@@ -145,4 +298,258 @@ var _ = Describe("Tunnel controller", func() {
 			}).Should(Succeed())
 		}, SpecTimeout(3*time.Second))
 	})
+	Context("When the proxy is created after the tunnel", func() {
+		It("Should become ready", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "microservice-database.staging",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name + "-later"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+			tunnelKey := types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnelKey, &tunnel)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionFalse(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionProxyFound)).Should(BeTrue())
+			}).Should(Succeed())
+
+			By("Creating the proxy")
+			laterProxy := ktunnelsv1.Proxy{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      proxy.Name + "-later",
+					Namespace: "default",
+				},
+			}
+			Expect(k8sClient.Create(ctx, &laterProxy)).Should(Succeed())
+
+			By("Verifying the tunnel is not ready until the proxy is ready")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnelKey, &tunnel)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionServiceReady)).Should(BeTrue())
+				ready := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(ready).ShouldNot(BeNil())
+				g.Expect(ready.Reason).Should(Equal(ktunnelsv1.TunnelReasonProxyNotReady))
+			}).Should(Succeed())
+
+			makeProxyDeploymentReady(ctx, laterProxy)
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnelKey, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.Ready).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a tunnel references the default proxy of the namespace", func() {
+		It("Should create the proxy", func(ctx context.Context) {
+			By("Creating a namespace with the annotation")
+			namespace := corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "default-proxy-",
+					Annotations:  map[string]string{ktunnelsv1.AnnotationKeyDefaultProxy: "shared"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &namespace)).Should(Succeed())
+
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    namespace.Name,
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "microservice-database.staging",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: "shared"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Getting the proxy")
+			var defaultProxy ktunnelsv1.Proxy
+			Eventually(func() error {
+				return k8sClient.Get(ctx, types.NamespacedName{Name: "shared", Namespace: namespace.Name}, &defaultProxy)
+			}).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &tunnel)).Should(Succeed())
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionProxyFound)).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should set the proxy to a tunnel without the proxy", func(ctx context.Context) {
+			By("Creating a namespace with the annotation")
+			namespace := corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "default-proxy-",
+					Annotations:  map[string]string{ktunnelsv1.AnnotationKeyDefaultProxy: "shared"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &namespace)).Should(Succeed())
+
+			By("Creating a tunnel without the proxy")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    namespace.Name,
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "microservice-database.staging",
+					Port: 5432,
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Verifying the proxy of the tunnel")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Spec.Proxy.Name).Should(Equal("shared"))
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionProxyFound)).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a tunnel is expired", func() {
+		It("Should not be ready", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:      "microservice-database.staging",
+					Port:      5432,
+					Proxy:     corev1.LocalObjectReference{Name: proxy.Name},
+					ExpiresAt: ptr.To(metav1.NewTime(time.Now().Add(-time.Minute))),
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.ExpiresAt).ShouldNot(BeNil())
+				g.Expect(meta.IsStatusConditionTrue(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionExpired)).Should(BeTrue())
+				ready := meta.FindStatusCondition(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionReady)
+				g.Expect(ready).ShouldNot(BeNil())
+				g.Expect(ready.Reason).Should(Equal(ktunnelsv1.TunnelReasonExpired))
+			}).Should(Succeed())
+
+			By("Verifying the service does not exist")
+			var svc corev1.Service
+			err := k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &svc)
+			Expect(errors.ReasonForError(err)).Should(Equal(metav1.StatusReasonNotFound))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should show the remaining time", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "microservice-database.staging",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+					TTL:   &metav1.Duration{Duration: 8 * time.Hour},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.ExpiresAt).ShouldNot(BeNil())
+				g.Expect(tunnel.Status.Remaining).Should(Equal("7h"))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should delete the tunnel when the ttl is passed", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:         "microservice-database.staging",
+					Port:         5432,
+					Proxy:        corev1.LocalObjectReference{Name: proxy.Name},
+					TTL:          &metav1.Duration{Duration: 500 * time.Millisecond},
+					ExpiryPolicy: ktunnelsv1.TunnelExpiryPolicyDelete,
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			By("Verifying the tunnel is deleted")
+			Eventually(func(g Gomega) {
+				err := k8sClient.Get(ctx, types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}, &tunnel)
+				g.Expect(errors.ReasonForError(err)).Should(Equal(metav1.StatusReasonNotFound))
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When the proxy is deleted", func() {
+		It("Should not be ready", func(ctx context.Context) {
+			By("Creating a tunnel")
+			tunnel := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "microservice-database-",
+					Namespace:    "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "microservice-database.staging",
+					Port:  5432,
+					Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+			tunnelKey := types.NamespacedName{Name: tunnel.Name, Namespace: tunnel.Namespace}
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnelKey, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.Ready).Should(BeTrue())
+			}).Should(Succeed())
+
+			By("Deleting the proxy")
+			Expect(k8sClient.Delete(ctx, &proxy)).Should(Succeed())
+
+			By("Verifying the status")
+			Eventually(func(g Gomega) {
+				g.Expect(k8sClient.Get(ctx, tunnelKey, &tunnel)).Should(Succeed())
+				g.Expect(tunnel.Status.Ready).Should(BeFalse())
+				g.Expect(meta.IsStatusConditionFalse(tunnel.Status.Conditions, ktunnelsv1.TunnelConditionProxyFound)).Should(BeTrue())
+			}).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
 })
+
+// makeProxyDeploymentReady updates the status of Deployment of the proxy,
+// because no Deployment controller is running in the test environment.
+func makeProxyDeploymentReady(ctx context.Context, proxy ktunnelsv1.Proxy) {
+	By("Updating the status of Deployment")
+	Eventually(func(g Gomega) {
+		var deployment appsv1.Deployment
+		g.Expect(k8sClient.Get(ctx, types.NamespacedName{
+			Name:      "ktunnels-proxy-" + proxy.Name,
+			Namespace: proxy.Namespace,
+		}, &deployment)).Should(Succeed())
+		deployment.Status.Replicas = 1
+		deployment.Status.ReadyReplicas = 1
+		deployment.Status.AvailableReplicas = 1
+		g.Expect(k8sClient.Status().Update(ctx, &deployment)).Should(Succeed())
+	}).Should(Succeed())
+}
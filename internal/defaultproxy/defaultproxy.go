@@ -0,0 +1,25 @@
+package defaultproxy
+
+import (
+	"context"
+	"fmt"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NameOf returns the name of the default proxy in the namespace.
+// It prefers the annotation of the namespace to the fallback name.
+// It returns an empty string if neither is set.
+func NameOf(ctx context.Context, c client.Reader, namespace, fallbackName string) (string, error) {
+	var ns corev1.Namespace
+	if err := c.Get(ctx, types.NamespacedName{Name: namespace}, &ns); err != nil {
+		return "", fmt.Errorf("unable to get the namespace: %w", err)
+	}
+	if name := ns.Annotations[ktunnelsv1.AnnotationKeyDefaultProxy]; name != "" {
+		return name, nil
+	}
+	return fallbackName, nil
+}
@@ -0,0 +1,45 @@
+package defaultproxy
+
+import (
+	"context"
+	"testing"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestNameOf(t *testing.T) {
+	c := fake.NewClientBuilder().WithObjects(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "plain"}},
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{
+			Name:        "annotated",
+			Annotations: map[string]string{ktunnelsv1.AnnotationKeyDefaultProxy: "shared"},
+		}},
+	).Build()
+
+	t.Run("annotation", func(t *testing.T) {
+		got, err := NameOf(context.TODO(), c, "annotated", "default")
+		if err != nil {
+			t.Fatalf("NameOf: %s", err)
+		}
+		if got != "shared" {
+			t.Errorf("name wants shared but got %s", got)
+		}
+	})
+	t.Run("fallback", func(t *testing.T) {
+		got, err := NameOf(context.TODO(), c, "plain", "default")
+		if err != nil {
+			t.Fatalf("NameOf: %s", err)
+		}
+		if got != "default" {
+			t.Errorf("name wants default but got %s", got)
+		}
+	})
+	t.Run("namespace not found", func(t *testing.T) {
+		if _, err := NameOf(context.TODO(), c, "missing", "default"); err == nil {
+			t.Errorf("err wants non-nil but got nil")
+		}
+	})
+}
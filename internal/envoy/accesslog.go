@@ -0,0 +1,228 @@
+package envoy
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	accesslogv3 "github.com/envoyproxy/go-control-plane/envoy/config/accesslog/v3"
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	grpcv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	open_telemetryv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/open_telemetry/v3"
+	streamv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	tracingv3 "github.com/envoyproxy/go-control-plane/envoy/type/tracing/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	otlpcommonv1 "go.opentelemetry.io/proto/otlp/common/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// accessLogName is the log name sent to a collector.
+const accessLogName = "ktunnels"
+
+// accessLogOf returns the access log settings of the tunnel.
+// It returns nil if no access log is written.
+func accessLogOf(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) *ktunnelsv1.AccessLog {
+	if tunnel.Spec.AccessLog != nil {
+		return tunnel.Spec.AccessLog
+	}
+	return opts.accessLog
+}
+
+// accessLogFieldsOf returns the default fields of an access log entry.
+func accessLogFieldsOf(tunnel *ktunnelsv1.Tunnel, portName string) map[string]string {
+	fields := map[string]string{
+		"tunnel":             tunnel.Name,
+		"namespace":          tunnel.Namespace,
+		"start_time":         "%START_TIME%",
+		"downstream_address": "%DOWNSTREAM_REMOTE_ADDRESS%",
+		"upstream_host":      "%UPSTREAM_HOST%",
+		"bytes_received":     "%BYTES_RECEIVED%",
+		"bytes_sent":         "%BYTES_SENT%",
+		"duration_ms":        "%DURATION%",
+		"response_flags":     "%RESPONSE_FLAGS%",
+	}
+	if tunnel.IsMultiPort() {
+		fields["port"] = portName
+	}
+	return fields
+}
+
+// accessLogTextFormatOf returns the default format string of a text entry.
+func accessLogTextFormatOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	return fmt.Sprintf("[%%START_TIME%%] %s/%s %%DOWNSTREAM_REMOTE_ADDRESS%% -> %%UPSTREAM_HOST%% "+
+		"received=%%BYTES_RECEIVED%% sent=%%BYTES_SENT%% duration=%%DURATION%%ms flags=%%RESPONSE_FLAGS%%",
+		tunnel.Namespace, resourceNameOf(tunnel, portName))
+}
+
+// newAccessLogs returns the access loggers of the listener for the tunnel.
+// The access logs are written by the listener, so that a connection denied by the RBAC filter is also logged.
+func newAccessLogs(tunnel *ktunnelsv1.Tunnel, portName string, opts resourceOptions) ([]*accesslogv3.AccessLog, error) {
+	accessLog := accessLogOf(tunnel, opts)
+	if accessLog == nil {
+		return nil, nil
+	}
+	var accessLogs []*accesslogv3.AccessLog
+	if accessLog.Stdout != nil {
+		config, err := anypb.New(&streamv3.StdoutAccessLog{
+			AccessLogFormat: &streamv3.StdoutAccessLog_LogFormat{
+				LogFormat: newStdoutLogFormat(tunnel, portName, accessLog.Stdout),
+			},
+		})
+		if err != nil {
+			return nil, fmt.Errorf("anypb.New(streamv3.StdoutAccessLog): %w", err)
+		}
+		accessLogs = append(accessLogs, &accesslogv3.AccessLog{
+			Name:       "envoy.access_loggers.stdout",
+			ConfigType: &accesslogv3.AccessLog_TypedConfig{TypedConfig: config},
+		})
+	}
+	if accessLog.Collector != nil {
+		collectorAccessLog, err := newCollectorAccessLog(tunnel, portName, accessLog.Collector)
+		if err != nil {
+			return nil, err
+		}
+		accessLogs = append(accessLogs, collectorAccessLog)
+	}
+	return accessLogs, nil
+}
+
+func newStdoutLogFormat(tunnel *ktunnelsv1.Tunnel, portName string, stdout *ktunnelsv1.StdoutAccessLog) *corev3.SubstitutionFormatString {
+	if stdout.Format == ktunnelsv1.AccessLogFormatText {
+		textFormat := stdout.TextFormat
+		if textFormat == "" {
+			textFormat = accessLogTextFormatOf(tunnel, portName)
+		}
+		if !strings.HasSuffix(textFormat, "\n") {
+			textFormat += "\n"
+		}
+		return &corev3.SubstitutionFormatString{
+			Format: &corev3.SubstitutionFormatString_TextFormatSource{
+				TextFormatSource: &corev3.DataSource{
+					Specifier: &corev3.DataSource_InlineString{InlineString: textFormat},
+				},
+			},
+		}
+	}
+	fields := accessLogFieldsOf(tunnel, portName)
+	maps.Copy(fields, stdout.JSONFormat)
+	jsonFormat := &structpb.Struct{Fields: make(map[string]*structpb.Value)}
+	for key, value := range fields {
+		jsonFormat.Fields[key] = structpb.NewStringValue(value)
+	}
+	return &corev3.SubstitutionFormatString{
+		Format: &corev3.SubstitutionFormatString_JsonFormat{JsonFormat: jsonFormat},
+	}
+}
+
+func newCollectorAccessLog(tunnel *ktunnelsv1.Tunnel, portName string, collector *ktunnelsv1.AccessLogCollector) (*accesslogv3.AccessLog, error) {
+	grpcService := &corev3.GrpcService{
+		TargetSpecifier: &corev3.GrpcService_EnvoyGrpc_{
+			EnvoyGrpc: &corev3.GrpcService_EnvoyGrpc{ClusterName: accessLogCollectorClusterNameOf(collector.Address)},
+		},
+	}
+	var name string
+	var config proto.Message
+	switch collector.Protocol {
+	case ktunnelsv1.AccessLogCollectorProtocolGRPC:
+		name = "envoy.access_loggers.tcp_grpc"
+		config = &grpcv3.TcpGrpcAccessLogConfig{
+			CommonConfig: &grpcv3.CommonGrpcAccessLogConfig{
+				LogName:             accessLogName,
+				GrpcService:         grpcService,
+				TransportApiVersion: corev3.ApiVersion_V3,
+				CustomTags: []*tracingv3.CustomTag{
+					newLiteralCustomTag("tunnel", tunnel.Name),
+					newLiteralCustomTag("namespace", tunnel.Namespace),
+				},
+			},
+		}
+	default:
+		name = "envoy.access_loggers.open_telemetry"
+		fields := accessLogFieldsOf(tunnel, portName)
+		var attributes []*otlpcommonv1.KeyValue
+		for _, key := range slices.Sorted(maps.Keys(fields)) {
+			attributes = append(attributes, &otlpcommonv1.KeyValue{
+				Key:   key,
+				Value: &otlpcommonv1.AnyValue{Value: &otlpcommonv1.AnyValue_StringValue{StringValue: fields[key]}},
+			})
+		}
+		config = &open_telemetryv3.OpenTelemetryAccessLogConfig{
+			LogName:     accessLogName,
+			GrpcService: grpcService,
+			Body: &otlpcommonv1.AnyValue{
+				Value: &otlpcommonv1.AnyValue_StringValue{StringValue: accessLogTextFormatOf(tunnel, portName)},
+			},
+			Attributes: &otlpcommonv1.KeyValueList{Values: attributes},
+		}
+	}
+	typedConfig, err := anypb.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(%T): %w", config, err)
+	}
+	return &accesslogv3.AccessLog{
+		Name:       name,
+		ConfigType: &accesslogv3.AccessLog_TypedConfig{TypedConfig: typedConfig},
+	}, nil
+}
+
+func newLiteralCustomTag(tag, value string) *tracingv3.CustomTag {
+	return &tracingv3.CustomTag{
+		Tag:  tag,
+		Type: &tracingv3.CustomTag_Literal_{Literal: &tracingv3.CustomTag_Literal{Value: value}},
+	}
+}
+
+// accessLogCollectorClusterNameOf returns the name of the cluster for the collector.
+func accessLogCollectorClusterNameOf(address string) string {
+	return fmt.Sprintf("access_log_collector/%s", address)
+}
+
+// newAccessLogCollectorClusters returns a cluster for each collector referenced by the tunnels.
+func newAccessLogCollectorClusters(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) ([]*clusterv3.Cluster, error) {
+	addresses := make(map[string]bool)
+	for _, tunnel := range tunnels {
+		if accessLog := accessLogOf(tunnel, opts); accessLog != nil && accessLog.Collector != nil {
+			addresses[accessLog.Collector.Address] = true
+		}
+	}
+	if len(addresses) == 0 {
+		return nil, nil
+	}
+	http2ProtocolOptions, err := newHTTP2ProtocolOptions()
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*clusterv3.Cluster
+	for _, address := range slices.Sorted(maps.Keys(addresses)) {
+		host, portString, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address of access log collector %q: %w", address, err)
+		}
+		port, err := strconv.ParseUint(portString, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port of access log collector %q: %w", portString, err)
+		}
+		name := accessLogCollectorClusterNameOf(address)
+		clusters = append(clusters, &clusterv3.Cluster{
+			Name:           name,
+			ConnectTimeout: durationpb.New(5 * time.Second),
+			ClusterDiscoveryType: &clusterv3.Cluster_Type{
+				Type: clusterv3.Cluster_LOGICAL_DNS,
+			},
+			DnsLookupFamily: clusterv3.Cluster_V4_ONLY,
+			TypedExtensionProtocolOptions: map[string]*anypb.Any{
+				"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": http2ProtocolOptions,
+			},
+			LoadAssignment: newLoadAssignment(name, host, uint32(port)),
+		})
+	}
+	return clusters, nil
+}
@@ -0,0 +1,185 @@
+package envoy
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	grpcv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/grpc/v3"
+	open_telemetryv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/open_telemetry/v3"
+	streamv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/access_loggers/stream/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newAccessLogs(t *testing.T) {
+	newTunnel := func(accessLog *ktunnelsv1.AccessLog) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "payment-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:      "payment-database.staging",
+				Port:      5432,
+				AccessLog: accessLog,
+			},
+		}
+	}
+
+	t.Run("no access log", func(t *testing.T) {
+		accessLogs, err := newAccessLogs(newTunnel(nil), ktunnelsv1.SinglePortName, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		if len(accessLogs) != 0 {
+			t.Errorf("accessLogs wants empty but got %v", accessLogs)
+		}
+	})
+	t.Run("tunnel disables the default of proxy", func(t *testing.T) {
+		opts := resourceOptions{accessLog: &ktunnelsv1.AccessLog{Stdout: &ktunnelsv1.StdoutAccessLog{}}}
+		accessLogs, err := newAccessLogs(newTunnel(&ktunnelsv1.AccessLog{}), ktunnelsv1.SinglePortName, opts)
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		if len(accessLogs) != 0 {
+			t.Errorf("accessLogs wants empty but got %v", accessLogs)
+		}
+	})
+	t.Run("JSON format of proxy", func(t *testing.T) {
+		opts := resourceOptions{accessLog: &ktunnelsv1.AccessLog{
+			Stdout: &ktunnelsv1.StdoutAccessLog{
+				JSONFormat: map[string]string{"protocol": "%PROTOCOL%"},
+			},
+		}}
+		accessLogs, err := newAccessLogs(newTunnel(nil), ktunnelsv1.SinglePortName, opts)
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		if len(accessLogs) != 1 {
+			t.Fatalf("len(accessLogs) wants 1 but got %d", len(accessLogs))
+		}
+		var stdout streamv3.StdoutAccessLog
+		if err := accessLogs[0].GetTypedConfig().UnmarshalTo(&stdout); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		got := stdout.GetLogFormat().GetJsonFormat().AsMap()
+		want := map[string]any{
+			"tunnel":             "payment-database",
+			"namespace":          "default",
+			"start_time":         "%START_TIME%",
+			"downstream_address": "%DOWNSTREAM_REMOTE_ADDRESS%",
+			"upstream_host":      "%UPSTREAM_HOST%",
+			"bytes_received":     "%BYTES_RECEIVED%",
+			"bytes_sent":         "%BYTES_SENT%",
+			"duration_ms":        "%DURATION%",
+			"response_flags":     "%RESPONSE_FLAGS%",
+			"protocol":           "%PROTOCOL%",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("jsonFormat mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("Text format of tunnel", func(t *testing.T) {
+		tunnel := newTunnel(&ktunnelsv1.AccessLog{
+			Stdout: &ktunnelsv1.StdoutAccessLog{
+				Format:     ktunnelsv1.AccessLogFormatText,
+				TextFormat: "%UPSTREAM_CLUSTER% %DOWNSTREAM_REMOTE_ADDRESS%",
+			},
+		})
+		accessLogs, err := newAccessLogs(tunnel, ktunnelsv1.SinglePortName, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		var stdout streamv3.StdoutAccessLog
+		if err := accessLogs[0].GetTypedConfig().UnmarshalTo(&stdout); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		got := stdout.GetLogFormat().GetTextFormatSource().GetInlineString()
+		if want := "%UPSTREAM_CLUSTER% %DOWNSTREAM_REMOTE_ADDRESS%\n"; want != got {
+			t.Errorf("textFormat wants %q but got %q", want, got)
+		}
+	})
+	t.Run("OpenTelemetry collector", func(t *testing.T) {
+		tunnel := newTunnel(&ktunnelsv1.AccessLog{
+			Collector: &ktunnelsv1.AccessLogCollector{Address: "otel-collector.monitoring.svc:4317"},
+		})
+		accessLogs, err := newAccessLogs(tunnel, ktunnelsv1.SinglePortName, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		if accessLogs[0].GetName() != "envoy.access_loggers.open_telemetry" {
+			t.Errorf("name wants envoy.access_loggers.open_telemetry but got %s", accessLogs[0].GetName())
+		}
+		var config open_telemetryv3.OpenTelemetryAccessLogConfig
+		if err := accessLogs[0].GetTypedConfig().UnmarshalTo(&config); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		if got := config.GetGrpcService().GetEnvoyGrpc().GetClusterName(); got != "access_log_collector/otel-collector.monitoring.svc:4317" {
+			t.Errorf("clusterName wants access_log_collector/otel-collector.monitoring.svc:4317 but got %s", got)
+		}
+		attributes := make(map[string]string)
+		for _, kv := range config.GetAttributes().GetValues() {
+			attributes[kv.GetKey()] = kv.GetValue().GetStringValue()
+		}
+		if attributes["tunnel"] != "payment-database" {
+			t.Errorf("tunnel attribute wants payment-database but got %s", attributes["tunnel"])
+		}
+	})
+	t.Run("gRPC collector", func(t *testing.T) {
+		tunnel := newTunnel(&ktunnelsv1.AccessLog{
+			Collector: &ktunnelsv1.AccessLogCollector{
+				Address:  "als.monitoring.svc:9000",
+				Protocol: ktunnelsv1.AccessLogCollectorProtocolGRPC,
+			},
+		})
+		accessLogs, err := newAccessLogs(tunnel, ktunnelsv1.SinglePortName, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newAccessLogs: %s", err)
+		}
+		var config grpcv3.TcpGrpcAccessLogConfig
+		if err := accessLogs[0].GetTypedConfig().UnmarshalTo(&config); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		var tags []string
+		for _, tag := range config.GetCommonConfig().GetCustomTags() {
+			tags = append(tags, tag.GetTag()+"="+tag.GetLiteral().GetValue())
+		}
+		if diff := cmp.Diff([]string{"tunnel=payment-database", "namespace=default"}, tags); diff != "" {
+			t.Errorf("customTags mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func Test_newAccessLogCollectorClusters(t *testing.T) {
+	opts := resourceOptions{accessLog: &ktunnelsv1.AccessLog{
+		Collector: &ktunnelsv1.AccessLogCollector{Address: "otel-collector.monitoring.svc:4317"},
+	}}
+	tunnels := []*ktunnelsv1.Tunnel{
+		{ObjectMeta: metav1.ObjectMeta{Name: "payment-database"}},
+		{ObjectMeta: metav1.ObjectMeta{Name: "message-broker"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis"},
+			Spec: ktunnelsv1.TunnelSpec{
+				AccessLog: &ktunnelsv1.AccessLog{
+					Collector: &ktunnelsv1.AccessLogCollector{Address: "als.monitoring.svc:9000"},
+				},
+			},
+		},
+	}
+	clusters, err := newAccessLogCollectorClusters(tunnels, opts)
+	if err != nil {
+		t.Fatalf("newAccessLogCollectorClusters: %s", err)
+	}
+	var names []string
+	for _, cluster := range clusters {
+		names = append(names, cluster.GetName())
+	}
+	want := []string{
+		"access_log_collector/als.monitoring.svc:9000",
+		"access_log_collector/otel-collector.monitoring.svc:4317",
+	}
+	if diff := cmp.Diff(want, names); diff != "" {
+		t.Errorf("names mismatch (-want +got):\n%s", diff)
+	}
+	if clusters[0].GetType() != clusterv3.Cluster_LOGICAL_DNS {
+		t.Errorf("type wants LOGICAL_DNS but got %s", clusters[0].GetType())
+	}
+}
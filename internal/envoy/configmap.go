@@ -2,6 +2,8 @@ package envoy
 
 import (
 	"fmt"
+	"net"
+	"strconv"
 	"time"
 
 	bootstrapv3 "github.com/envoyproxy/go-control-plane/envoy/config/bootstrap/v3"
@@ -13,26 +15,32 @@ import (
 	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
 	http_connection_managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
 	tcp_proxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	httpv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/upstreams/http/v3"
 	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
 )
 
-func NewConfigMap(key types.NamespacedName, tunnels []*ktunnelsv1.Tunnel) (corev1.ConfigMap, error) {
+// NewConfigMap returns a ConfigMap with the bootstrap, CDS and LDS files.
+// Envoy watches the files and reloads the configuration when kubelet updates the volume.
+func NewConfigMap(key types.NamespacedName, proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) (corev1.ConfigMap, error) {
+	opts := newResourceOptions(proxy)
 	bootstrap, err := generateBootstrap()
 	if err != nil {
 		return corev1.ConfigMap{}, fmt.Errorf("unable to generate bootstrap: %w", err)
 	}
-	cds, err := generateCDS(tunnels)
+	cds, err := generateCDS(tunnels, opts)
 	if err != nil {
 		return corev1.ConfigMap{}, fmt.Errorf("unable to generate CDS: %w", err)
 	}
-	lds, err := generateLDS(tunnels)
+	lds, err := generateLDS(tunnels, opts)
 	if err != nil {
 		return corev1.ConfigMap{}, fmt.Errorf("unable to generate LDS: %w", err)
 	}
@@ -49,24 +57,37 @@ func NewConfigMap(key types.NamespacedName, tunnels []*ktunnelsv1.Tunnel) (corev
 	}, nil
 }
 
+// NewADSConfigMap returns a ConfigMap with the bootstrap file only.
+// Envoy fetches the clusters and listeners from the xDS server via ADS.
+func NewADSConfigMap(key types.NamespacedName, nodeID, xdsAddress string) (corev1.ConfigMap, error) {
+	bootstrap, err := generateADSBootstrap(nodeID, xdsAddress)
+	if err != nil {
+		return corev1.ConfigMap{}, fmt.Errorf("unable to generate bootstrap: %w", err)
+	}
+	return corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+		Data: map[string]string{
+			"bootstrap.json": bootstrap,
+		},
+	}, nil
+}
+
+// NodeID returns the node ID of Envoy for the proxy.
+// The xDS server distinguishes the proxies by this value.
+func NodeID(proxyKey types.NamespacedName) string {
+	return proxyKey.String()
+}
+
 func generateBootstrap() (string, error) {
 	bootstrap := &bootstrapv3.Bootstrap{
 		Node: &corev3.Node{
 			Cluster: "test-cluster",
 			Id:      "test-id",
 		},
-		Admin: &bootstrapv3.Admin{
-			Address: &corev3.Address{
-				Address: &corev3.Address_SocketAddress{
-					SocketAddress: &corev3.SocketAddress{
-						Address: "127.0.0.1",
-						PortSpecifier: &corev3.SocketAddress_PortValue{
-							PortValue: 19901,
-						},
-					},
-				},
-			},
-		},
+		Admin: createAdmin(),
 		DynamicResources: &bootstrapv3.Bootstrap_DynamicResources{
 			CdsConfig: &corev3.ConfigSource{
 				ResourceApiVersion: corev3.ApiVersion_V3,
@@ -99,117 +120,340 @@ func generateBootstrap() (string, error) {
 	return string(b), nil
 }
 
-func generateCDS(tunnels []*ktunnelsv1.Tunnel) (string, error) {
-	var resources []*anypb.Any
-	for _, tunnel := range tunnels {
-		cluster := &clusterv3.Cluster{
-			Name:           tunnel.Name,
-			ConnectTimeout: durationpb.New(30 * time.Second),
-			ClusterDiscoveryType: &clusterv3.Cluster_Type{
-				Type: clusterv3.Cluster_LOGICAL_DNS,
+const xdsClusterName = "xds_cluster"
+
+func generateADSBootstrap(nodeID, xdsAddress string) (string, error) {
+	xdsHost, xdsPortString, err := net.SplitHostPort(xdsAddress)
+	if err != nil {
+		return "", fmt.Errorf("invalid xDS address %q: %w", xdsAddress, err)
+	}
+	xdsPort, err := strconv.ParseUint(xdsPortString, 10, 16)
+	if err != nil {
+		return "", fmt.Errorf("invalid xDS port %q: %w", xdsPortString, err)
+	}
+	http2ProtocolOptions, err := newHTTP2ProtocolOptions()
+	if err != nil {
+		return "", err
+	}
+
+	bootstrap := &bootstrapv3.Bootstrap{
+		Node: &corev3.Node{
+			Cluster: "ktunnels",
+			Id:      nodeID,
+		},
+		Admin: createAdmin(),
+		StaticResources: &bootstrapv3.Bootstrap_StaticResources{
+			Clusters: []*clusterv3.Cluster{
+				{
+					Name:           xdsClusterName,
+					ConnectTimeout: durationpb.New(5 * time.Second),
+					ClusterDiscoveryType: &clusterv3.Cluster_Type{
+						Type: clusterv3.Cluster_LOGICAL_DNS,
+					},
+					DnsLookupFamily: clusterv3.Cluster_V4_ONLY,
+					TypedExtensionProtocolOptions: map[string]*anypb.Any{
+						"envoy.extensions.upstreams.http.v3.HttpProtocolOptions": http2ProtocolOptions,
+					},
+					LoadAssignment: newLoadAssignment(xdsClusterName, xdsHost, uint32(xdsPort)),
+				},
 			},
-			DnsLookupFamily: clusterv3.Cluster_V4_ONLY,
-			LoadAssignment: &endpointv3.ClusterLoadAssignment{
-				ClusterName: tunnel.Name,
-				Endpoints: []*endpointv3.LocalityLbEndpoints{
+		},
+		DynamicResources: &bootstrapv3.Bootstrap_DynamicResources{
+			AdsConfig: &corev3.ApiConfigSource{
+				ApiType:                   corev3.ApiConfigSource_GRPC,
+				TransportApiVersion:       corev3.ApiVersion_V3,
+				SetNodeOnFirstMessageOnly: true,
+				GrpcServices: []*corev3.GrpcService{
 					{
-						LbEndpoints: []*endpointv3.LbEndpoint{
-							{
-								HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
-									Endpoint: &endpointv3.Endpoint{
-										Address: &corev3.Address{
-											Address: &corev3.Address_SocketAddress{
-												SocketAddress: &corev3.SocketAddress{
-													Address: tunnel.Spec.Host,
-													PortSpecifier: &corev3.SocketAddress_PortValue{
-														PortValue: uint32(tunnel.Spec.Port),
-													},
-												},
-											},
-										},
-									},
-								},
-							},
+						TargetSpecifier: &corev3.GrpcService_EnvoyGrpc_{
+							EnvoyGrpc: &corev3.GrpcService_EnvoyGrpc{ClusterName: xdsClusterName},
 						},
 					},
 				},
 			},
-		}
-		r, err := anypb.New(cluster)
-		if err != nil {
-			return "", fmt.Errorf("anypb.New(clusterv3.Cluster): %w", err)
-		}
-		resources = append(resources, r)
+			CdsConfig: &corev3.ConfigSource{
+				ResourceApiVersion:    corev3.ApiVersion_V3,
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+			LdsConfig: &corev3.ConfigSource{
+				ResourceApiVersion:    corev3.ApiVersion_V3,
+				ConfigSourceSpecifier: &corev3.ConfigSource_Ads{Ads: &corev3.AggregatedConfigSource{}},
+			},
+		},
 	}
+	b, err := protojson.Marshal(bootstrap)
+	if err != nil {
+		return "", fmt.Errorf("marshal: %w", err)
+	}
+	return string(b), nil
+}
+
+// newHTTP2ProtocolOptions returns the protocol options of a gRPC cluster.
+func newHTTP2ProtocolOptions() (*anypb.Any, error) {
+	http2ProtocolOptions, err := anypb.New(&httpv3.HttpProtocolOptions{
+		UpstreamProtocolOptions: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_{
+			ExplicitHttpConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig{
+				ProtocolConfig: &httpv3.HttpProtocolOptions_ExplicitHttpConfig_Http2ProtocolOptions{
+					Http2ProtocolOptions: &corev3.Http2ProtocolOptions{},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(httpv3.HttpProtocolOptions): %w", err)
+	}
+	return http2ProtocolOptions, nil
+}
 
-	adminCluster, err := createAdminCluster()
+func generateCDS(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) (string, error) {
+	clusters, err := newClusters(tunnels, opts)
 	if err != nil {
-		return "", fmt.Errorf("unable to create an admin cluster: %w", err)
+		return "", err
+	}
+	var resources []proto.Message
+	for _, cluster := range clusters {
+		resources = append(resources, cluster)
 	}
-	resources = append(resources, adminCluster)
+	return marshalDiscoveryResponse(resources)
+}
 
-	b, err := protojson.Marshal(&discoveryv3.DiscoveryResponse{Resources: resources})
+func generateLDS(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) (string, error) {
+	listeners, err := newListeners(tunnels, opts)
+	if err != nil {
+		return "", err
+	}
+	var resources []proto.Message
+	for _, listener := range listeners {
+		resources = append(resources, listener)
+	}
+	return marshalDiscoveryResponse(resources)
+}
+
+func marshalDiscoveryResponse(resources []proto.Message) (string, error) {
+	var anyResources []*anypb.Any
+	for _, resource := range resources {
+		r, err := anypb.New(resource)
+		if err != nil {
+			return "", fmt.Errorf("anypb.New(%T): %w", resource, err)
+		}
+		anyResources = append(anyResources, r)
+	}
+	b, err := protojson.Marshal(&discoveryv3.DiscoveryResponse{Resources: anyResources})
 	if err != nil {
 		return "", fmt.Errorf("marshal: %w", err)
 	}
 	return string(b), nil
 }
 
-func generateLDS(tunnels []*ktunnelsv1.Tunnel) (string, error) {
-	var resources []*anypb.Any
+// resourceNameOf returns the name of the listener and cluster for the port of the tunnel.
+// It keeps the tunnel name in the single-port form for compatibility.
+func resourceNameOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	if !tunnel.IsMultiPort() {
+		return tunnel.Name
+	}
+	return fmt.Sprintf("%s/%s", tunnel.Name, portName)
+}
+
+// resourceOptions represents the options to generate the resources.
+type resourceOptions struct {
+	// allowedSourceRanges is used if a tunnel does not specify it.
+	allowedSourceRanges []string
+
+	// accessLog is used if a tunnel does not specify it.
+	accessLog *ktunnelsv1.AccessLog
+
+	// timeouts and keepalive are used for the fields which a tunnel does not specify.
+	timeouts  *ktunnelsv1.TunnelTimeouts
+	keepalive *ktunnelsv1.TunnelKeepalive
+
+	// dns is used for the fields which a tunnel does not specify.
+	dns *ktunnelsv1.TunnelDNS
+
+	// dnsResolvers are the DNS servers to resolve the hosts of the tunnels.
+	dnsResolvers []string
+
+	// dualStack is true if the listeners bind both IPv4 and IPv6.
+	dualStack bool
+
+	// tlsPassthroughPort is the port of the TLS passthrough listener.
+	// It is nil if the TLS passthrough is not enabled.
+	tlsPassthroughPort *int32
+}
+
+func newResourceOptions(proxy ktunnelsv1.Proxy) resourceOptions {
+	return resourceOptions{
+		allowedSourceRanges: proxy.Spec.AllowedSourceRanges,
+		accessLog:           proxy.Spec.AccessLog,
+		timeouts:            proxy.Spec.Timeouts,
+		keepalive:           proxy.Spec.Keepalive,
+		dns:                 proxy.Spec.DNS,
+		dnsResolvers:        proxy.Spec.DNSResolvers,
+		dualStack:           proxy.Spec.DualStack,
+		tlsPassthroughPort:  TLSPassthroughPortOf(proxy),
+	}
+}
+
+func newClusters(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) ([]*clusterv3.Cluster, error) {
+	dnsResolverConfig, err := newDNSResolverConfig(opts.dnsResolvers)
+	if err != nil {
+		return nil, err
+	}
+	var clusters []*clusterv3.Cluster
 	for _, tunnel := range tunnels {
-		if tunnel.Status.TransitPort == nil {
-			continue
+		transportSocket, err := newUpstreamTransportSocket(tunnel)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
+		}
+		timeouts := timeoutsOf(tunnel, opts)
+		dns := dnsOf(tunnel, opts)
+		destinations := tunnel.GetDestinations()
+		for _, port := range tunnel.GetPorts() {
+			name := resourceNameOf(tunnel, port.Name)
+			clusters = append(clusters, &clusterv3.Cluster{
+				Name:                      name,
+				AltStatName:               StatPrefixOf(tunnel, port.Name),
+				ConnectTimeout:            newConnectTimeout(timeouts),
+				ClusterDiscoveryType:      newClusterDiscoveryType(destinations, dns),
+				DnsLookupFamily:           newDNSLookupFamily(dns.LookupFamily),
+				DnsRefreshRate:            newDurationOrNil(dns.RefreshRate),
+				RespectDnsTtl:             ptr.Deref(dns.RespectTTL, false),
+				TypedDnsResolverConfig:    dnsResolverConfig,
+				LoadAssignment:            newDestinationLoadAssignment(name, destinations, uint32(port.Port)),
+				OutlierDetection:          newOutlierDetection(destinations),
+				HealthChecks:              newHealthChecks(tunnel.Spec.HealthCheck),
+				TransportSocket:           transportSocket,
+				UpstreamConnectionOptions: newUpstreamConnectionOptions(keepaliveOf(tunnel, opts)),
+			})
 		}
+	}
+	collectorClusters, err := newAccessLogCollectorClusters(tunnels, opts)
+	if err != nil {
+		return nil, err
+	}
+	clusters = append(clusters, collectorClusters...)
+	clusters = append(clusters, createAdminCluster())
+	return clusters, nil
+}
 
-		tcpProxyConfig, err := anypb.New(&tcp_proxyv3.TcpProxy{
-			StatPrefix:       "destination",
-			ClusterSpecifier: &tcp_proxyv3.TcpProxy_Cluster{Cluster: tunnel.Name},
-		})
+func newListeners(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) ([]*listenerv3.Listener, error) {
+	var listeners []*listenerv3.Listener
+	for _, tunnel := range tunnels {
+		for _, port := range tunnel.GetPorts() {
+			transitPort := tunnel.GetTransitPort(port.Name)
+			if transitPort == nil {
+				continue
+			}
+			listener, err := newListener(tunnel, port.Name, *transitPort, opts)
+			if err != nil {
+				return nil, err
+			}
+			listeners = append(listeners, listener)
+		}
+	}
+
+	tlsPassthroughListener, err := newTLSPassthroughListener(tunnels, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a TLS passthrough listener: %w", err)
+	}
+	if tlsPassthroughListener != nil {
+		listeners = append(listeners, tlsPassthroughListener)
+	}
+
+	adminListener, err := createAdminListener(opts.dualStack)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create an admin listener: %w", err)
+	}
+	listeners = append(listeners, adminListener)
+	return listeners, nil
+}
+
+func newListener(tunnel *ktunnelsv1.Tunnel, portName string, transitPort int32, opts resourceOptions) (*listenerv3.Listener, error) {
+	name := resourceNameOf(tunnel, portName)
+	var filters []*listenerv3.Filter
+	sourceRBACFilter, err := newSourceRBACFilter(tunnel, portName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if sourceRBACFilter != nil {
+		filters = append(filters, sourceRBACFilter)
+	}
+	protocolFilter, err := newProtocolFilter(tunnel, portName)
+	if err != nil {
+		return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
+	}
+	if protocolFilter != nil {
+		filters = append(filters, protocolFilter)
+	}
+	switch {
+	case tunnel.Spec.HTTP != nil:
+		httpConnectionManagerFilter, err := newHTTPConnectionManagerFilter(tunnel, portName, opts)
 		if err != nil {
-			return "", fmt.Errorf("anypb.New(tcp_proxyv3.TcpProxy): %w", err)
+			return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
 		}
-		listener := &listenerv3.Listener{
-			Name: tunnel.Name,
-			Address: &corev3.Address{
-				Address: &corev3.Address_SocketAddress{
-					SocketAddress: &corev3.SocketAddress{
-						Address: "0.0.0.0",
-						PortSpecifier: &corev3.SocketAddress_PortValue{
-							PortValue: uint32(*tunnel.Status.TransitPort),
-						},
-					},
-				},
-			},
-			FilterChains: []*listenerv3.FilterChain{
-				{
-					Filters: []*listenerv3.Filter{
-						{
-							Name:       "envoy.filters.network.tcp_proxy",
-							ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: tcpProxyConfig},
-						},
-					},
-				},
-			},
+		filters = append(filters, httpConnectionManagerFilter)
+	case tunnel.Spec.Protocol == ktunnelsv1.TunnelProtocolRedis:
+		redisProxyFilter, err := newRedisProxyFilter(tunnel, portName)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
 		}
-		r, err := anypb.New(listener)
+		filters = append(filters, redisProxyFilter)
+	default:
+		timeouts := timeoutsOf(tunnel, opts)
+		tcpProxyConfig, err := anypb.New(&tcp_proxyv3.TcpProxy{
+			StatPrefix:                      StatPrefixOf(tunnel, portName),
+			ClusterSpecifier:                &tcp_proxyv3.TcpProxy_Cluster{Cluster: name},
+			IdleTimeout:                     newDurationOrNil(timeouts.Idle),
+			MaxDownstreamConnectionDuration: newDurationOrNil(timeouts.MaxConnectionDuration),
+		})
 		if err != nil {
-			return "", fmt.Errorf("anypb.New(listenerv3.Listener): %w", err)
+			return nil, fmt.Errorf("anypb.New(tcp_proxyv3.TcpProxy): %w", err)
 		}
-		resources = append(resources, r)
+		filters = append(filters, &listenerv3.Filter{
+			Name:       "envoy.filters.network.tcp_proxy",
+			ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: tcpProxyConfig},
+		})
 	}
-
-	adminListener, err := createAdminListener()
+	accessLogs, err := newAccessLogs(tunnel, portName, opts)
 	if err != nil {
-		return "", fmt.Errorf("unable to create an admin listener: %w", err)
+		return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
 	}
-	resources = append(resources, adminListener)
+	return &listenerv3.Listener{
+		Name:       name,
+		StatPrefix: StatPrefixOf(tunnel, portName),
+		AccessLog:  accessLogs,
+		Address:    newListenerAddress(uint32(transitPort), opts.dualStack),
+		FilterChains: []*listenerv3.FilterChain{
+			{Filters: filters},
+		},
+	}, nil
+}
 
-	b, err := protojson.Marshal(&discoveryv3.DiscoveryResponse{Resources: resources})
-	if err != nil {
-		return "", fmt.Errorf("marshal: %w", err)
+func newLoadAssignment(clusterName, host string, port uint32) *endpointv3.ClusterLoadAssignment {
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints: []*endpointv3.LocalityLbEndpoints{
+			{
+				LbEndpoints: []*endpointv3.LbEndpoint{
+					{
+						HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+							Endpoint: &endpointv3.Endpoint{
+								Address: &corev3.Address{
+									Address: &corev3.Address_SocketAddress{
+										SocketAddress: &corev3.SocketAddress{
+											Address: host,
+											PortSpecifier: &corev3.SocketAddress_PortValue{
+												PortValue: port,
+											},
+										},
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
 	}
-	return string(b), nil
 }
 
 const (
@@ -217,47 +461,45 @@ const (
 	adminListenerName = "admin_proxy"
 )
 
-func createAdminCluster() (*anypb.Any, error) {
-	admin, err := anypb.New(&clusterv3.Cluster{
+// AdminPort is the port of the admin listener exposed by a proxy pod.
+const AdminPort = 9901
+
+// adminInternalPort is the port of the Envoy admin interface bound to the loopback.
+const adminInternalPort = 19901
+
+// ReservedPorts returns the ports which cannot be used as a transit port.
+func ReservedPorts() []int32 {
+	return []int32{AdminPort, adminInternalPort}
+}
+
+func createAdmin() *bootstrapv3.Admin {
+	return &bootstrapv3.Admin{
+		Address: &corev3.Address{
+			Address: &corev3.Address_SocketAddress{
+				SocketAddress: &corev3.SocketAddress{
+					Address: "127.0.0.1",
+					PortSpecifier: &corev3.SocketAddress_PortValue{
+						PortValue: adminInternalPort,
+					},
+				},
+			},
+		},
+	}
+}
+
+func createAdminCluster() *clusterv3.Cluster {
+	return &clusterv3.Cluster{
 		Name:           adminClusterName,
 		ConnectTimeout: durationpb.New(30 * time.Second),
 		ClusterDiscoveryType: &clusterv3.Cluster_Type{
 			Type: clusterv3.Cluster_LOGICAL_DNS,
 		},
 		DnsLookupFamily: clusterv3.Cluster_V4_ONLY,
-		LoadAssignment: &endpointv3.ClusterLoadAssignment{
-			ClusterName: adminClusterName,
-			Endpoints: []*endpointv3.LocalityLbEndpoints{
-				{
-					LbEndpoints: []*endpointv3.LbEndpoint{
-						{
-							HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
-								Endpoint: &endpointv3.Endpoint{
-									Address: &corev3.Address{
-										Address: &corev3.Address_SocketAddress{
-											SocketAddress: &corev3.SocketAddress{
-												Address: "127.0.0.1",
-												PortSpecifier: &corev3.SocketAddress_PortValue{
-													PortValue: 19901,
-												},
-											},
-										},
-									},
-								},
-							},
-						},
-					},
-				},
-			},
-		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("anypb.New(clusterv3.Cluster): %w", err)
+		LoadAssignment:  newLoadAssignment(adminClusterName, "127.0.0.1", adminInternalPort),
 	}
-	return admin, nil
 }
 
-func createAdminListener() (*anypb.Any, error) {
+func createAdminListener(dualStack bool) (*listenerv3.Listener, error) {
 	router, err := anypb.New(&routerv3.Router{})
 	if err != nil {
 		return nil, fmt.Errorf("anypb.New(routerv3.Router): %w", err)
@@ -307,6 +549,36 @@ func createAdminListener() (*anypb.Any, error) {
 									},
 								},
 							},
+							{
+								// for the controller to collect the statistics of tunnels
+								Match: &routev3.RouteMatch{
+									PathSpecifier: &routev3.RouteMatch_Path{
+										Path: "/stats",
+									},
+								},
+								Action: &routev3.Route_Route{
+									Route: &routev3.RouteAction{
+										ClusterSpecifier: &routev3.RouteAction_Cluster{
+											Cluster: adminClusterName,
+										},
+									},
+								},
+							},
+							{
+								// for the controller to collect the health of destinations
+								Match: &routev3.RouteMatch{
+									PathSpecifier: &routev3.RouteMatch_Path{
+										Path: "/clusters",
+									},
+								},
+								Action: &routev3.Route_Route{
+									Route: &routev3.RouteAction{
+										ClusterSpecifier: &routev3.RouteAction_Cluster{
+											Cluster: adminClusterName,
+										},
+									},
+								},
+							},
 						},
 					},
 				},
@@ -317,18 +589,9 @@ func createAdminListener() (*anypb.Any, error) {
 		return nil, fmt.Errorf("anypb.New(http_connection_managerv3.HttpConnectionManager): %w", err)
 	}
 
-	admin, err := anypb.New(&listenerv3.Listener{
-		Name: adminListenerName,
-		Address: &corev3.Address{
-			Address: &corev3.Address_SocketAddress{
-				SocketAddress: &corev3.SocketAddress{
-					Address: "0.0.0.0",
-					PortSpecifier: &corev3.SocketAddress_PortValue{
-						PortValue: 9901,
-					},
-				},
-			},
-		},
+	return &listenerv3.Listener{
+		Name:    adminListenerName,
+		Address: newListenerAddress(AdminPort, dualStack),
 		FilterChains: []*listenerv3.FilterChain{
 			{
 				Filters: []*listenerv3.Filter{
@@ -339,9 +602,5 @@ func createAdminListener() (*anypb.Any, error) {
 				},
 			},
 		},
-	})
-	if err != nil {
-		return nil, fmt.Errorf("anypb.New(listenerv3.Listener): %w", err)
-	}
-	return admin, nil
+	}, nil
 }
@@ -5,6 +5,7 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -30,7 +31,7 @@ func Test_generateCDS(t *testing.T) {
 				Proxy: corev1.LocalObjectReference{Name: "example"},
 			},
 		},
-	})
+	}, resourceOptions{})
 	if err != nil {
 		t.Fatalf("generateCDS: %s", err)
 	}
@@ -68,7 +69,7 @@ func Test_generateLDS(t *testing.T) {
 				TransitPort: ptr.To[int32](30000),
 			},
 		},
-	})
+	}, resourceOptions{})
 	if err != nil {
 		t.Fatalf("generateLDS: %s", err)
 	}
@@ -89,3 +90,77 @@ func Test_generateLDS(t *testing.T) {
 		t.Errorf("resources[1].@type wants %s but got %s", want, got)
 	}
 }
+
+func Test_generateADSBootstrap(t *testing.T) {
+	t.Run("valid address", func(t *testing.T) {
+		bootstrap, err := generateADSBootstrap("default/example", "ktunnels-xds-service.ktunnels-system.svc:18000")
+		if err != nil {
+			t.Fatalf("generateADSBootstrap: %s", err)
+		}
+		t.Logf("bootstrap=%s", bootstrap)
+
+		var bootstrapValue struct {
+			Node struct {
+				ID string `json:"id"`
+			} `json:"node"`
+			DynamicResources struct {
+				AdsConfig struct {
+					APIType string `json:"apiType"`
+				} `json:"adsConfig"`
+			} `json:"dynamicResources"`
+		}
+		if err := json.NewDecoder(strings.NewReader(bootstrap)).Decode(&bootstrapValue); err != nil {
+			t.Fatalf("unable to decode bootstrap json: %s", err)
+		}
+		if want, got := "default/example", bootstrapValue.Node.ID; want != got {
+			t.Errorf("node.id wants %s but got %s", want, got)
+		}
+		if want, got := "GRPC", bootstrapValue.DynamicResources.AdsConfig.APIType; want != got {
+			t.Errorf("dynamicResources.adsConfig.apiType wants %s but got %s", want, got)
+		}
+	})
+	t.Run("invalid address", func(t *testing.T) {
+		_, err := generateADSBootstrap("default/example", "ktunnels-xds-service")
+		if err == nil {
+			t.Errorf("generateADSBootstrap wants error but got nil")
+		}
+	})
+}
+
+func Test_newListeners(t *testing.T) {
+	t.Run("multiple ports", func(t *testing.T) {
+		listeners, err := newListeners([]*ktunnelsv1.Tunnel{
+			{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:      "broker",
+					Namespace: "default",
+				},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "broker.staging",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+						{Name: "management", Port: 15672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: "example"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPorts: []ktunnelsv1.TunnelTransitPort{
+						{Name: "amqp", TransitPort: 30000},
+						{Name: "management", TransitPort: 30001},
+					},
+				},
+			},
+		}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newListeners: %s", err)
+		}
+		var names []string
+		for _, listener := range listeners {
+			names = append(names, listener.GetName())
+		}
+		want := []string{"broker/amqp", "broker/management", adminListenerName}
+		if diff := cmp.Diff(want, names); diff != "" {
+			t.Errorf("listener names mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
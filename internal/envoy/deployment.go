@@ -13,7 +13,45 @@ import (
 
 const PodLabelKeyOfProxy = "ktunnels.int128.github.io/proxy"
 
-func NewDeployment(key types.NamespacedName, proxy ktunnelsv1.Proxy) appsv1.Deployment {
+// PodAnnotationKeyOfBootstrapHash is set to the hash of bootstrap.
+// Envoy reads the bootstrap only at startup, so the pods are restarted when the bootstrap is changed.
+const PodAnnotationKeyOfBootstrapHash = "ktunnels.int128.github.io/bootstrap-hash"
+
+// NewDeployment returns a Deployment of Envoy.
+func NewDeployment(key types.NamespacedName, proxy ktunnelsv1.Proxy, bootstrapHash string) appsv1.Deployment {
+	var podAnnotations map[string]string
+	if bootstrapHash != "" {
+		podAnnotations = map[string]string{
+			PodAnnotationKeyOfBootstrapHash: bootstrapHash,
+		}
+	}
+	// assume same name of Secret and Deployment
+	secretVolume := newSecretVolume(key.Name)
+	volumes := []corev1.Volume{
+		{
+			Name: "envoy-config",
+			VolumeSource: corev1.VolumeSource{
+				ConfigMap: &corev1.ConfigMapVolumeSource{
+					LocalObjectReference: corev1.LocalObjectReference{
+						// assume same name of ConfigMap and Deployment
+						Name: key.Name,
+					},
+				},
+			},
+		},
+		secretVolume,
+	}
+	volumeMounts := []corev1.VolumeMount{
+		{
+			Name:      "envoy-config",
+			MountPath: "/etc/envoy",
+		},
+		{
+			Name:      secretVolume.Name,
+			MountPath: secretMountPath,
+			ReadOnly:  true,
+		},
+	}
 	return appsv1.Deployment{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: key.Namespace,
@@ -31,6 +69,7 @@ func NewDeployment(key types.NamespacedName, proxy ktunnelsv1.Proxy) appsv1.Depl
 					Labels: map[string]string{
 						PodLabelKeyOfProxy: proxy.Name,
 					},
+					Annotations: podAnnotations,
 				},
 				Spec: corev1.PodSpec{
 					NodeSelector: proxy.Spec.Template.Spec.NodeSelector,
@@ -57,7 +96,7 @@ func NewDeployment(key types.NamespacedName, proxy ktunnelsv1.Proxy) appsv1.Depl
 							Ports: []corev1.ContainerPort{
 								{
 									Name:          "admin",
-									ContainerPort: 9901,
+									ContainerPort: AdminPort,
 								},
 							},
 							ReadinessProbe: &corev1.Probe{
@@ -78,28 +117,14 @@ func NewDeployment(key types.NamespacedName, proxy ktunnelsv1.Proxy) appsv1.Depl
 							SecurityContext: &corev1.SecurityContext{
 								AllowPrivilegeEscalation: ptr.To(false),
 							},
-							VolumeMounts: []corev1.VolumeMount{
-								{
-									Name:      "envoy-config",
-									MountPath: "/etc/envoy",
-								},
-							},
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "envoy-config",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										// assume same name of ConfigMap and Deployment
-										Name: key.Name,
-									},
-								},
-							},
+							VolumeMounts: volumeMounts,
 						},
 					},
+					Volumes:          volumes,
 					ImagePullSecrets: proxy.Spec.Template.Spec.ImagePullSecrets,
+					DNSPolicy:        proxy.Spec.Template.Spec.DNSPolicy,
+					DNSConfig:        proxy.Spec.Template.Spec.DNSConfig,
+					HostAliases:      proxy.Spec.Template.Spec.HostAliases,
 				},
 			},
 		},
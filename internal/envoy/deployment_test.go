@@ -24,6 +24,7 @@ func TestNewDeployment(t *testing.T) {
 					Name:      "example",
 				},
 			},
+			"",
 		)
 		want := appsv1.Deployment{
 			ObjectMeta: metav1.ObjectMeta{
@@ -85,6 +86,11 @@ func TestNewDeployment(t *testing.T) {
 										Name:      "envoy-config",
 										MountPath: "/etc/envoy",
 									},
+									{
+										Name:      "envoy-secrets",
+										MountPath: "/etc/envoy-secrets",
+										ReadOnly:  true,
+									},
 								},
 							},
 						},
@@ -99,6 +105,12 @@ func TestNewDeployment(t *testing.T) {
 									},
 								},
 							},
+							{
+								Name: "envoy-secrets",
+								VolumeSource: corev1.VolumeSource{
+									Secret: &corev1.SecretVolumeSource{SecretName: "ktunnels-proxy-example"},
+								},
+							},
 						},
 					},
 				},
@@ -122,6 +134,9 @@ func TestNewDeployment(t *testing.T) {
 					Template: ktunnelsv1.ProxyPod{
 						Spec: ktunnelsv1.ProxyPodSpec{
 							ImagePullSecrets: []corev1.LocalObjectReference{{Name: "docker-hub"}},
+							DNSPolicy:        corev1.DNSNone,
+							DNSConfig:        &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.2"}},
+							HostAliases:      []corev1.HostAlias{{IP: "10.1.2.3", Hostnames: []string{"db.corp.example.com"}}},
 							Envoy: ktunnelsv1.ProxyEnvoy{
 								Image: ptr.To("1234567890.dkr.ecr.us-east-1.amazonaws.com/envoy:v9.99"),
 								Resources: &corev1.ResourceRequirements{
@@ -138,6 +153,7 @@ func TestNewDeployment(t *testing.T) {
 					},
 				},
 			},
+			"0123456789abcdef",
 		)
 		want := appsv1.Deployment{
 			ObjectMeta: metav1.ObjectMeta{
@@ -156,6 +172,9 @@ func TestNewDeployment(t *testing.T) {
 						Labels: map[string]string{
 							PodLabelKeyOfProxy: "example",
 						},
+						Annotations: map[string]string{
+							PodAnnotationKeyOfBootstrapHash: "0123456789abcdef",
+						},
 					},
 					Spec: corev1.PodSpec{
 						Containers: []corev1.Container{
@@ -200,6 +219,11 @@ func TestNewDeployment(t *testing.T) {
 										Name:      "envoy-config",
 										MountPath: "/etc/envoy",
 									},
+									{
+										Name:      "envoy-secrets",
+										MountPath: "/etc/envoy-secrets",
+										ReadOnly:  true,
+									},
 								},
 							},
 						},
@@ -214,8 +238,17 @@ func TestNewDeployment(t *testing.T) {
 									},
 								},
 							},
+							{
+								Name: "envoy-secrets",
+								VolumeSource: corev1.VolumeSource{
+									Secret: &corev1.SecretVolumeSource{SecretName: "ktunnels-proxy-example"},
+								},
+							},
 						},
 						ImagePullSecrets: []corev1.LocalObjectReference{{Name: "docker-hub"}},
+						DNSPolicy:        corev1.DNSNone,
+						DNSConfig:        &corev1.PodDNSConfig{Nameservers: []string{"10.0.0.2"}},
+						HostAliases:      []corev1.HostAlias{{IP: "10.1.2.3", Hostnames: []string{"db.corp.example.com"}}},
 					},
 				},
 			},
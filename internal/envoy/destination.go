@@ -0,0 +1,77 @@
+package envoy
+
+import (
+	"maps"
+	"net/netip"
+	"slices"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	endpointv3 "github.com/envoyproxy/go-control-plane/envoy/config/endpoint/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// newDestinationLoadAssignment returns the endpoints of the destinations.
+// The priorities are renumbered from 0 without gaps, as required by Envoy.
+func newDestinationLoadAssignment(clusterName string, destinations []ktunnelsv1.TunnelDestination, port uint32) *endpointv3.ClusterLoadAssignment {
+	lbEndpointsByPriority := make(map[int32][]*endpointv3.LbEndpoint)
+	for _, destination := range destinations {
+		lbEndpoint := &endpointv3.LbEndpoint{
+			HostIdentifier: &endpointv3.LbEndpoint_Endpoint{
+				Endpoint: &endpointv3.Endpoint{
+					Address: &corev3.Address{
+						Address: &corev3.Address_SocketAddress{
+							SocketAddress: &corev3.SocketAddress{
+								Address: destination.Host,
+								PortSpecifier: &corev3.SocketAddress_PortValue{
+									PortValue: port,
+								},
+							},
+						},
+					},
+				},
+			},
+		}
+		if destination.Weight != nil {
+			lbEndpoint.LoadBalancingWeight = wrapperspb.UInt32(uint32(*destination.Weight))
+		}
+		lbEndpointsByPriority[destination.Priority] = append(lbEndpointsByPriority[destination.Priority], lbEndpoint)
+	}
+
+	var endpoints []*endpointv3.LocalityLbEndpoints
+	for i, priority := range slices.Sorted(maps.Keys(lbEndpointsByPriority)) {
+		endpoints = append(endpoints, &endpointv3.LocalityLbEndpoints{
+			Priority:    uint32(i),
+			LbEndpoints: lbEndpointsByPriority[priority],
+		})
+	}
+	return &endpointv3.ClusterLoadAssignment{
+		ClusterName: clusterName,
+		Endpoints:   endpoints,
+	}
+}
+
+// isStaticDestinations returns true if all hosts are IP addresses.
+func isStaticDestinations(destinations []ktunnelsv1.TunnelDestination) bool {
+	for _, destination := range destinations {
+		if _, err := netip.ParseAddr(destination.Host); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// newOutlierDetection ejects a destination refusing connections, if there are multiple destinations.
+// The local origin errors such as a connection failure are counted separately,
+// and all destinations of a priority can be ejected to fail over to the next priority.
+func newOutlierDetection(destinations []ktunnelsv1.TunnelDestination) *clusterv3.OutlierDetection {
+	if len(destinations) < 2 {
+		return nil
+	}
+	return &clusterv3.OutlierDetection{
+		SplitExternalLocalOriginErrors: true,
+		ConsecutiveLocalOriginFailure:  wrapperspb.UInt32(3),
+		MaxEjectionPercent:             wrapperspb.UInt32(100),
+	}
+}
@@ -0,0 +1,99 @@
+package envoy
+
+import (
+	"testing"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_destinations(t *testing.T) {
+	newTunnel := func(destinations ...ktunnelsv1.TunnelDestination) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "payment-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:         "payment-database.staging",
+				Destinations: destinations,
+				Port:         5432,
+			},
+		}
+	}
+	type endpoint struct {
+		Priority uint32
+		Host     string
+		Weight   uint32
+	}
+	endpointsOf := func(cluster *clusterv3.Cluster) []endpoint {
+		var endpoints []endpoint
+		for _, localityLbEndpoints := range cluster.GetLoadAssignment().GetEndpoints() {
+			for _, lbEndpoint := range localityLbEndpoints.GetLbEndpoints() {
+				endpoints = append(endpoints, endpoint{
+					Priority: localityLbEndpoints.GetPriority(),
+					Host:     lbEndpoint.GetEndpoint().GetAddress().GetSocketAddress().GetAddress(),
+					Weight:   lbEndpoint.GetLoadBalancingWeight().GetValue(),
+				})
+			}
+		}
+		return endpoints
+	}
+
+	t.Run("host", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{newTunnel()}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		want := []endpoint{{Host: "payment-database.staging"}}
+		if diff := cmp.Diff(want, endpointsOf(clusters[0])); diff != "" {
+			t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+		}
+		if clusters[0].GetOutlierDetection() != nil {
+			t.Errorf("outlierDetection wants nil but got %s", clusters[0].GetOutlierDetection())
+		}
+	})
+	t.Run("weighted", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{newTunnel(
+			ktunnelsv1.TunnelDestination{Host: "old-database.staging", Weight: ptr.To[int32](90)},
+			ktunnelsv1.TunnelDestination{Host: "new-database.staging", Weight: ptr.To[int32](10)},
+		)}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		want := []endpoint{
+			{Host: "old-database.staging", Weight: 90},
+			{Host: "new-database.staging", Weight: 10},
+		}
+		if diff := cmp.Diff(want, endpointsOf(clusters[0])); diff != "" {
+			t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+		}
+		if got := clusters[0].GetType(); got != clusterv3.Cluster_STRICT_DNS {
+			t.Errorf("type wants STRICT_DNS but got %s", got)
+		}
+		if !clusters[0].GetOutlierDetection().GetSplitExternalLocalOriginErrors() {
+			t.Errorf("splitExternalLocalOriginErrors wants true")
+		}
+	})
+	t.Run("failover", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{newTunnel(
+			ktunnelsv1.TunnelDestination{Host: "10.1.2.4", Priority: 10},
+			ktunnelsv1.TunnelDestination{Host: "10.1.2.3", Priority: 5},
+			ktunnelsv1.TunnelDestination{Host: "10.1.2.5", Priority: 10},
+		)}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		want := []endpoint{
+			{Priority: 0, Host: "10.1.2.3"},
+			{Priority: 1, Host: "10.1.2.4"},
+			{Priority: 1, Host: "10.1.2.5"},
+		}
+		if diff := cmp.Diff(want, endpointsOf(clusters[0])); diff != "" {
+			t.Errorf("endpoints mismatch (-want +got):\n%s", diff)
+		}
+		if got := clusters[0].GetType(); got != clusterv3.Cluster_STATIC {
+			t.Errorf("type wants STATIC but got %s", got)
+		}
+	})
+}
@@ -0,0 +1,132 @@
+package envoy
+
+import (
+	"cmp"
+	"fmt"
+	"net/netip"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	caresv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/network/dns_resolver/cares/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/utils/ptr"
+)
+
+// dnsOf returns the DNS resolution of the tunnel.
+// Each field of the tunnel takes precedence over the default of the proxy.
+func dnsOf(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) ktunnelsv1.TunnelDNS {
+	proxyDNS := ptr.Deref(opts.dns, ktunnelsv1.TunnelDNS{})
+	tunnelDNS := ptr.Deref(tunnel.Spec.DNS, ktunnelsv1.TunnelDNS{})
+	return ktunnelsv1.TunnelDNS{
+		LookupFamily: cmp.Or(tunnelDNS.LookupFamily, proxyDNS.LookupFamily, ktunnelsv1.DNSLookupFamilyV4Only),
+		Resolution:   cmp.Or(tunnelDNS.Resolution, proxyDNS.Resolution, ktunnelsv1.DNSResolutionLogical),
+		RefreshRate:  mergePointer(proxyDNS.RefreshRate, tunnelDNS.RefreshRate),
+		RespectTTL:   mergePointer(proxyDNS.RespectTTL, tunnelDNS.RespectTTL),
+	}
+}
+
+// newClusterDiscoveryType returns STATIC if all hosts are IP addresses.
+// Otherwise, it returns the type of the DNS resolution.
+// It returns STRICT_DNS for multiple hosts, because LOGICAL_DNS supports only a single host.
+func newClusterDiscoveryType(destinations []ktunnelsv1.TunnelDestination, dns ktunnelsv1.TunnelDNS) *clusterv3.Cluster_Type {
+	if isStaticDestinations(destinations) {
+		return &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STATIC}
+	}
+	if len(destinations) > 1 || dns.Resolution == ktunnelsv1.DNSResolutionStrict {
+		return &clusterv3.Cluster_Type{Type: clusterv3.Cluster_STRICT_DNS}
+	}
+	return &clusterv3.Cluster_Type{Type: clusterv3.Cluster_LOGICAL_DNS}
+}
+
+func newDNSLookupFamily(lookupFamily ktunnelsv1.DNSLookupFamily) clusterv3.Cluster_DnsLookupFamily {
+	switch lookupFamily {
+	case ktunnelsv1.DNSLookupFamilyV6Only:
+		return clusterv3.Cluster_V6_ONLY
+	case ktunnelsv1.DNSLookupFamilyV4Preferred:
+		return clusterv3.Cluster_V4_PREFERRED
+	case ktunnelsv1.DNSLookupFamilyAuto:
+		return clusterv3.Cluster_AUTO
+	case ktunnelsv1.DNSLookupFamilyAll:
+		return clusterv3.Cluster_ALL
+	default:
+		return clusterv3.Cluster_V4_ONLY
+	}
+}
+
+// ParseDNSResolver parses the address of a DNS server, such as 10.0.0.2 or 10.0.0.2:53.
+// If the port is omitted, it returns the default port of DNS.
+func ParseDNSResolver(resolver string) (netip.AddrPort, error) {
+	if addr, err := netip.ParseAddr(resolver); err == nil {
+		return netip.AddrPortFrom(addr, dnsPort), nil
+	}
+	addrPort, err := netip.ParseAddrPort(resolver)
+	if err != nil {
+		return netip.AddrPort{}, fmt.Errorf("invalid DNS resolver %q: %w", resolver, err)
+	}
+	return addrPort, nil
+}
+
+// newDNSResolverConfig returns the c-ares resolver which queries the DNS servers.
+// It returns nil if no DNS server is given, so that Envoy uses the DNS servers of the pod.
+func newDNSResolverConfig(resolvers []string) (*corev3.TypedExtensionConfig, error) {
+	if len(resolvers) == 0 {
+		return nil, nil
+	}
+	var addresses []*corev3.Address
+	for _, resolver := range resolvers {
+		addrPort, err := ParseDNSResolver(resolver)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, &corev3.Address{
+			Address: &corev3.Address_SocketAddress{
+				SocketAddress: &corev3.SocketAddress{
+					Address:       addrPort.Addr().String(),
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: uint32(addrPort.Port())},
+				},
+			},
+		})
+	}
+	caresConfig, err := anypb.New(&caresv3.CaresDnsResolverConfig{Resolvers: addresses})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(caresv3.CaresDnsResolverConfig): %w", err)
+	}
+	return &corev3.TypedExtensionConfig{
+		Name:        "envoy.network.dns_resolver.cares",
+		TypedConfig: caresConfig,
+	}, nil
+}
+
+// newListenerAddress returns the address to bind a listener.
+// If dualStack is true, it binds both IPv4 and IPv6.
+func newListenerAddress(port uint32, dualStack bool) *corev3.Address {
+	if dualStack {
+		return &corev3.Address{
+			Address: &corev3.Address_SocketAddress{
+				SocketAddress: &corev3.SocketAddress{
+					Address:       "::",
+					Ipv4Compat:    true,
+					PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: port},
+				},
+			},
+		}
+	}
+	return &corev3.Address{
+		Address: &corev3.Address_SocketAddress{
+			SocketAddress: &corev3.SocketAddress{
+				Address:       "0.0.0.0",
+				PortSpecifier: &corev3.SocketAddress_PortValue{PortValue: port},
+			},
+		},
+	}
+}
+
+// newIPFamilyPolicy returns the IP family policy of the Services of the proxy.
+func newIPFamilyPolicy(proxy ktunnelsv1.Proxy) *corev1.IPFamilyPolicy {
+	if proxy.Spec.DualStack {
+		return ptr.To(corev1.IPFamilyPolicyPreferDualStack)
+	}
+	return ptr.To(corev1.IPFamilyPolicySingleStack)
+}
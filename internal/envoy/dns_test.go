@@ -0,0 +1,165 @@
+package envoy
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	caresv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/network/dns_resolver/cares/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func Test_dns(t *testing.T) {
+	t.Run("default", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "redis.staging", Port: 6379},
+		}}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		cluster := clusters[0]
+		if got := cluster.GetType(); got != clusterv3.Cluster_LOGICAL_DNS {
+			t.Errorf("type wants LOGICAL_DNS but got %s", got)
+		}
+		if got := cluster.GetDnsLookupFamily(); got != clusterv3.Cluster_V4_ONLY {
+			t.Errorf("dnsLookupFamily wants V4_ONLY but got %s", got)
+		}
+		if cluster.GetDnsRefreshRate() != nil {
+			t.Errorf("dnsRefreshRate wants nil but got %s", cluster.GetDnsRefreshRate())
+		}
+	})
+	t.Run("tunnel overrides the default of proxy", func(t *testing.T) {
+		opts := resourceOptions{dns: &ktunnelsv1.TunnelDNS{
+			LookupFamily: ktunnelsv1.DNSLookupFamilyAll,
+			Resolution:   ktunnelsv1.DNSResolutionStrict,
+			RefreshRate:  &metav1.Duration{Duration: 30 * time.Second},
+		}}
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "microservice-database.staging",
+				Port: 5432,
+				DNS: &ktunnelsv1.TunnelDNS{
+					LookupFamily: ktunnelsv1.DNSLookupFamilyV6Only,
+					RespectTTL:   ptr.To(true),
+				},
+			},
+		}}, opts)
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		cluster := clusters[0]
+		if got := cluster.GetType(); got != clusterv3.Cluster_STRICT_DNS {
+			t.Errorf("type wants STRICT_DNS but got %s", got)
+		}
+		if got := cluster.GetDnsLookupFamily(); got != clusterv3.Cluster_V6_ONLY {
+			t.Errorf("dnsLookupFamily wants V6_ONLY but got %s", got)
+		}
+		if got := cluster.GetDnsRefreshRate().AsDuration(); got != 30*time.Second {
+			t.Errorf("dnsRefreshRate wants 30s but got %s", got)
+		}
+		if !cluster.GetRespectDnsTtl() {
+			t.Errorf("respectDnsTtl wants true")
+		}
+	})
+	t.Run("IP address", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "message-broker", Namespace: "default"},
+				Spec:       ktunnelsv1.TunnelSpec{Host: "10.1.2.3", Port: 5672},
+			},
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "ipv6-database", Namespace: "default"},
+				Spec:       ktunnelsv1.TunnelSpec{Host: "fd00::1", Port: 5432},
+			},
+		}, resourceOptions{dns: &ktunnelsv1.TunnelDNS{Resolution: ktunnelsv1.DNSResolutionStrict}})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		for _, cluster := range clusters[:2] {
+			if got := cluster.GetType(); got != clusterv3.Cluster_STATIC {
+				t.Errorf("%s: type wants STATIC but got %s", cluster.GetName(), got)
+			}
+		}
+	})
+}
+
+func Test_dualStack(t *testing.T) {
+	tunnel := &ktunnelsv1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+		Spec: ktunnelsv1.TunnelSpec{
+			Host:  "microservice-database.staging",
+			Port:  5432,
+			Proxy: corev1.LocalObjectReference{Name: "default"},
+		},
+		Status: ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20001)},
+	}
+
+	t.Run("listeners", func(t *testing.T) {
+		listeners, err := newListeners([]*ktunnelsv1.Tunnel{tunnel}, resourceOptions{dualStack: true})
+		if err != nil {
+			t.Fatalf("newListeners: %s", err)
+		}
+		for _, listener := range listeners {
+			socketAddress := listener.GetAddress().GetSocketAddress()
+			if socketAddress.GetAddress() != "::" {
+				t.Errorf("%s: address wants :: but got %s", listener.GetName(), socketAddress.GetAddress())
+			}
+			if !socketAddress.GetIpv4Compat() {
+				t.Errorf("%s: ipv4Compat wants true", listener.GetName())
+			}
+		}
+	})
+	t.Run("service", func(t *testing.T) {
+		proxy := ktunnelsv1.Proxy{
+			ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"},
+			Spec:       ktunnelsv1.ProxySpec{DualStack: true},
+		}
+		svc := NewService(types.NamespacedName{Namespace: "default", Name: "microservice-database"}, proxy, *tunnel)
+		if got := ptr.Deref(svc.Spec.IPFamilyPolicy, ""); got != corev1.IPFamilyPolicyPreferDualStack {
+			t.Errorf("ipFamilyPolicy wants PreferDualStack but got %s", got)
+		}
+	})
+}
+
+func Test_newDNSResolverConfig(t *testing.T) {
+	t.Run("no resolver", func(t *testing.T) {
+		config, err := newDNSResolverConfig(nil)
+		if err != nil {
+			t.Fatalf("newDNSResolverConfig: %s", err)
+		}
+		if config != nil {
+			t.Errorf("config wants nil but got %s", config)
+		}
+	})
+	t.Run("resolvers", func(t *testing.T) {
+		config, err := newDNSResolverConfig([]string{"10.0.0.2", "[fd00::2]:5353"})
+		if err != nil {
+			t.Fatalf("newDNSResolverConfig: %s", err)
+		}
+		var caresConfig caresv3.CaresDnsResolverConfig
+		if err := config.GetTypedConfig().UnmarshalTo(&caresConfig); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		var got []string
+		for _, resolver := range caresConfig.GetResolvers() {
+			socketAddress := resolver.GetSocketAddress()
+			got = append(got, fmt.Sprintf("%s:%d", socketAddress.GetAddress(), socketAddress.GetPortValue()))
+		}
+		if diff := cmp.Diff([]string{"10.0.0.2:53", "fd00::2:5353"}, got); diff != "" {
+			t.Errorf("resolvers mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("hostname", func(t *testing.T) {
+		if _, err := newDNSResolverConfig([]string{"dns.corp.example.com"}); err == nil {
+			t.Errorf("newDNSResolverConfig wants an error but got nil")
+		}
+	})
+}
@@ -0,0 +1,136 @@
+package envoy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	adminv3 "github.com/envoyproxy/go-control-plane/envoy/admin/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+const (
+	defaultHealthCheckInterval           = 10 * time.Second
+	defaultHealthCheckTimeout            = 5 * time.Second
+	defaultHealthCheckUnhealthyThreshold = 3
+	defaultHealthCheckHealthyThreshold   = 1
+)
+
+// newHealthChecks returns the active health checks of the destinations.
+// It returns nil if the health check is not enabled.
+func newHealthChecks(healthCheck *ktunnelsv1.TunnelHealthCheck) []*corev3.HealthCheck {
+	if healthCheck == nil {
+		return nil
+	}
+	hc := &corev3.HealthCheck{
+		Interval: newDurationOrDefault(healthCheck.Interval, defaultHealthCheckInterval),
+		Timeout:  newDurationOrDefault(healthCheck.Timeout, defaultHealthCheckTimeout),
+		UnhealthyThreshold: wrapperspb.UInt32(uint32(
+			ptr.Deref(healthCheck.UnhealthyThreshold, defaultHealthCheckUnhealthyThreshold))),
+		HealthyThreshold: wrapperspb.UInt32(uint32(
+			ptr.Deref(healthCheck.HealthyThreshold, defaultHealthCheckHealthyThreshold))),
+		HealthChecker: &corev3.HealthCheck_TcpHealthCheck_{
+			// connect only
+			TcpHealthCheck: &corev3.HealthCheck_TcpHealthCheck{},
+		},
+	}
+	if healthCheck.HTTPPath != "" {
+		hc.HealthChecker = &corev3.HealthCheck_HttpHealthCheck_{
+			HttpHealthCheck: &corev3.HealthCheck_HttpHealthCheck{Path: healthCheck.HTTPPath},
+		}
+	}
+	return []*corev3.HealthCheck{hc}
+}
+
+func newDurationOrDefault(d *metav1.Duration, defaultValue time.Duration) *durationpb.Duration {
+	if d == nil {
+		return durationpb.New(defaultValue)
+	}
+	return durationpb.New(d.Duration)
+}
+
+// TunnelHealth represents the result of the health checks of a tunnel in an Envoy instance.
+// The destinations are counted for each port.
+type TunnelHealth struct {
+	// Number of the destinations which passed the health check.
+	Healthy int
+	// Number of the destinations which failed the health check.
+	Unhealthy int
+}
+
+// Add returns the sum of the results.
+func (h TunnelHealth) Add(o TunnelHealth) TunnelHealth {
+	return TunnelHealth{
+		Healthy:   h.Healthy + o.Healthy,
+		Unhealthy: h.Unhealthy + o.Unhealthy,
+	}
+}
+
+// GetTunnelHealth fetches the health of the destinations from the admin endpoint of an Envoy instance.
+// It returns a map of the tunnel name to the result, summed over the ports.
+// A tunnel without health check is not included.
+// A destination pending the first health check is not counted.
+func GetTunnelHealth(ctx context.Context, httpClient *http.Client, adminURL string, tunnels []*ktunnelsv1.Tunnel) (map[string]TunnelHealth, error) {
+	q := url.Values{}
+	q.Set("format", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/clusters?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code wants 200 but was %d", resp.StatusCode)
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read clusters json: %w", err)
+	}
+	var clusters adminv3.Clusters
+	if err := (protojson.UnmarshalOptions{DiscardUnknown: true}).Unmarshal(b, &clusters); err != nil {
+		return nil, fmt.Errorf("unable to decode clusters json: %w", err)
+	}
+
+	tunnelNameByClusterName := make(map[string]string)
+	for _, tunnel := range tunnels {
+		if tunnel.Spec.HealthCheck == nil {
+			continue
+		}
+		for _, port := range tunnel.GetPorts() {
+			tunnelNameByClusterName[resourceNameOf(tunnel, port.Name)] = tunnel.Name
+		}
+	}
+	healthByTunnelName := make(map[string]TunnelHealth)
+	for _, clusterStatus := range clusters.GetClusterStatuses() {
+		tunnelName, ok := tunnelNameByClusterName[clusterStatus.GetName()]
+		if !ok {
+			continue
+		}
+		h := healthByTunnelName[tunnelName]
+		for _, hostStatus := range clusterStatus.GetHostStatuses() {
+			healthStatus := hostStatus.GetHealthStatus()
+			switch {
+			case healthStatus.GetFailedActiveHealthCheck():
+				h.Unhealthy++
+			case healthStatus.GetPendingActiveHc():
+				// not checked yet
+			default:
+				h.Healthy++
+			}
+		}
+		healthByTunnelName[tunnelName] = h
+	}
+	return healthByTunnelName, nil
+}
@@ -0,0 +1,107 @@
+package envoy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newHealthChecks(t *testing.T) {
+	t.Run("disabled", func(t *testing.T) {
+		if got := newHealthChecks(nil); got != nil {
+			t.Errorf("healthChecks wants nil but got %v", got)
+		}
+	})
+	t.Run("tcp", func(t *testing.T) {
+		got := newHealthChecks(&ktunnelsv1.TunnelHealthCheck{})
+		if len(got) != 1 {
+			t.Fatalf("len(healthChecks) wants 1 but got %d", len(got))
+		}
+		if got[0].GetTcpHealthCheck() == nil {
+			t.Errorf("tcpHealthCheck wants non-nil")
+		}
+		if got := got[0].GetInterval().AsDuration(); got != defaultHealthCheckInterval {
+			t.Errorf("interval wants %s but got %s", defaultHealthCheckInterval, got)
+		}
+		if got := got[0].GetUnhealthyThreshold().GetValue(); got != defaultHealthCheckUnhealthyThreshold {
+			t.Errorf("unhealthyThreshold wants %d but got %d", defaultHealthCheckUnhealthyThreshold, got)
+		}
+	})
+	t.Run("http", func(t *testing.T) {
+		got := newHealthChecks(&ktunnelsv1.TunnelHealthCheck{
+			Interval: &metav1.Duration{Duration: 30 * time.Second},
+			HTTPPath: "/healthz",
+		})
+		if got := got[0].GetHttpHealthCheck().GetPath(); got != "/healthz" {
+			t.Errorf("path wants /healthz but got %s", got)
+		}
+		if got := got[0].GetInterval().AsDuration(); got != 30*time.Second {
+			t.Errorf("interval wants 30s but got %s", got)
+		}
+	})
+}
+
+func TestGetTunnelHealth(t *testing.T) {
+	sv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/clusters" {
+			http.NotFound(w, r)
+			return
+		}
+		_, _ = w.Write([]byte(`{"cluster_statuses": [
+  {"name": "payment-database", "host_statuses": [
+    {"health_status": {"eds_health_status": "HEALTHY"}},
+    {"health_status": {"failed_active_health_check": true, "eds_health_status": "HEALTHY"}}
+  ]},
+  {"name": "redis", "host_statuses": [
+    {"health_status": {"pending_active_hc": true, "eds_health_status": "HEALTHY"}}
+  ]},
+  {"name": "message-broker", "host_statuses": [
+    {"health_status": {"eds_health_status": "HEALTHY"}}
+  ]},
+  {"name": "admin", "host_statuses": [
+    {"health_status": {"eds_health_status": "HEALTHY"}}
+  ]}
+]}`))
+	}))
+	t.Cleanup(sv.Close)
+
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "payment-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:        "payment-database.staging",
+				Port:        5432,
+				HealthCheck: &ktunnelsv1.TunnelHealthCheck{},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:        "redis.staging",
+				Port:        6379,
+				HealthCheck: &ktunnelsv1.TunnelHealthCheck{},
+			},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "message-broker", Namespace: "default"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "message-broker.staging", Port: 5672},
+		},
+	}
+	got, err := GetTunnelHealth(context.TODO(), sv.Client(), sv.URL, tunnels)
+	if err != nil {
+		t.Fatalf("GetTunnelHealth: %s", err)
+	}
+	want := map[string]TunnelHealth{
+		"payment-database": {Healthy: 1, Unhealthy: 1},
+		"redis":            {},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("health mismatch (-want +got):\n%s", diff)
+	}
+}
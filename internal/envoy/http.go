@@ -0,0 +1,161 @@
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	credential_injectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/credential_injector/v3"
+	routerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/router/v3"
+	http_connection_managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	genericv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/http/injected_credentials/generic/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// sdsSecretKindHeader is the kind of the SDS secret containing the value of a request header.
+const sdsSecretKindHeader = "header"
+
+// headerSecretKeyRefsOf returns the secret keys referenced by the request headers of the tunnel.
+func headerSecretKeyRefsOf(tunnel *ktunnelsv1.Tunnel) []*corev1.SecretKeySelector {
+	if tunnel.Spec.HTTP == nil {
+		return nil
+	}
+	var refs []*corev1.SecretKeySelector
+	for _, header := range tunnel.Spec.HTTP.RequestHeaders {
+		if header.SecretKeyRef != nil {
+			refs = append(refs, header.SecretKeyRef)
+		}
+	}
+	return refs
+}
+
+func headerSdsSecretNameOf(ref *corev1.SecretKeySelector) string {
+	return sdsSecretNameOf(sdsSecretKindHeader, path.Join(ref.Name, ref.Key))
+}
+
+// hostRewriteOf returns the Host header sent to the destination.
+// The port is omitted if it is the default port of the scheme.
+func hostRewriteOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	if tunnel.Spec.HTTP.HostRewrite != "" {
+		return tunnel.Spec.HTTP.HostRewrite
+	}
+	host := tunnel.GetDestinations()[0].Host
+	defaultPort := int32(80)
+	if tunnel.Spec.TLS != nil {
+		defaultPort = 443
+	}
+	for _, port := range tunnel.GetPorts() {
+		if port.Name == portName && port.Port != defaultPort {
+			return net.JoinHostPort(host, strconv.Itoa(int(port.Port)))
+		}
+	}
+	return host
+}
+
+// newHTTPConnectionManagerFilter returns the HTTP connection manager which sends all requests to the cluster.
+// It replaces the TCP proxy in the HTTP mode.
+// A header from a literal value is added by the route,
+// and a header from a secret is injected by the credential injector, so that the value is not written to the ConfigMap.
+func newHTTPConnectionManagerFilter(tunnel *ktunnelsv1.Tunnel, portName string, opts resourceOptions) (*listenerv3.Filter, error) {
+	name := resourceNameOf(tunnel, portName)
+	var requestHeadersToAdd []*corev3.HeaderValueOption
+	var httpFilters []*http_connection_managerv3.HttpFilter
+	for _, header := range tunnel.Spec.HTTP.RequestHeaders {
+		if header.SecretKeyRef == nil {
+			requestHeadersToAdd = append(requestHeadersToAdd, &corev3.HeaderValueOption{
+				Header:       &corev3.HeaderValue{Key: header.Name, Value: header.Value},
+				AppendAction: corev3.HeaderValueOption_OVERWRITE_IF_EXISTS_OR_ADD,
+			})
+			continue
+		}
+		credentialInjector, err := newCredentialInjector(header.Name, header.SecretKeyRef)
+		if err != nil {
+			return nil, err
+		}
+		httpFilters = append(httpFilters, &http_connection_managerv3.HttpFilter{
+			Name:       fmt.Sprintf("envoy.filters.http.credential_injector/%s", header.Name),
+			ConfigType: &http_connection_managerv3.HttpFilter_TypedConfig{TypedConfig: credentialInjector},
+		})
+	}
+	router, err := anypb.New(&routerv3.Router{})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(routerv3.Router): %w", err)
+	}
+	httpFilters = append(httpFilters, &http_connection_managerv3.HttpFilter{
+		Name:       "envoy.filters.http.router",
+		ConfigType: &http_connection_managerv3.HttpFilter_TypedConfig{TypedConfig: router},
+	})
+
+	timeouts := timeoutsOf(tunnel, opts)
+	return newFilter("envoy.filters.network.http_connection_manager", &http_connection_managerv3.HttpConnectionManager{
+		StatPrefix:  StatPrefixOf(tunnel, portName),
+		HttpFilters: httpFilters,
+		CommonHttpProtocolOptions: &corev3.HttpProtocolOptions{
+			IdleTimeout:           newDurationOrNil(timeouts.Idle),
+			MaxConnectionDuration: newDurationOrNil(timeouts.MaxConnectionDuration),
+		},
+		// for the web consoles
+		UpgradeConfigs: []*http_connection_managerv3.HttpConnectionManager_UpgradeConfig{
+			{UpgradeType: "websocket"},
+		},
+		RouteSpecifier: &http_connection_managerv3.HttpConnectionManager_RouteConfig{
+			RouteConfig: &routev3.RouteConfiguration{
+				Name: name,
+				VirtualHosts: []*routev3.VirtualHost{
+					{
+						Name:                name,
+						Domains:             []string{"*"},
+						RequestHeadersToAdd: requestHeadersToAdd,
+						Routes: []*routev3.Route{
+							{
+								Match: &routev3.RouteMatch{
+									PathSpecifier: &routev3.RouteMatch_Prefix{Prefix: "/"},
+								},
+								Action: &routev3.Route_Route{
+									Route: &routev3.RouteAction{
+										ClusterSpecifier: &routev3.RouteAction_Cluster{Cluster: name},
+										HostRewriteSpecifier: &routev3.RouteAction_HostRewriteLiteral{
+											HostRewriteLiteral: hostRewriteOf(tunnel, portName),
+										},
+										// a long polling or streaming request should not be cut, as well as a TCP connection
+										Timeout: durationpb.New(0),
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	})
+}
+
+// newCredentialInjector returns the credential injector of the header from the secret.
+// The secret is read from the SDS file in the Secret of the proxy.
+func newCredentialInjector(headerName string, ref *corev1.SecretKeySelector) (*anypb.Any, error) {
+	credential, err := anypb.New(&genericv3.Generic{
+		Credential: newSdsSecretConfig(headerSdsSecretNameOf(ref)),
+		Header:     headerName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(genericv3.Generic): %w", err)
+	}
+	credentialInjector, err := anypb.New(&credential_injectorv3.CredentialInjector{
+		Overwrite: true,
+		Credential: &corev3.TypedExtensionConfig{
+			Name:        "envoy.http.injected_credentials.generic",
+			TypedConfig: credential,
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(credential_injectorv3.CredentialInjector): %w", err)
+	}
+	return credentialInjector, nil
+}
@@ -0,0 +1,138 @@
+package envoy
+
+import (
+	"testing"
+
+	routev3 "github.com/envoyproxy/go-control-plane/envoy/config/route/v3"
+	http_connection_managerv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_http(t *testing.T) {
+	apiKeyRef := &corev1.SecretKeySelector{
+		LocalObjectReference: corev1.LocalObjectReference{Name: "payment-api"},
+		Key:                  "api-key",
+	}
+	newTunnel := func(port int32, tlsSpec *ktunnelsv1.TunnelTLS) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "payment-api", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "payment-api.staging",
+				Port: port,
+				TLS:  tlsSpec,
+				HTTP: &ktunnelsv1.TunnelHTTP{
+					RequestHeaders: []ktunnelsv1.TunnelHTTPHeader{
+						{Name: "X-Developer", Value: "alice"},
+						{Name: "X-Api-Key", SecretKeyRef: apiKeyRef},
+					},
+				},
+			},
+		}
+	}
+	unmarshal := func(t *testing.T, tunnel *ktunnelsv1.Tunnel, opts resourceOptions) *http_connection_managerv3.HttpConnectionManager {
+		t.Helper()
+		listener, err := newListener(tunnel, ktunnelsv1.SinglePortName, 20001, opts)
+		if err != nil {
+			t.Fatalf("newListener: %s", err)
+		}
+		filters := listener.GetFilterChains()[0].GetFilters()
+		if len(filters) != 1 || filters[0].GetName() != "envoy.filters.network.http_connection_manager" {
+			t.Fatalf("filters wants the http connection manager only but got %v", filters)
+		}
+		var manager http_connection_managerv3.HttpConnectionManager
+		if err := filters[0].GetTypedConfig().UnmarshalTo(&manager); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		return &manager
+	}
+	routeOf := func(manager *http_connection_managerv3.HttpConnectionManager) *routev3.RouteAction {
+		return manager.GetRouteConfig().GetVirtualHosts()[0].GetRoutes()[0].GetRoute()
+	}
+
+	t.Run("host rewrite", func(t *testing.T) {
+		for _, tc := range []struct {
+			port    int32
+			tlsSpec *ktunnelsv1.TunnelTLS
+			want    string
+		}{
+			{80, nil, "payment-api.staging"},
+			{8080, nil, "payment-api.staging:8080"},
+			{443, &ktunnelsv1.TunnelTLS{}, "payment-api.staging"},
+		} {
+			got := routeOf(unmarshal(t, newTunnel(tc.port, tc.tlsSpec), resourceOptions{})).GetHostRewriteLiteral()
+			if got != tc.want {
+				t.Errorf("hostRewriteLiteral wants %s but got %s", tc.want, got)
+			}
+		}
+	})
+	t.Run("explicit host rewrite", func(t *testing.T) {
+		tunnel := newTunnel(8080, nil)
+		tunnel.Spec.HTTP.HostRewrite = "payment.example.com"
+		got := routeOf(unmarshal(t, tunnel, resourceOptions{})).GetHostRewriteLiteral()
+		if got != "payment.example.com" {
+			t.Errorf("hostRewriteLiteral wants payment.example.com but got %s", got)
+		}
+	})
+	t.Run("request headers", func(t *testing.T) {
+		manager := unmarshal(t, newTunnel(80, nil), resourceOptions{})
+		var gotHeaders []string
+		for _, header := range manager.GetRouteConfig().GetVirtualHosts()[0].GetRequestHeadersToAdd() {
+			gotHeaders = append(gotHeaders, header.GetHeader().GetKey()+"="+header.GetHeader().GetValue())
+		}
+		if diff := cmp.Diff([]string{"X-Developer=alice"}, gotHeaders); diff != "" {
+			t.Errorf("requestHeadersToAdd mismatch (-want +got):\n%s", diff)
+		}
+		var gotFilters []string
+		for _, httpFilter := range manager.GetHttpFilters() {
+			gotFilters = append(gotFilters, httpFilter.GetName())
+		}
+		wantFilters := []string{"envoy.filters.http.credential_injector/X-Api-Key", "envoy.filters.http.router"}
+		if diff := cmp.Diff(wantFilters, gotFilters); diff != "" {
+			t.Errorf("httpFilters mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("SDS secrets", func(t *testing.T) {
+		got := newSdsSecrets([]*ktunnelsv1.Tunnel{newTunnel(80, nil)}, map[string]*corev1.Secret{
+			"payment-api": {Data: map[string][]byte{"api-key": []byte("secret-value")}},
+		})
+		if len(got) != 1 {
+			t.Fatalf("len(secrets) wants 1 but got %d", len(got))
+		}
+		if got[0].GetName() != "header/payment-api/api-key" {
+			t.Errorf("name wants header/payment-api/api-key but got %s", got[0].GetName())
+		}
+		if filename := got[0].GetGenericSecret().GetSecret().GetFilename(); filename != "/etc/envoy-secrets/payment-api_api-key" {
+			t.Errorf("secret wants the file of the secret but got %s", filename)
+		}
+	})
+}
+
+func TestSecretKeysOf(t *testing.T) {
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			Spec: ktunnelsv1.TunnelSpec{
+				TLS: &ktunnelsv1.TunnelTLS{
+					CASecretRef: &corev1.LocalObjectReference{Name: "payment-api"},
+				},
+				HTTP: &ktunnelsv1.TunnelHTTP{
+					RequestHeaders: []ktunnelsv1.TunnelHTTPHeader{
+						{
+							Name: "X-Api-Key",
+							SecretKeyRef: &corev1.SecretKeySelector{
+								LocalObjectReference: corev1.LocalObjectReference{Name: "payment-api"},
+								Key:                  "api-key",
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+	want := map[string][]string{"payment-api": {"ca.crt", "api-key"}}
+	if diff := cmp.Diff(want, SecretKeysOf(tunnels)); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,199 @@
+package envoy
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+const dnsPort = 53
+
+// NewNetworkPolicy returns a NetworkPolicy of the proxy pods.
+// It allows ingress to the transit ports of the tunnels and the TLS passthrough port from any source,
+// and to the admin port only from adminNamespaces, such as the controller and monitoring namespaces.
+// If adminNamespaces is empty, the admin port is reachable only via kubelet, e.g. the readiness probe or port-forward.
+// It allows egress to the destination ports of the tunnels, DNS, the DNS resolvers, the access log collectors and the xDS server.
+// If the host of a tunnel is an IP address, the egress is allowed only to the address.
+// If xdsAddress is empty, the egress to the xDS server is not allowed.
+func NewNetworkPolicy(key types.NamespacedName, proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel, xdsAddress string, adminNamespaces []string) (networkingv1.NetworkPolicy, error) {
+	var ingress []networkingv1.NetworkPolicyIngressRule
+	// a rule without ports allows all ports, so it is omitted if no port is open
+	if tunnelPorts := newIngressPorts(proxy, tunnels); len(tunnelPorts) > 0 {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{Ports: tunnelPorts})
+	}
+	if len(adminNamespaces) > 0 {
+		ingress = append(ingress, networkingv1.NetworkPolicyIngressRule{
+			From: []networkingv1.NetworkPolicyPeer{
+				{
+					NamespaceSelector: &metav1.LabelSelector{
+						MatchExpressions: []metav1.LabelSelectorRequirement{
+							{
+								Key:      corev1.LabelMetadataName,
+								Operator: metav1.LabelSelectorOpIn,
+								Values:   slices.Sorted(slices.Values(adminNamespaces)),
+							},
+						},
+					},
+				},
+			},
+			Ports: []networkingv1.NetworkPolicyPort{newNetworkPolicyPort(corev1.ProtocolTCP, AdminPort)},
+		})
+	}
+	egress := newDestinationEgressRules(tunnels)
+	egress = append(egress, networkingv1.NetworkPolicyEgressRule{Ports: newDNSPorts(proxy.Spec.DNSResolvers)})
+	if collectorPorts := newAccessLogCollectorPorts(proxy, tunnels); len(collectorPorts) > 0 {
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{Ports: collectorPorts})
+	}
+	if xdsAddress != "" {
+		_, xdsPortString, err := net.SplitHostPort(xdsAddress)
+		if err != nil {
+			return networkingv1.NetworkPolicy{}, fmt.Errorf("invalid xDS address %q: %w", xdsAddress, err)
+		}
+		xdsPort, err := strconv.ParseUint(xdsPortString, 10, 16)
+		if err != nil {
+			return networkingv1.NetworkPolicy{}, fmt.Errorf("invalid xDS port %q: %w", xdsPortString, err)
+		}
+		egress = append(egress, networkingv1.NetworkPolicyEgressRule{
+			Ports: []networkingv1.NetworkPolicyPort{newNetworkPolicyPort(corev1.ProtocolTCP, int32(xdsPort))},
+		})
+	}
+	return networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: map[string]string{
+					PodLabelKeyOfProxy: proxy.Name,
+				},
+			},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress:     ingress,
+			Egress:      egress,
+		},
+	}, nil
+}
+
+// newIngressPorts returns the TLS passthrough port and the allocated transit ports in order.
+func newIngressPorts(proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) []networkingv1.NetworkPolicyPort {
+	portSet := make(map[int32]bool)
+	if tlsPassthroughPort := TLSPassthroughPortOf(proxy); tlsPassthroughPort != nil {
+		portSet[*tlsPassthroughPort] = true
+	}
+	for _, tunnel := range tunnels {
+		for _, port := range tunnel.GetPorts() {
+			if transitPort := tunnel.GetTransitPort(port.Name); transitPort != nil {
+				portSet[*transitPort] = true
+			}
+		}
+	}
+	return newTCPPorts(portSet)
+}
+
+// newDestinationEgressRules returns the egress rules to the destinations of the tunnels.
+// The ports of hostnames are allowed to any address, because the controller does not resolve them.
+func newDestinationEgressRules(tunnels []*ktunnelsv1.Tunnel) []networkingv1.NetworkPolicyEgressRule {
+	anyPortSet := make(map[int32]bool)
+	addrPortSets := make(map[netip.Addr]map[int32]bool)
+	for _, tunnel := range tunnels {
+		for _, destination := range tunnel.GetDestinations() {
+			addr, err := netip.ParseAddr(destination.Host)
+			for _, port := range tunnel.GetPorts() {
+				if err != nil {
+					anyPortSet[port.Port] = true
+					continue
+				}
+				addr = addr.Unmap()
+				if addrPortSets[addr] == nil {
+					addrPortSets[addr] = make(map[int32]bool)
+				}
+				addrPortSets[addr][port.Port] = true
+			}
+		}
+	}
+
+	var rules []networkingv1.NetworkPolicyEgressRule
+	if len(anyPortSet) > 0 {
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{Ports: newTCPPorts(anyPortSet)})
+	}
+	for _, addr := range slices.SortedFunc(maps.Keys(addrPortSets), netip.Addr.Compare) {
+		rules = append(rules, networkingv1.NetworkPolicyEgressRule{
+			To: []networkingv1.NetworkPolicyPeer{
+				{IPBlock: &networkingv1.IPBlock{CIDR: netip.PrefixFrom(addr, addr.BitLen()).String()}},
+			},
+			Ports: newTCPPorts(addrPortSets[addr]),
+		})
+	}
+	return rules
+}
+
+// newDNSPorts returns the UDP and TCP ports of DNS and the DNS resolvers.
+// An invalid address is skipped.
+func newDNSPorts(resolvers []string) []networkingv1.NetworkPolicyPort {
+	portSet := map[int32]bool{dnsPort: true}
+	for _, resolver := range resolvers {
+		addrPort, err := ParseDNSResolver(resolver)
+		if err != nil {
+			continue
+		}
+		portSet[int32(addrPort.Port())] = true
+	}
+	var ports []networkingv1.NetworkPolicyPort
+	for _, port := range slices.Sorted(maps.Keys(portSet)) {
+		ports = append(ports,
+			newNetworkPolicyPort(corev1.ProtocolUDP, port),
+			newNetworkPolicyPort(corev1.ProtocolTCP, port),
+		)
+	}
+	return ports
+}
+
+// newAccessLogCollectorPorts returns the ports of the access log collectors referenced by the tunnels.
+// An invalid address is skipped.
+func newAccessLogCollectorPorts(proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) []networkingv1.NetworkPolicyPort {
+	opts := newResourceOptions(proxy)
+	portSet := make(map[int32]bool)
+	for _, tunnel := range tunnels {
+		accessLog := accessLogOf(tunnel, opts)
+		if accessLog == nil || accessLog.Collector == nil {
+			continue
+		}
+		_, portString, err := net.SplitHostPort(accessLog.Collector.Address)
+		if err != nil {
+			continue
+		}
+		port, err := strconv.ParseUint(portString, 10, 16)
+		if err != nil {
+			continue
+		}
+		portSet[int32(port)] = true
+	}
+	return newTCPPorts(portSet)
+}
+
+func newTCPPorts(portSet map[int32]bool) []networkingv1.NetworkPolicyPort {
+	var ports []networkingv1.NetworkPolicyPort
+	for _, port := range slices.Sorted(maps.Keys(portSet)) {
+		ports = append(ports, newNetworkPolicyPort(corev1.ProtocolTCP, port))
+	}
+	return ports
+}
+
+func newNetworkPolicyPort(protocol corev1.Protocol, port int32) networkingv1.NetworkPolicyPort {
+	return networkingv1.NetworkPolicyPort{
+		Protocol: ptr.To(protocol),
+		Port:     ptr.To(intstr.FromInt32(port)),
+	}
+}
@@ -0,0 +1,145 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/utils/ptr"
+)
+
+func TestNewNetworkPolicy(t *testing.T) {
+	proxy := ktunnelsv1.Proxy{ObjectMeta: metav1.ObjectMeta{Name: "default", Namespace: "default"}}
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "microservice-database.staging", Port: 5432},
+			Status:     ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20002)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "message-broker"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "10.1.2.3",
+				Ports: []ktunnelsv1.TunnelPort{
+					{Name: "amqp", Port: 5672},
+					{Name: "management", Port: 15672},
+				},
+			},
+			Status: ktunnelsv1.TunnelStatus{
+				TransitPorts: []ktunnelsv1.TunnelTransitPort{
+					{Name: "amqp", TransitPort: 20003},
+					{Name: "management", TransitPort: 20001},
+				},
+			},
+		},
+		{
+			// not allocated yet
+			ObjectMeta: metav1.ObjectMeta{Name: "another-database"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "another-database.staging", Port: 5432},
+		},
+	}
+	tcpPort := func(port int32) networkingv1.NetworkPolicyPort {
+		return newNetworkPolicyPort(corev1.ProtocolTCP, port)
+	}
+
+	t.Run("xDS server is enabled", func(t *testing.T) {
+		got, err := NewNetworkPolicy(types.NamespacedName{Namespace: "default", Name: "ktunnels-proxy-default"},
+			proxy, tunnels, "ktunnels-xds-service.ktunnels-system.svc:18000", []string{"monitoring", "ktunnels-system"})
+		if err != nil {
+			t.Fatalf("NewNetworkPolicy: %s", err)
+		}
+		want := networkingv1.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: map[string]string{PodLabelKeyOfProxy: "default"}},
+			PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress},
+			Ingress: []networkingv1.NetworkPolicyIngressRule{
+				{Ports: []networkingv1.NetworkPolicyPort{tcpPort(20001), tcpPort(20002), tcpPort(20003)}},
+				{
+					From: []networkingv1.NetworkPolicyPeer{
+						{
+							NamespaceSelector: &metav1.LabelSelector{
+								MatchExpressions: []metav1.LabelSelectorRequirement{
+									{
+										Key:      "kubernetes.io/metadata.name",
+										Operator: metav1.LabelSelectorOpIn,
+										Values:   []string{"ktunnels-system", "monitoring"},
+									},
+								},
+							},
+						},
+					},
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(AdminPort)},
+				},
+			},
+			Egress: []networkingv1.NetworkPolicyEgressRule{
+				{Ports: []networkingv1.NetworkPolicyPort{tcpPort(5432)}},
+				{
+					To:    []networkingv1.NetworkPolicyPeer{{IPBlock: &networkingv1.IPBlock{CIDR: "10.1.2.3/32"}}},
+					Ports: []networkingv1.NetworkPolicyPort{tcpPort(5672), tcpPort(15672)},
+				},
+				{Ports: []networkingv1.NetworkPolicyPort{newNetworkPolicyPort(corev1.ProtocolUDP, 53), tcpPort(53)}},
+				{Ports: []networkingv1.NetworkPolicyPort{tcpPort(18000)}},
+			},
+		}
+		if diff := cmp.Diff(want, got.Spec); diff != "" {
+			t.Errorf("spec mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("access log collector", func(t *testing.T) {
+		proxy := proxy
+		proxy.Spec.AccessLog = &ktunnelsv1.AccessLog{
+			Collector: &ktunnelsv1.AccessLogCollector{Address: "otel-collector.monitoring.svc:4317"},
+		}
+		got, err := NewNetworkPolicy(types.NamespacedName{Namespace: "default", Name: "ktunnels-proxy-default"},
+			proxy, tunnels, "", nil)
+		if err != nil {
+			t.Fatalf("NewNetworkPolicy: %s", err)
+		}
+		want := networkingv1.NetworkPolicyEgressRule{Ports: []networkingv1.NetworkPolicyPort{tcpPort(4317)}}
+		if diff := cmp.Diff(want, got.Spec.Egress[len(got.Spec.Egress)-1]); diff != "" {
+			t.Errorf("egress mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("DNS resolvers", func(t *testing.T) {
+		proxy := proxy
+		proxy.Spec.DNSResolvers = []string{"10.0.0.2", "10.0.0.3:5353"}
+		got, err := NewNetworkPolicy(types.NamespacedName{Namespace: "default", Name: "ktunnels-proxy-default"},
+			proxy, nil, "", nil)
+		if err != nil {
+			t.Fatalf("NewNetworkPolicy: %s", err)
+		}
+		want := []networkingv1.NetworkPolicyEgressRule{
+			{
+				Ports: []networkingv1.NetworkPolicyPort{
+					newNetworkPolicyPort(corev1.ProtocolUDP, 53), tcpPort(53),
+					newNetworkPolicyPort(corev1.ProtocolUDP, 5353), tcpPort(5353),
+				},
+			},
+		}
+		if diff := cmp.Diff(want, got.Spec.Egress); diff != "" {
+			t.Errorf("egress mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("xDS server is disabled", func(t *testing.T) {
+		got, err := NewNetworkPolicy(types.NamespacedName{Namespace: "default", Name: "ktunnels-proxy-default"},
+			proxy, nil, "", nil)
+		if err != nil {
+			t.Fatalf("NewNetworkPolicy: %s", err)
+		}
+		if len(got.Spec.Ingress) != 0 {
+			t.Errorf("ingress wants empty to deny all but got %v", got.Spec.Ingress)
+		}
+		wantEgress := []networkingv1.NetworkPolicyEgressRule{
+			{Ports: []networkingv1.NetworkPolicyPort{newNetworkPolicyPort(corev1.ProtocolUDP, 53), tcpPort(53)}},
+		}
+		if diff := cmp.Diff(wantEgress, got.Spec.Egress); diff != "" {
+			t.Errorf("egress mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package envoy
+
+import (
+	"errors"
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	tls_inspectorv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/listener/tls_inspector/v3"
+	tcp_proxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+)
+
+// DefaultTLSPassthroughPort is the port of the TLS passthrough listener if the proxy does not specify it.
+const DefaultTLSPassthroughPort = 8443
+
+const tlsPassthroughListenerName = "tls_passthrough"
+
+// TLSPassthroughPortOf returns the port of the TLS passthrough listener of the proxy.
+// It returns nil if the TLS passthrough is not enabled.
+func TLSPassthroughPortOf(proxy ktunnelsv1.Proxy) *int32 {
+	if !proxy.Spec.TLSPassthrough.Enabled {
+		return nil
+	}
+	if proxy.Spec.TLSPassthrough.Port != nil {
+		return proxy.Spec.TLSPassthrough.Port
+	}
+	port := int32(DefaultTLSPassthroughPort)
+	return &port
+}
+
+// serverNamesOf returns the server names (SNI) routed to the TLS passthrough tunnel.
+// An IP address is skipped, because a client does not send it as the server name.
+func serverNamesOf(tunnel *ktunnelsv1.Tunnel) []string {
+	var serverNames []string
+	for _, destination := range tunnel.GetDestinations() {
+		if _, err := netip.ParseAddr(destination.Host); err == nil {
+			continue
+		}
+		serverName := strings.ToLower(destination.Host)
+		if !slices.Contains(serverNames, serverName) {
+			serverNames = append(serverNames, serverName)
+		}
+	}
+	return serverNames
+}
+
+// TLSPassthroughConflictsOf returns the errors of the TLS passthrough tunnels which conflict with another tunnel.
+// If a server name is routed to more than one tunnel, the former in order of name is routed,
+// and the latter is not routed at all.
+func TLSPassthroughConflictsOf(tunnels []*ktunnelsv1.Tunnel) map[*ktunnelsv1.Tunnel]error {
+	_, conflicts := routeTLSPassthroughTunnels(tunnels)
+	return conflicts
+}
+
+// routeTLSPassthroughTunnels returns the TLS passthrough tunnels routed by the listener in order of name,
+// and the errors of the tunnels which are not routed due to conflict of the server name.
+func routeTLSPassthroughTunnels(tunnels []*ktunnelsv1.Tunnel) ([]*ktunnelsv1.Tunnel, map[*ktunnelsv1.Tunnel]error) {
+	var passthroughTunnels []*ktunnelsv1.Tunnel
+	for _, tunnel := range tunnels {
+		if tunnel.Spec.TLSPassthrough {
+			passthroughTunnels = append(passthroughTunnels, tunnel)
+		}
+	}
+	slices.SortFunc(passthroughTunnels, func(a, b *ktunnelsv1.Tunnel) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	var routedTunnels []*ktunnelsv1.Tunnel
+	conflicts := make(map[*ktunnelsv1.Tunnel]error)
+	routedServerNames := make(map[string]string)
+	for _, tunnel := range passthroughTunnels {
+		serverNames := serverNamesOf(tunnel)
+		var conflictMessages []string
+		for _, serverName := range serverNames {
+			if routedTunnelName, exists := routedServerNames[serverName]; exists {
+				conflictMessages = append(conflictMessages,
+					fmt.Sprintf("server name %s is already routed to tunnel %s", serverName, routedTunnelName))
+			}
+		}
+		if len(conflictMessages) > 0 {
+			conflicts[tunnel] = errors.New(strings.Join(conflictMessages, ", "))
+			continue
+		}
+		for _, serverName := range serverNames {
+			routedServerNames[serverName] = tunnel.Name
+		}
+		routedTunnels = append(routedTunnels, tunnel)
+	}
+	return routedTunnels, conflicts
+}
+
+// newTLSPassthroughListener returns the listener shared by the TLS passthrough tunnels.
+// The TLS inspector reads the server name from the ClientHello,
+// and the filter chain of the tunnel forwards the connection to the destination without terminating TLS.
+// A tunnel which conflicts with another tunnel by the server name is skipped. See TLSPassthroughConflictsOf.
+// It returns nil if the TLS passthrough is not enabled or no tunnel uses it.
+func newTLSPassthroughListener(tunnels []*ktunnelsv1.Tunnel, opts resourceOptions) (*listenerv3.Listener, error) {
+	if opts.tlsPassthroughPort == nil {
+		return nil, nil
+	}
+	routedTunnels, _ := routeTLSPassthroughTunnels(tunnels)
+	var filterChains []*listenerv3.FilterChain
+	for _, tunnel := range routedTunnels {
+		serverNames := serverNamesOf(tunnel)
+		if len(serverNames) == 0 {
+			continue
+		}
+		filters, err := newTLSPassthroughFilters(tunnel, opts)
+		if err != nil {
+			return nil, fmt.Errorf("tunnel %s: %w", tunnel.Name, err)
+		}
+		filterChains = append(filterChains, &listenerv3.FilterChain{
+			Name:             tunnel.Name,
+			FilterChainMatch: &listenerv3.FilterChainMatch{ServerNames: serverNames},
+			Filters:          filters,
+		})
+	}
+	if len(filterChains) == 0 {
+		return nil, nil
+	}
+
+	tlsInspector, err := anypb.New(&tls_inspectorv3.TlsInspector{})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(tls_inspectorv3.TlsInspector): %w", err)
+	}
+	return &listenerv3.Listener{
+		Name:       tlsPassthroughListenerName,
+		StatPrefix: tlsPassthroughListenerName,
+		Address:    newListenerAddress(uint32(*opts.tlsPassthroughPort), opts.dualStack),
+		ListenerFilters: []*listenerv3.ListenerFilter{
+			{
+				Name:       "envoy.filters.listener.tls_inspector",
+				ConfigType: &listenerv3.ListenerFilter_TypedConfig{TypedConfig: tlsInspector},
+			},
+		},
+		FilterChains: filterChains,
+	}, nil
+}
+
+// newTLSPassthroughFilters returns the filters of the filter chain of the TLS passthrough tunnel.
+// The access logs are written by the TCP proxy, because the listener is shared by the tunnels.
+// Unlike a transit port, a connection denied by the RBAC filter is not logged.
+func newTLSPassthroughFilters(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) ([]*listenerv3.Filter, error) {
+	var filters []*listenerv3.Filter
+	sourceRBACFilter, err := newSourceRBACFilter(tunnel, ktunnelsv1.SinglePortName, opts)
+	if err != nil {
+		return nil, err
+	}
+	if sourceRBACFilter != nil {
+		filters = append(filters, sourceRBACFilter)
+	}
+	accessLogs, err := newAccessLogs(tunnel, ktunnelsv1.SinglePortName, opts)
+	if err != nil {
+		return nil, err
+	}
+	timeouts := timeoutsOf(tunnel, opts)
+	tcpProxyFilter, err := newFilter("envoy.filters.network.tcp_proxy", &tcp_proxyv3.TcpProxy{
+		StatPrefix:                      StatPrefixOf(tunnel, ktunnelsv1.SinglePortName),
+		ClusterSpecifier:                &tcp_proxyv3.TcpProxy_Cluster{Cluster: resourceNameOf(tunnel, ktunnelsv1.SinglePortName)},
+		IdleTimeout:                     newDurationOrNil(timeouts.Idle),
+		MaxDownstreamConnectionDuration: newDurationOrNil(timeouts.MaxConnectionDuration),
+		AccessLog:                       accessLogs,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return append(filters, tcpProxyFilter), nil
+}
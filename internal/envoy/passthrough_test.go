@@ -0,0 +1,130 @@
+package envoy
+
+import (
+	"testing"
+
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func Test_tlsPassthrough(t *testing.T) {
+	newTunnel := func(name string, hosts ...string) *ktunnelsv1.Tunnel {
+		tunnel := &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default"},
+			Spec:       ktunnelsv1.TunnelSpec{Port: 443, TLSPassthrough: true},
+		}
+		for _, host := range hosts {
+			tunnel.Spec.Destinations = append(tunnel.Spec.Destinations, ktunnelsv1.TunnelDestination{Host: host})
+		}
+		return tunnel
+	}
+	opts := resourceOptions{tlsPassthroughPort: ptr.To[int32](8443)}
+	findListener := func(t *testing.T, listeners []*listenerv3.Listener) *listenerv3.Listener {
+		t.Helper()
+		for _, listener := range listeners {
+			if listener.GetName() == tlsPassthroughListenerName {
+				return listener
+			}
+		}
+		return nil
+	}
+
+	t.Run("filter chain per tunnel", func(t *testing.T) {
+		listeners, err := newListeners([]*ktunnelsv1.Tunnel{
+			newTunnel("payment-api", "payment-api.example.com"),
+			newTunnel("auth", "auth.example.com", "AUTH-replica.example.com", "10.1.2.3"),
+		}, opts)
+		if err != nil {
+			t.Fatalf("newListeners: %s", err)
+		}
+		listener := findListener(t, listeners)
+		if listener == nil {
+			t.Fatalf("listener %s is not found", tlsPassthroughListenerName)
+		}
+		if got := listener.GetAddress().GetSocketAddress().GetPortValue(); got != 8443 {
+			t.Errorf("port wants 8443 but got %d", got)
+		}
+		if got := listener.GetListenerFilters()[0].GetName(); got != "envoy.filters.listener.tls_inspector" {
+			t.Errorf("listener filter wants the TLS inspector but got %s", got)
+		}
+		type filterChain struct {
+			Name        string
+			ServerNames []string
+			Filters     []string
+		}
+		var got []filterChain
+		for _, chain := range listener.GetFilterChains() {
+			var filters []string
+			for _, filter := range chain.GetFilters() {
+				filters = append(filters, filter.GetName())
+			}
+			got = append(got, filterChain{chain.GetName(), chain.GetFilterChainMatch().GetServerNames(), filters})
+		}
+		want := []filterChain{
+			{"auth", []string{"auth.example.com", "auth-replica.example.com"}, []string{"envoy.filters.network.tcp_proxy"}},
+			{"payment-api", []string{"payment-api.example.com"}, []string{"envoy.filters.network.tcp_proxy"}},
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("filter chains mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("skip a tunnel in conflict of the server name", func(t *testing.T) {
+		listener, err := newTLSPassthroughListener([]*ktunnelsv1.Tunnel{
+			newTunnel("payment-api-v2", "payment-api.example.com", "payment-api-v2.example.com"),
+			newTunnel("payment-api", "payment-api.example.com"),
+		}, opts)
+		if err != nil {
+			t.Fatalf("newTLSPassthroughListener: %s", err)
+		}
+		chains := listener.GetFilterChains()
+		if len(chains) != 1 || chains[0].GetName() != "payment-api" {
+			t.Errorf("filter chains wants payment-api only but got %v", chains)
+		}
+	})
+	t.Run("conflicts of the server name", func(t *testing.T) {
+		paymentAPI := newTunnel("payment-api", "payment-api.example.com")
+		paymentAPIv2 := newTunnel("payment-api-v2", "Payment-API.example.com", "payment-api-v2.example.com")
+		auth := newTunnel("auth", "auth.example.com")
+		conflicts := TLSPassthroughConflictsOf([]*ktunnelsv1.Tunnel{paymentAPIv2, auth, paymentAPI})
+		got := make(map[string]string)
+		for tunnel, err := range conflicts {
+			got[tunnel.Name] = err.Error()
+		}
+		want := map[string]string{
+			"payment-api-v2": "server name payment-api.example.com is already routed to tunnel payment-api",
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("conflicts mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("not enabled", func(t *testing.T) {
+		listeners, err := newListeners([]*ktunnelsv1.Tunnel{newTunnel("payment-api", "payment-api.example.com")}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newListeners: %s", err)
+		}
+		if listener := findListener(t, listeners); listener != nil {
+			t.Errorf("listener wants nil but got %v", listener)
+		}
+	})
+	t.Run("service ports", func(t *testing.T) {
+		proxy := ktunnelsv1.Proxy{Spec: ktunnelsv1.ProxySpec{TLSPassthrough: ktunnelsv1.ProxyTLSPassthrough{Enabled: true}}}
+		ports, conflicts := newProxyServicePorts(proxy, []*ktunnelsv1.Tunnel{
+			newTunnel("payment-api", "payment-api.example.com"),
+			newTunnel("auth", "auth.example.com"),
+		})
+		wantPorts := []corev1.ServicePort{
+			{Name: "tls-passthrough-443", Port: 443, TargetPort: intstr.FromInt32(DefaultTLSPassthroughPort)},
+		}
+		if diff := cmp.Diff(wantPorts, ports); diff != "" {
+			t.Errorf("ports mismatch (-want +got):\n%s", diff)
+		}
+		if conflicts != nil {
+			t.Errorf("conflicts wants nil but got %v", conflicts)
+		}
+	})
+}
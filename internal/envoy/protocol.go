@@ -0,0 +1,79 @@
+package envoy
+
+import (
+	"fmt"
+	"time"
+
+	xdstypev3 "github.com/cncf/xds/go/xds/type/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	mongo_proxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/mongo_proxy/v3"
+	redis_proxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/redis_proxy/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// redisOperationTimeout is the timeout of a Redis command, which is required by the Redis proxy.
+const redisOperationTimeout = 5 * time.Second
+
+// newProtocolFilter returns the network filter to parse the protocol of the tunnel.
+// It is inserted ahead of the TCP proxy.
+// It returns nil if the protocol is TCP or Redis, because the Redis proxy replaces the TCP proxy.
+//
+// The PostgreSQL and MySQL filters are contrib extensions of Envoy,
+// so they are configured by TypedStruct without the Go types.
+func newProtocolFilter(tunnel *ktunnelsv1.Tunnel, portName string) (*listenerv3.Filter, error) {
+	statPrefix := StatPrefixOf(tunnel, portName)
+	switch tunnel.Spec.Protocol {
+	case ktunnelsv1.TunnelProtocolPostgreSQL:
+		return newTypedStructFilter("envoy.filters.network.postgres_proxy",
+			"type.googleapis.com/envoy.extensions.filters.network.postgres_proxy.v3alpha.PostgresProxy",
+			map[string]any{"stat_prefix": statPrefix})
+	case ktunnelsv1.TunnelProtocolMySQL:
+		return newTypedStructFilter("envoy.filters.network.mysql_proxy",
+			"type.googleapis.com/envoy.extensions.filters.network.mysql_proxy.v3.MySQLProxy",
+			map[string]any{"stat_prefix": statPrefix})
+	case ktunnelsv1.TunnelProtocolMongoDB:
+		return newFilter("envoy.filters.network.mongo_proxy", &mongo_proxyv3.MongoProxy{
+			StatPrefix: statPrefix,
+		})
+	}
+	return nil, nil
+}
+
+// newRedisProxyFilter returns the Redis proxy which sends all commands to the cluster.
+func newRedisProxyFilter(tunnel *ktunnelsv1.Tunnel, portName string) (*listenerv3.Filter, error) {
+	return newFilter("envoy.filters.network.redis_proxy", &redis_proxyv3.RedisProxy{
+		StatPrefix: StatPrefixOf(tunnel, portName),
+		Settings: &redis_proxyv3.RedisProxy_ConnPoolSettings{
+			OpTimeout:          durationpb.New(redisOperationTimeout),
+			EnableCommandStats: true,
+		},
+		PrefixRoutes: &redis_proxyv3.RedisProxy_PrefixRoutes{
+			CatchAllRoute: &redis_proxyv3.RedisProxy_PrefixRoutes_Route{
+				Cluster: resourceNameOf(tunnel, portName),
+			},
+		},
+	})
+}
+
+func newFilter(name string, config proto.Message) (*listenerv3.Filter, error) {
+	typedConfig, err := anypb.New(config)
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(%s): %w", name, err)
+	}
+	return &listenerv3.Filter{
+		Name:       name,
+		ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: typedConfig},
+	}, nil
+}
+
+func newTypedStructFilter(name, typeURL string, value map[string]any) (*listenerv3.Filter, error) {
+	structValue, err := structpb.NewStruct(value)
+	if err != nil {
+		return nil, fmt.Errorf("structpb.NewStruct(%s): %w", name, err)
+	}
+	return newFilter(name, &xdstypev3.TypedStruct{TypeUrl: typeURL, Value: structValue})
+}
@@ -0,0 +1,51 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_protocol(t *testing.T) {
+	filterNamesOf := func(t *testing.T, protocol ktunnelsv1.TunnelProtocol) []string {
+		tunnel := &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:     "microservice-database.staging",
+				Port:     5432,
+				Protocol: protocol,
+			},
+			Status: ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20001)},
+		}
+		listener, err := newListener(tunnel, ktunnelsv1.SinglePortName, 20001, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newListener: %s", err)
+		}
+		var names []string
+		for _, filter := range listener.GetFilterChains()[0].GetFilters() {
+			names = append(names, filter.GetName())
+		}
+		return names
+	}
+
+	for _, tc := range []struct {
+		protocol ktunnelsv1.TunnelProtocol
+		want     []string
+	}{
+		{"", []string{"envoy.filters.network.tcp_proxy"}},
+		{ktunnelsv1.TunnelProtocolTCP, []string{"envoy.filters.network.tcp_proxy"}},
+		{ktunnelsv1.TunnelProtocolPostgreSQL, []string{"envoy.filters.network.postgres_proxy", "envoy.filters.network.tcp_proxy"}},
+		{ktunnelsv1.TunnelProtocolMySQL, []string{"envoy.filters.network.mysql_proxy", "envoy.filters.network.tcp_proxy"}},
+		{ktunnelsv1.TunnelProtocolMongoDB, []string{"envoy.filters.network.mongo_proxy", "envoy.filters.network.tcp_proxy"}},
+		{ktunnelsv1.TunnelProtocolRedis, []string{"envoy.filters.network.redis_proxy"}},
+	} {
+		t.Run(string(tc.protocol), func(t *testing.T) {
+			if diff := cmp.Diff(tc.want, filterNamesOf(t, tc.protocol)); diff != "" {
+				t.Errorf("filters mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package envoy
+
+import (
+	"fmt"
+	"net/netip"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	listenerv3 "github.com/envoyproxy/go-control-plane/envoy/config/listener/v3"
+	rbacconfigv3 "github.com/envoyproxy/go-control-plane/envoy/config/rbac/v3"
+	rbacv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/rbac/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// allowedSourceRangesOf returns the CIDRs of the clients allowed to connect to the tunnel.
+// It returns nil if any client is allowed.
+func allowedSourceRangesOf(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) []string {
+	if len(tunnel.Spec.AllowedSourceRanges) > 0 {
+		return tunnel.Spec.AllowedSourceRanges
+	}
+	return opts.allowedSourceRanges
+}
+
+// newSourceRBACFilter returns the network RBAC filter to allow the connections from the source ranges.
+// It returns nil if any client is allowed.
+// It returns an error if a CIDR is invalid, instead of silently allowing fewer sources.
+func newSourceRBACFilter(tunnel *ktunnelsv1.Tunnel, portName string, opts resourceOptions) (*listenerv3.Filter, error) {
+	sourceRanges := allowedSourceRangesOf(tunnel, opts)
+	if len(sourceRanges) == 0 {
+		return nil, nil
+	}
+	var principals []*rbacconfigv3.Principal
+	for _, sourceRange := range sourceRanges {
+		prefix, err := netip.ParsePrefix(sourceRange)
+		if err != nil {
+			return nil, fmt.Errorf("invalid allowedSourceRanges: %w", err)
+		}
+		principals = append(principals, &rbacconfigv3.Principal{
+			Identifier: &rbacconfigv3.Principal_DirectRemoteIp{
+				DirectRemoteIp: &corev3.CidrRange{
+					AddressPrefix: prefix.Masked().Addr().String(),
+					PrefixLen:     wrapperspb.UInt32(uint32(prefix.Bits())),
+				},
+			},
+		})
+	}
+	rules := &rbacconfigv3.RBAC{
+		Action: rbacconfigv3.RBAC_ALLOW,
+		Policies: map[string]*rbacconfigv3.Policy{
+			"allowed-source-ranges": {
+				Permissions: []*rbacconfigv3.Permission{
+					{Rule: &rbacconfigv3.Permission_Any{Any: true}},
+				},
+				Principals: principals,
+			},
+		},
+	}
+	rbacConfig, err := anypb.New(&rbacv3.RBAC{
+		// the stats are emitted as PREFIX.rbac.denied
+		StatPrefix: StatPrefixOf(tunnel, portName),
+		Rules:      rules,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(rbacv3.RBAC): %w", err)
+	}
+	return &listenerv3.Filter{
+		Name:       "envoy.filters.network.rbac",
+		ConfigType: &listenerv3.Filter_TypedConfig{TypedConfig: rbacConfig},
+	}, nil
+}
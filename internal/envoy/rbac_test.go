@@ -0,0 +1,83 @@
+package envoy
+
+import (
+	"fmt"
+	"testing"
+
+	rbacv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/rbac/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_newSourceRBACFilter(t *testing.T) {
+	newTunnel := func(allowedSourceRanges []string) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:                "microservice-database.staging",
+				Port:                5432,
+				AllowedSourceRanges: allowedSourceRanges,
+			},
+		}
+	}
+	unmarshal := func(t *testing.T, tunnel *ktunnelsv1.Tunnel, opts resourceOptions) *rbacv3.RBAC {
+		t.Helper()
+		filter, err := newSourceRBACFilter(tunnel, ktunnelsv1.SinglePortName, opts)
+		if err != nil {
+			t.Fatalf("newSourceRBACFilter: %s", err)
+		}
+		if filter == nil {
+			t.Fatalf("filter wants non-nil but got nil")
+		}
+		var rbac rbacv3.RBAC
+		if err := filter.GetTypedConfig().UnmarshalTo(&rbac); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		return &rbac
+	}
+	sourceRangesOf := func(rbac *rbacv3.RBAC) []string {
+		var sourceRanges []string
+		for _, policy := range rbac.GetRules().GetPolicies() {
+			for _, principal := range policy.GetPrincipals() {
+				cidr := principal.GetDirectRemoteIp()
+				sourceRanges = append(sourceRanges, fmt.Sprintf("%s/%d", cidr.GetAddressPrefix(), cidr.GetPrefixLen().GetValue()))
+			}
+		}
+		return sourceRanges
+	}
+
+	t.Run("any client is allowed", func(t *testing.T) {
+		filter, err := newSourceRBACFilter(newTunnel(nil), ktunnelsv1.SinglePortName, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newSourceRBACFilter: %s", err)
+		}
+		if filter != nil {
+			t.Errorf("filter wants nil but got %v", filter)
+		}
+	})
+	t.Run("tunnel", func(t *testing.T) {
+		got := unmarshal(t, newTunnel([]string{"127.0.0.1/32", "10.1.0.0/16"}),
+			resourceOptions{allowedSourceRanges: []string{"192.168.0.0/16"}})
+		if got.GetStatPrefix() != "default_microservice-database" {
+			t.Errorf("statPrefix wants default_microservice-database but got %s", got.GetStatPrefix())
+		}
+		want := []string{"127.0.0.1/32", "10.1.0.0/16"}
+		if diff := cmp.Diff(want, sourceRangesOf(got)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("default of proxy", func(t *testing.T) {
+		got := unmarshal(t, newTunnel(nil), resourceOptions{allowedSourceRanges: []string{"192.168.1.2/16"}})
+		want := []string{"192.168.0.0/16"}
+		if diff := cmp.Diff(want, sourceRangesOf(got)); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+	t.Run("invalid CIDR", func(t *testing.T) {
+		_, err := newSourceRBACFilter(newTunnel([]string{"127.0.0.1/32", "10.1.0.0"}), ktunnelsv1.SinglePortName, resourceOptions{})
+		if err == nil {
+			t.Errorf("newSourceRBACFilter wants an error but got nil")
+		}
+	})
+}
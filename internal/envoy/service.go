@@ -1,30 +1,118 @@
 package envoy
 
 import (
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
+
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
-func NewService(key types.NamespacedName, tunnel ktunnelsv1.Tunnel) corev1.Service {
+func NewService(key types.NamespacedName, proxy ktunnelsv1.Proxy, tunnel ktunnelsv1.Tunnel) corev1.Service {
 	return corev1.Service{
 		ObjectMeta: metav1.ObjectMeta{
 			Namespace: key.Namespace,
 			Name:      key.Name,
 		},
 		Spec: corev1.ServiceSpec{
-			Ports: []corev1.ServicePort{
-				{
-					Name:       "proxy",
-					Port:       tunnel.Spec.Port,
-					TargetPort: intstr.IntOrString{Type: intstr.Int, IntVal: *tunnel.Status.TransitPort},
-				},
-			},
+			Ports: newServicePorts(proxy, tunnel),
 			Selector: map[string]string{
 				PodLabelKeyOfProxy: tunnel.Spec.Proxy.Name,
 			},
+			IPFamilyPolicy: newIPFamilyPolicy(proxy),
 		},
 	}
 }
+
+// newServicePorts returns the ports of the tunnel mapped to the transit ports.
+// A TLS passthrough tunnel is mapped to the TLS passthrough port of the proxy instead.
+func newServicePorts(proxy ktunnelsv1.Proxy, tunnel ktunnelsv1.Tunnel) []corev1.ServicePort {
+	var ports []corev1.ServicePort
+	for _, port := range tunnel.GetPorts() {
+		transitPort := tunnel.GetTransitPort(port.Name)
+		if tunnel.Spec.TLSPassthrough {
+			transitPort = TLSPassthroughPortOf(proxy)
+		}
+		if transitPort == nil {
+			continue
+		}
+		ports = append(ports, corev1.ServicePort{
+			Name:       port.Name,
+			Port:       port.Port,
+			TargetPort: intstr.IntOrString{Type: intstr.Int, IntVal: *transitPort},
+		})
+	}
+	return ports
+}
+
+// NewProxyService returns a Service which has the ports of all tunnels of the proxy,
+// so that a single port-forward opens all tunnels.
+// A port is named after the tunnel, or the tunnel and port name for multiple ports.
+// The TLS passthrough tunnels share a port named after the port number, because the proxy routes them by the server name.
+// If the port or name is already used by another tunnel, it is skipped and returned as conflicts.
+func NewProxyService(key types.NamespacedName, proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) (corev1.Service, []string) {
+	ports, conflicts := newProxyServicePorts(proxy, tunnels)
+	return corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+		Spec: corev1.ServiceSpec{
+			Ports: ports,
+			Selector: map[string]string{
+				PodLabelKeyOfProxy: proxy.Name,
+			},
+			IPFamilyPolicy: newIPFamilyPolicy(proxy),
+		},
+	}, conflicts
+}
+
+func newProxyServicePorts(proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) ([]corev1.ServicePort, []string) {
+	sortedTunnels := slices.SortedFunc(slices.Values(tunnels), func(a, b *ktunnelsv1.Tunnel) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+	var ports []corev1.ServicePort
+	var conflicts []string
+	usedNames := make(map[string]bool)
+	usedPorts := make(map[int32]bool)
+	for _, tunnel := range sortedTunnels {
+		for _, port := range newServicePorts(proxy, *tunnel) {
+			if tunnel.Spec.TLSPassthrough {
+				port.Name = fmt.Sprintf("tls-passthrough-%d", port.Port)
+				if usedNames[port.Name] {
+					continue
+				}
+			} else {
+				port.Name = proxyServicePortNameOf(tunnel, port.Name)
+			}
+			if usedNames[port.Name] || usedPorts[port.Port] {
+				conflicts = append(conflicts, port.Name)
+				continue
+			}
+			usedNames[port.Name] = true
+			usedPorts[port.Port] = true
+			ports = append(ports, port)
+		}
+	}
+	return ports, conflicts
+}
+
+// proxyServicePortNameOf returns the port name in the Service of the proxy.
+// It must be a DNS label, while the tunnel name is a DNS subdomain.
+func proxyServicePortNameOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	name := tunnel.Name
+	if tunnel.IsMultiPort() {
+		name = fmt.Sprintf("%s-%s", tunnel.Name, portName)
+	}
+	name = strings.ReplaceAll(name, ".", "-")
+	if len(name) > validation.DNS1123LabelMaxLength {
+		name = name[:validation.DNS1123LabelMaxLength]
+	}
+	return strings.TrimRight(name, "-")
+}
@@ -0,0 +1,67 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/ptr"
+)
+
+func Test_newProxyServicePorts(t *testing.T) {
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis.cache"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "redis.staging", Port: 6379},
+			Status:     ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20001)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "microservice-database.staging", Port: 5432},
+			Status:     ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20002)},
+		},
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "message-broker"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "message-broker.staging",
+				Ports: []ktunnelsv1.TunnelPort{
+					{Name: "amqp", Port: 5672},
+					{Name: "management", Port: 15672},
+				},
+			},
+			Status: ktunnelsv1.TunnelStatus{
+				TransitPorts: []ktunnelsv1.TunnelTransitPort{
+					{Name: "amqp", TransitPort: 20003},
+					{Name: "management", TransitPort: 20004},
+				},
+			},
+		},
+		{
+			// same port as microservice-database
+			ObjectMeta: metav1.ObjectMeta{Name: "unused-database"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "unused-database.staging", Port: 5432},
+			Status:     ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20005)},
+		},
+		{
+			// not allocated yet
+			ObjectMeta: metav1.ObjectMeta{Name: "new-database"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "new-database.staging", Port: 3306},
+		},
+	}
+	ports, conflicts := newProxyServicePorts(ktunnelsv1.Proxy{}, tunnels)
+	wantPorts := []corev1.ServicePort{
+		{Name: "message-broker-amqp", Port: 5672, TargetPort: intstr.FromInt32(20003)},
+		{Name: "message-broker-management", Port: 15672, TargetPort: intstr.FromInt32(20004)},
+		{Name: "microservice-database", Port: 5432, TargetPort: intstr.FromInt32(20002)},
+		{Name: "redis-cache", Port: 6379, TargetPort: intstr.FromInt32(20001)},
+	}
+	if diff := cmp.Diff(wantPorts, ports); diff != "" {
+		t.Errorf("ports mismatch (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"unused-database"}, conflicts); diff != "" {
+		t.Errorf("conflicts mismatch (-want +got):\n%s", diff)
+	}
+}
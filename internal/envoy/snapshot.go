@@ -0,0 +1,68 @@
+package envoy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// NewSnapshot returns a snapshot of the clusters and listeners for the xDS server.
+// The snapshot contains no secret, because Envoy reads the secrets from the Secret of the proxy.
+// The version is derived from the content, so that Envoy receives an update only when the configuration is changed.
+func NewSnapshot(proxy ktunnelsv1.Proxy, tunnels []*ktunnelsv1.Tunnel) (*cachev3.Snapshot, error) {
+	opts := newResourceOptions(proxy)
+	clusterMessages, err := newClusters(tunnels, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate clusters: %w", err)
+	}
+	var clusters []types.Resource
+	for _, cluster := range clusterMessages {
+		clusters = append(clusters, cluster)
+	}
+	listenerMessages, err := newListeners(tunnels, opts)
+	if err != nil {
+		return nil, fmt.Errorf("unable to generate listeners: %w", err)
+	}
+	var listeners []types.Resource
+	for _, listener := range listenerMessages {
+		listeners = append(listeners, listener)
+	}
+
+	resources := map[resource.Type][]types.Resource{
+		resource.ClusterType:  clusters,
+		resource.ListenerType: listeners,
+	}
+	version, err := computeVersion(resources)
+	if err != nil {
+		return nil, fmt.Errorf("unable to compute the version: %w", err)
+	}
+	snapshot, err := cachev3.NewSnapshot(version, resources)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create a snapshot: %w", err)
+	}
+	if err := snapshot.Consistent(); err != nil {
+		return nil, fmt.Errorf("inconsistent snapshot: %w", err)
+	}
+	return snapshot, nil
+}
+
+func computeVersion(resources map[resource.Type][]types.Resource) (string, error) {
+	h := sha256.New()
+	for _, typeURL := range []resource.Type{resource.ClusterType, resource.ListenerType} {
+		for _, r := range resources[typeURL] {
+			b, err := proto.MarshalOptions{Deterministic: true}.Marshal(r)
+			if err != nil {
+				return "", fmt.Errorf("marshal: %w", err)
+			}
+			_, _ = fmt.Fprintf(h, "%s:%d:", typeURL, len(b))
+			h.Write(b)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16], nil
+}
@@ -0,0 +1,61 @@
+package envoy
+
+import (
+	"testing"
+
+	"github.com/envoyproxy/go-control-plane/pkg/resource/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestNewSnapshot(t *testing.T) {
+	newTunnel := func(transitPort int32) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      "microservice-database",
+				Namespace: "default",
+			},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  "microservice-database.staging",
+				Port:  5432,
+				Proxy: corev1.LocalObjectReference{Name: "example"},
+			},
+			Status: ktunnelsv1.TunnelStatus{
+				TransitPort: ptr.To(transitPort),
+			},
+		}
+	}
+
+	snapshot, err := NewSnapshot(ktunnelsv1.Proxy{}, []*ktunnelsv1.Tunnel{newTunnel(30000)})
+	if err != nil {
+		t.Fatalf("NewSnapshot: %s", err)
+	}
+	if got := len(snapshot.GetResources(resource.ClusterType)); got != 2 {
+		t.Errorf("len(clusters) wants 2 but got %d", got)
+	}
+	if got := len(snapshot.GetResources(resource.ListenerType)); got != 2 {
+		t.Errorf("len(listeners) wants 2 but got %d", got)
+	}
+
+	t.Run("same configuration", func(t *testing.T) {
+		same, err := NewSnapshot(ktunnelsv1.Proxy{}, []*ktunnelsv1.Tunnel{newTunnel(30000)})
+		if err != nil {
+			t.Fatalf("NewSnapshot: %s", err)
+		}
+		want, got := snapshot.GetVersion(resource.ListenerType), same.GetVersion(resource.ListenerType)
+		if want != got {
+			t.Errorf("version wants %s but got %s", want, got)
+		}
+	})
+	t.Run("different configuration", func(t *testing.T) {
+		changed, err := NewSnapshot(ktunnelsv1.Proxy{}, []*ktunnelsv1.Tunnel{newTunnel(30001)})
+		if err != nil {
+			t.Fatalf("NewSnapshot: %s", err)
+		}
+		if v := snapshot.GetVersion(resource.ListenerType); v == changed.GetVersion(resource.ListenerType) {
+			t.Errorf("version wants to be changed but got %s", v)
+		}
+	})
+}
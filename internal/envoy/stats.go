@@ -0,0 +1,150 @@
+package envoy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"k8s.io/utils/ptr"
+)
+
+// StatPrefixOf returns the prefix of the listener, cluster and TCP proxy stats of the port of the tunnel.
+// Envoy stat names are separated by dot, so it is replaced in the name.
+// A namespace does not contain underscore, so the first underscore separates the namespace and name.
+// The port name is appended after double underscore in the multi-port form.
+func StatPrefixOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	prefix := fmt.Sprintf("%s_%s", tunnel.Namespace, strings.ReplaceAll(tunnel.Name, ".", "_"))
+	if !tunnel.IsMultiPort() {
+		return prefix
+	}
+	return fmt.Sprintf("%s__%s", prefix, portName)
+}
+
+// TunnelStats represents the statistics of a tunnel in an Envoy instance.
+type TunnelStats struct {
+	// ActiveConnections is nil for a tunnel of TLS passthrough,
+	// because the listener is shared and the TCP proxy does not emit the active connections.
+	ActiveConnections *int64
+	TotalConnections  int64
+	ReceivedBytes     int64
+	SentBytes         int64
+	DeniedConnections int64
+}
+
+// Add returns the sum of the statistics.
+func (s TunnelStats) Add(o TunnelStats) TunnelStats {
+	return TunnelStats{
+		ActiveConnections: addOrNil(s.ActiveConnections, o.ActiveConnections),
+		TotalConnections:  s.TotalConnections + o.TotalConnections,
+		ReceivedBytes:     s.ReceivedBytes + o.ReceivedBytes,
+		SentBytes:         s.SentBytes + o.SentBytes,
+		DeniedConnections: s.DeniedConnections + o.DeniedConnections,
+	}
+}
+
+func addOrNil(a, b *int64) *int64 {
+	if a == nil && b == nil {
+		return nil
+	}
+	return ptr.To(ptr.Deref(a, 0) + ptr.Deref(b, 0))
+}
+
+type statsResponse struct {
+	Stats []struct {
+		Name  string `json:"name"`
+		Value *int64 `json:"value"`
+	} `json:"stats"`
+}
+
+// GetTunnelStats fetches the statistics from the admin endpoint of an Envoy instance.
+// It returns a map of the tunnel name to the statistics, summed over the ports.
+func GetTunnelStats(ctx context.Context, httpClient *http.Client, adminURL string, tunnels []*ktunnelsv1.Tunnel) (map[string]TunnelStats, error) {
+	q := url.Values{}
+	q.Set("format", "json")
+	q.Set("filter", `^(tcp|redis|http|listener)\.|\.rbac\.denied$`)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, adminURL+"/stats?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not create a request: %w", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("http error: %w", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status code wants 200 but was %d", resp.StatusCode)
+	}
+	var statsValue statsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&statsValue); err != nil {
+		return nil, fmt.Errorf("unable to decode stats json: %w", err)
+	}
+
+	tunnelNameByStatPrefix := make(map[string]string)
+	for _, tunnel := range tunnels {
+		for _, port := range tunnel.GetPorts() {
+			tunnelNameByStatPrefix[StatPrefixOf(tunnel, port.Name)] = tunnel.Name
+		}
+	}
+	statsByTunnelName := make(map[string]TunnelStats)
+	for _, stat := range statsValue.Stats {
+		if stat.Value == nil {
+			continue
+		}
+		// the network RBAC filter emits PREFIX.rbac.denied
+		if prefix, ok := strings.CutSuffix(stat.Name, ".rbac.denied"); ok {
+			if tunnelName, ok := tunnelNameByStatPrefix[prefix]; ok {
+				s := statsByTunnelName[tunnelName]
+				s.DeniedConnections += *stat.Value
+				statsByTunnelName[tunnelName] = s
+			}
+			continue
+		}
+		scope, name, ok := parseStatName(stat.Name)
+		if !ok {
+			continue
+		}
+		tunnelName, ok := tunnelNameByStatPrefix[name.prefix]
+		if !ok {
+			continue
+		}
+		// the Redis proxy and HTTP connection manager emit the same stats as the TCP proxy
+		if scope == "redis" || scope == "http" {
+			scope = "tcp"
+		}
+		s := statsByTunnelName[tunnelName]
+		switch {
+		case scope == "listener" && name.stat == "downstream_cx_active":
+			s.ActiveConnections = addOrNil(s.ActiveConnections, stat.Value)
+		case scope == "tcp" && name.stat == "downstream_cx_total":
+			s.TotalConnections += *stat.Value
+		case scope == "tcp" && name.stat == "downstream_cx_rx_bytes_total":
+			s.ReceivedBytes += *stat.Value
+		case scope == "tcp" && name.stat == "downstream_cx_tx_bytes_total":
+			s.SentBytes += *stat.Value
+		}
+		statsByTunnelName[tunnelName] = s
+	}
+	return statsByTunnelName, nil
+}
+
+type statName struct {
+	prefix string
+	stat   string
+}
+
+// parseStatName parses a name such as tcp.PREFIX.downstream_cx_total
+func parseStatName(s string) (string, statName, bool) {
+	scope, rest, ok := strings.Cut(s, ".")
+	if !ok {
+		return "", statName{}, false
+	}
+	i := strings.LastIndex(rest, ".")
+	if i < 0 {
+		return "", statName{}, false
+	}
+	return scope, statName{prefix: rest[:i], stat: rest[i+1:]}, true
+}
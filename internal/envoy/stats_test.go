@@ -0,0 +1,99 @@
+package envoy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func TestStatPrefixOf(t *testing.T) {
+	tunnel := &ktunnelsv1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "microservice.database"},
+	}
+	if got := StatPrefixOf(tunnel, ktunnelsv1.SinglePortName); got != "default_microservice_database" {
+		t.Errorf("StatPrefixOf wants default_microservice_database but got %s", got)
+	}
+
+	t.Run("multiple ports", func(t *testing.T) {
+		tunnel := &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "broker"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Ports: []ktunnelsv1.TunnelPort{{Name: "amqp", Port: 5672}},
+			},
+		}
+		if got := StatPrefixOf(tunnel, "amqp"); got != "default_broker__amqp" {
+			t.Errorf("StatPrefixOf wants default_broker__amqp but got %s", got)
+		}
+	})
+}
+
+func TestGetTunnelStats(t *testing.T) {
+	tunnels := []*ktunnelsv1.Tunnel{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "microservice-database"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "unused-database"}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "redis"}},
+		{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: "api"},
+			Spec:       ktunnelsv1.TunnelSpec{TLSPassthrough: true},
+		},
+	}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/stats" || r.URL.Query().Get("format") != "json" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("content-type", "application/json")
+		_, _ = w.Write([]byte(`{"stats":[
+{"name":"listener.default_microservice-database.downstream_cx_active","value":2},
+{"name":"listener.default_microservice-database.downstream_cx_total","value":100},
+{"name":"tcp.default_microservice-database.downstream_cx_total","value":5},
+{"name":"tcp.default_microservice-database.downstream_cx_rx_bytes_total","value":1024},
+{"name":"tcp.default_microservice-database.downstream_cx_tx_bytes_total","value":2048},
+{"name":"tcp.default_unused-database.downstream_cx_total","value":0},
+{"name":"default_microservice-database.rbac.denied","value":3},
+{"name":"default_microservice-database.rbac.allowed","value":5},
+{"name":"redis.default_redis.downstream_cx_total","value":7},
+{"name":"redis.default_redis.command.get.total","value":42},
+{"name":"tcp.admin.downstream_cx_total","value":3},
+{"name":"listener.tls_passthrough.downstream_cx_active","value":4},
+{"name":"tcp.default_api.downstream_cx_total","value":9},
+{"histograms":{}}
+]}`))
+	}))
+	defer ts.Close()
+
+	got, err := GetTunnelStats(context.TODO(), ts.Client(), ts.URL, tunnels)
+	if err != nil {
+		t.Fatalf("GetTunnelStats: %s", err)
+	}
+	want := map[string]TunnelStats{
+		"microservice-database": {
+			ActiveConnections: ptr.To[int64](2),
+			TotalConnections:  5,
+			ReceivedBytes:     1024,
+			SentBytes:         2048,
+			DeniedConnections: 3,
+		},
+		"unused-database": {},
+		"redis":           {TotalConnections: 7},
+		// the listener of TLS passthrough is shared by the tunnels
+		"api": {TotalConnections: 9},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("mismatch (-want +got):\n%s", diff)
+	}
+
+	t.Run("error response", func(t *testing.T) {
+		ts := httptest.NewServer(http.NotFoundHandler())
+		defer ts.Close()
+		if _, err := GetTunnelStats(context.TODO(), ts.Client(), ts.URL, tunnels); err == nil {
+			t.Errorf("err wants non-nil but got nil")
+		}
+	})
+}
@@ -0,0 +1,91 @@
+package envoy
+
+import (
+	"time"
+
+	clusterv3 "github.com/envoyproxy/go-control-plane/envoy/config/cluster/v3"
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+// defaultConnectTimeout is the timeout to connect to the destination if not set.
+const defaultConnectTimeout = 30 * time.Second
+
+// timeoutsOf returns the timeouts of the tunnel.
+// Each field of the tunnel takes precedence over the default of the proxy.
+func timeoutsOf(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) ktunnelsv1.TunnelTimeouts {
+	proxyTimeouts := ptr.Deref(opts.timeouts, ktunnelsv1.TunnelTimeouts{})
+	tunnelTimeouts := ptr.Deref(tunnel.Spec.Timeouts, ktunnelsv1.TunnelTimeouts{})
+	return ktunnelsv1.TunnelTimeouts{
+		Connect:               mergePointer(proxyTimeouts.Connect, tunnelTimeouts.Connect),
+		Idle:                  mergePointer(proxyTimeouts.Idle, tunnelTimeouts.Idle),
+		MaxConnectionDuration: mergePointer(proxyTimeouts.MaxConnectionDuration, tunnelTimeouts.MaxConnectionDuration),
+	}
+}
+
+// keepaliveOf returns the TCP keepalive of the tunnel.
+// Each field of the tunnel takes precedence over the default of the proxy.
+// It returns nil if neither is set, or the protocol is Redis which does not support keepalive.
+func keepaliveOf(tunnel *ktunnelsv1.Tunnel, opts resourceOptions) *ktunnelsv1.TunnelKeepalive {
+	if opts.keepalive == nil && tunnel.Spec.Keepalive == nil {
+		return nil
+	}
+	if tunnel.Spec.Protocol == ktunnelsv1.TunnelProtocolRedis {
+		return nil
+	}
+	proxyKeepalive := ptr.Deref(opts.keepalive, ktunnelsv1.TunnelKeepalive{})
+	tunnelKeepalive := ptr.Deref(tunnel.Spec.Keepalive, ktunnelsv1.TunnelKeepalive{})
+	return &ktunnelsv1.TunnelKeepalive{
+		Time:     mergePointer(proxyKeepalive.Time, tunnelKeepalive.Time),
+		Interval: mergePointer(proxyKeepalive.Interval, tunnelKeepalive.Interval),
+		Probes:   mergePointer(proxyKeepalive.Probes, tunnelKeepalive.Probes),
+	}
+}
+
+// mergePointer returns the last non-nil pointer.
+func mergePointer[T any](overrides ...*T) *T {
+	for i := len(overrides) - 1; i >= 0; i-- {
+		if overrides[i] != nil {
+			return overrides[i]
+		}
+	}
+	return nil
+}
+
+func newConnectTimeout(timeouts ktunnelsv1.TunnelTimeouts) *durationpb.Duration {
+	if timeouts.Connect == nil {
+		return durationpb.New(defaultConnectTimeout)
+	}
+	return durationpb.New(timeouts.Connect.Duration)
+}
+
+// newDurationOrNil returns nil if the duration is not set, so that Envoy uses the default.
+func newDurationOrNil(d *metav1.Duration) *durationpb.Duration {
+	if d == nil {
+		return nil
+	}
+	return durationpb.New(d.Duration)
+}
+
+// newUpstreamConnectionOptions returns the options to enable TCP keepalive.
+// It returns nil if keepalive is not set.
+func newUpstreamConnectionOptions(keepalive *ktunnelsv1.TunnelKeepalive) *clusterv3.UpstreamConnectionOptions {
+	if keepalive == nil {
+		return nil
+	}
+	tcpKeepalive := &corev3.TcpKeepalive{}
+	if keepalive.Time != nil {
+		tcpKeepalive.KeepaliveTime = wrapperspb.UInt32(uint32(keepalive.Time.Seconds()))
+	}
+	if keepalive.Interval != nil {
+		tcpKeepalive.KeepaliveInterval = wrapperspb.UInt32(uint32(keepalive.Interval.Seconds()))
+	}
+	if keepalive.Probes != nil {
+		tcpKeepalive.KeepaliveProbes = wrapperspb.UInt32(uint32(*keepalive.Probes))
+	}
+	return &clusterv3.UpstreamConnectionOptions{TcpKeepalive: tcpKeepalive}
+}
@@ -0,0 +1,102 @@
+package envoy
+
+import (
+	"testing"
+	"time"
+
+	tcp_proxyv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/tcp_proxy/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+func Test_timeouts(t *testing.T) {
+	tunnel := &ktunnelsv1.Tunnel{
+		ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+		Spec: ktunnelsv1.TunnelSpec{
+			Host: "microservice-database.staging",
+			Port: 5432,
+			Timeouts: &ktunnelsv1.TunnelTimeouts{
+				Idle: &metav1.Duration{Duration: 8 * time.Hour},
+			},
+			Keepalive: &ktunnelsv1.TunnelKeepalive{
+				Probes: ptr.To[int32](3),
+			},
+		},
+		Status: ktunnelsv1.TunnelStatus{TransitPort: ptr.To[int32](20001)},
+	}
+	opts := resourceOptions{
+		timeouts: &ktunnelsv1.TunnelTimeouts{
+			Connect: &metav1.Duration{Duration: 5 * time.Second},
+			Idle:    &metav1.Duration{Duration: time.Hour},
+		},
+		keepalive: &ktunnelsv1.TunnelKeepalive{
+			Time:     &metav1.Duration{Duration: time.Minute},
+			Interval: &metav1.Duration{Duration: 10 * time.Second},
+		},
+	}
+
+	t.Run("cluster", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{tunnel}, opts)
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		cluster := clusters[0]
+		if got := cluster.GetConnectTimeout().AsDuration(); got != 5*time.Second {
+			t.Errorf("connectTimeout wants 5s but got %s", got)
+		}
+		tcpKeepalive := cluster.GetUpstreamConnectionOptions().GetTcpKeepalive()
+		if got := tcpKeepalive.GetKeepaliveTime().GetValue(); got != 60 {
+			t.Errorf("keepaliveTime wants 60 but got %d", got)
+		}
+		if got := tcpKeepalive.GetKeepaliveInterval().GetValue(); got != 10 {
+			t.Errorf("keepaliveInterval wants 10 but got %d", got)
+		}
+		if got := tcpKeepalive.GetKeepaliveProbes().GetValue(); got != 3 {
+			t.Errorf("keepaliveProbes wants 3 but got %d", got)
+		}
+	})
+	t.Run("tcp proxy", func(t *testing.T) {
+		listener, err := newListener(tunnel, ktunnelsv1.SinglePortName, 20001, opts)
+		if err != nil {
+			t.Fatalf("newListener: %s", err)
+		}
+		var tcpProxy tcp_proxyv3.TcpProxy
+		if err := listener.GetFilterChains()[0].GetFilters()[0].GetTypedConfig().UnmarshalTo(&tcpProxy); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		if got := tcpProxy.GetIdleTimeout().AsDuration(); got != 8*time.Hour {
+			t.Errorf("idleTimeout wants 8h but got %s", got)
+		}
+		if tcpProxy.GetMaxDownstreamConnectionDuration() != nil {
+			t.Errorf("maxDownstreamConnectionDuration wants nil but got %s", tcpProxy.GetMaxDownstreamConnectionDuration())
+		}
+	})
+	t.Run("Redis ignores the default keepalive of proxy", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "redis.staging", Port: 6379, Protocol: ktunnelsv1.TunnelProtocolRedis},
+		}}, opts)
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		if clusters[0].GetUpstreamConnectionOptions() != nil {
+			t.Errorf("upstreamConnectionOptions wants nil but got %s", clusters[0].GetUpstreamConnectionOptions())
+		}
+	})
+	t.Run("default", func(t *testing.T) {
+		clusters, err := newClusters([]*ktunnelsv1.Tunnel{{
+			ObjectMeta: metav1.ObjectMeta{Name: "redis", Namespace: "default"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: "redis.staging", Port: 6379},
+		}}, resourceOptions{})
+		if err != nil {
+			t.Fatalf("newClusters: %s", err)
+		}
+		if got := clusters[0].GetConnectTimeout().AsDuration(); got != 30*time.Second {
+			t.Errorf("connectTimeout wants 30s but got %s", got)
+		}
+		if clusters[0].GetUpstreamConnectionOptions() != nil {
+			t.Errorf("upstreamConnectionOptions wants nil but got %s", clusters[0].GetUpstreamConnectionOptions())
+		}
+	})
+}
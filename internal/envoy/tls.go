@@ -0,0 +1,284 @@
+package envoy
+
+import (
+	"fmt"
+	"net"
+	"path"
+	"slices"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	matcherv3 "github.com/envoyproxy/go-control-plane/envoy/type/matcher/v3"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	// secretMountPath is the directory where the Secret of the proxy is mounted.
+	// This is not under /etc/envoy, because a ConfigMap volume is read-only.
+	secretMountPath = "/etc/envoy-secrets"
+
+	// sdsFileName is the key of the SDS file in the Secret of the proxy.
+	sdsFileName = "sds.json"
+
+	// systemCAFile is the CA bundle shipped with the Envoy image.
+	systemCAFile = "/etc/ssl/certs/ca-certificates.crt"
+)
+
+// SecretNamesOf returns the names of the secrets referenced by the tunnels.
+func SecretNamesOf(tunnels []*ktunnelsv1.Tunnel) []string {
+	var names []string
+	for _, tunnel := range tunnels {
+		for _, name := range tunnel.GetSecretNames() {
+			if !slices.Contains(names, name) {
+				names = append(names, name)
+			}
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// SecretKeysOf returns the keys of each secret referenced by the tunnels.
+func SecretKeysOf(tunnels []*ktunnelsv1.Tunnel) map[string][]string {
+	keysBySecretName := make(map[string][]string)
+	add := func(secretName string, keys ...string) {
+		for _, key := range keys {
+			if !slices.Contains(keysBySecretName[secretName], key) {
+				keysBySecretName[secretName] = append(keysBySecretName[secretName], key)
+			}
+		}
+	}
+	for _, tunnel := range tunnels {
+		if tunnel.Spec.TLS != nil {
+			if ref := tunnel.Spec.TLS.CASecretRef; ref != nil {
+				add(ref.Name, ktunnelsv1.TLSSecretKeyCA)
+			}
+			if ref := tunnel.Spec.TLS.ClientCertificateSecretRef; ref != nil {
+				add(ref.Name, ktunnelsv1.TLSSecretKeyCertificate, ktunnelsv1.TLSSecretKeyPrivateKey)
+			}
+		}
+		for _, ref := range headerSecretKeyRefsOf(tunnel) {
+			add(ref.Name, ref.Key)
+		}
+	}
+	return keysBySecretName
+}
+
+// NewSecret returns a Secret with the SDS file and the keys of the Secrets referenced by the tunnels.
+// The secrets are the Secrets referenced by the tunnels, keyed by the name.
+// The SDS file contains only the paths of the keys,
+// so that no secret value is written to the ConfigMap or sent via xDS.
+func NewSecret(key types.NamespacedName, tunnels []*ktunnelsv1.Tunnel, secrets map[string]*corev1.Secret) (corev1.Secret, error) {
+	data := make(map[string][]byte)
+	for secretName, keys := range SecretKeysOf(tunnels) {
+		secret, ok := secrets[secretName]
+		if !ok {
+			continue
+		}
+		for _, k := range keys {
+			if value := secret.Data[k]; len(value) > 0 {
+				data[secretFileNameOf(secretName, k)] = value
+			}
+		}
+	}
+	sds, err := generateSDS(tunnels, secrets)
+	if err != nil {
+		return corev1.Secret{}, fmt.Errorf("unable to generate SDS: %w", err)
+	}
+	data[sdsFileName] = []byte(sds)
+	return corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: key.Namespace,
+			Name:      key.Name,
+		},
+		Type: corev1.SecretTypeOpaque,
+		Data: data,
+	}, nil
+}
+
+// secretFileNameOf returns the key in the Secret of the proxy, corresponding to the key of the referenced Secret.
+// A name of Secret never contains an underscore, so the key is unique.
+func secretFileNameOf(secretName, key string) string {
+	return fmt.Sprintf("%s_%s", secretName, key)
+}
+
+// newSecretVolume returns the volume of the Secret of the proxy.
+// It does not depend on the tunnels, so that adding a secret does not restart the pods.
+// Envoy watches the directory and reloads the secrets when kubelet updates the volume.
+func newSecretVolume(secretName string) corev1.Volume {
+	return corev1.Volume{
+		Name: "envoy-secrets",
+		VolumeSource: corev1.VolumeSource{
+			Secret: &corev1.SecretVolumeSource{SecretName: secretName},
+		},
+	}
+}
+
+// sdsSecretNameOf returns the name of the SDS secret resource.
+// A Secret may be used as both CA and client certificate, so the kind is prepended.
+func sdsSecretNameOf(kind, secretName string) string {
+	return fmt.Sprintf("%s/%s", kind, secretName)
+}
+
+const (
+	sdsSecretKindCA          = "ca"
+	sdsSecretKindCertificate = "certificate"
+)
+
+// newSdsSecretConfig returns the config to read the secret from the SDS file in the Secret of the proxy.
+// Envoy reloads the SDS file and the referenced files when kubelet updates the volume.
+func newSdsSecretConfig(name string) *tlsv3.SdsSecretConfig {
+	return &tlsv3.SdsSecretConfig{
+		Name: name,
+		SdsConfig: &corev3.ConfigSource{
+			ResourceApiVersion: corev3.ApiVersion_V3,
+			ConfigSourceSpecifier: &corev3.ConfigSource_PathConfigSource{
+				PathConfigSource: &corev3.PathConfigSource{
+					Path:             path.Join(secretMountPath, sdsFileName),
+					WatchedDirectory: &corev3.WatchedDirectory{Path: secretMountPath},
+				},
+			},
+		},
+	}
+}
+
+func newFileDataSource(secretName, key string) *corev3.DataSource {
+	return &corev3.DataSource{
+		Specifier: &corev3.DataSource_Filename{
+			Filename: path.Join(secretMountPath, secretFileNameOf(secretName, key)),
+		},
+	}
+}
+
+// newUpstreamTransportSocket returns the transport socket to originate TLS to the destination.
+// It returns nil if TLS is not enabled.
+func newUpstreamTransportSocket(tunnel *ktunnelsv1.Tunnel) (*corev3.TransportSocket, error) {
+	tlsSpec := tunnel.Spec.TLS
+	if tlsSpec == nil {
+		return nil, nil
+	}
+	serverName := tlsSpec.ServerName
+	if host := tunnel.GetDestinations()[0].Host; serverName == "" && net.ParseIP(host) == nil {
+		serverName = host
+	}
+
+	commonTlsContext := &tlsv3.CommonTlsContext{}
+	if ref := tlsSpec.ClientCertificateSecretRef; ref != nil {
+		commonTlsContext.TlsCertificateSdsSecretConfigs = []*tlsv3.SdsSecretConfig{
+			newSdsSecretConfig(sdsSecretNameOf(sdsSecretKindCertificate, ref.Name)),
+		}
+	}
+	if !tlsSpec.InsecureSkipVerify {
+		validationContext := &tlsv3.CertificateValidationContext{}
+		if serverName != "" {
+			validationContext.MatchTypedSubjectAltNames = []*tlsv3.SubjectAltNameMatcher{
+				{
+					SanType: tlsv3.SubjectAltNameMatcher_DNS,
+					Matcher: &matcherv3.StringMatcher{
+						MatchPattern: &matcherv3.StringMatcher_Exact{Exact: serverName},
+					},
+				},
+			}
+		}
+		if ref := tlsSpec.CASecretRef; ref != nil {
+			commonTlsContext.ValidationContextType = &tlsv3.CommonTlsContext_CombinedValidationContext{
+				CombinedValidationContext: &tlsv3.CommonTlsContext_CombinedCertificateValidationContext{
+					DefaultValidationContext:         validationContext,
+					ValidationContextSdsSecretConfig: newSdsSecretConfig(sdsSecretNameOf(sdsSecretKindCA, ref.Name)),
+				},
+			}
+		} else {
+			validationContext.TrustedCa = &corev3.DataSource{
+				Specifier: &corev3.DataSource_Filename{Filename: systemCAFile},
+			}
+			commonTlsContext.ValidationContextType = &tlsv3.CommonTlsContext_ValidationContext{
+				ValidationContext: validationContext,
+			}
+		}
+	}
+
+	upstreamTlsContext, err := anypb.New(&tlsv3.UpstreamTlsContext{
+		CommonTlsContext: commonTlsContext,
+		Sni:              serverName,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("anypb.New(tlsv3.UpstreamTlsContext): %w", err)
+	}
+	return &corev3.TransportSocket{
+		Name:       "envoy.transport_sockets.tls",
+		ConfigType: &corev3.TransportSocket_TypedConfig{TypedConfig: upstreamTlsContext},
+	}, nil
+}
+
+// newSdsSecrets returns the SDS secret resources of the secrets referenced by the tunnels.
+// Each resource refers to the files in the Secret of the proxy.
+// A missing secret or key is skipped, and Envoy waits for it until the initial fetch timeout.
+func newSdsSecrets(tunnels []*ktunnelsv1.Tunnel, secrets map[string]*corev1.Secret) []*tlsv3.Secret {
+	var sdsSecrets []*tlsv3.Secret
+	seen := make(map[string]bool)
+	add := func(sdsSecret *tlsv3.Secret) {
+		if seen[sdsSecret.Name] {
+			return
+		}
+		seen[sdsSecret.Name] = true
+		sdsSecrets = append(sdsSecrets, sdsSecret)
+	}
+	for _, tunnel := range tunnels {
+		for _, ref := range headerSecretKeyRefsOf(tunnel) {
+			if secret, ok := secrets[ref.Name]; ok && len(secret.Data[ref.Key]) > 0 {
+				add(&tlsv3.Secret{
+					Name: headerSdsSecretNameOf(ref),
+					Type: &tlsv3.Secret_GenericSecret{
+						GenericSecret: &tlsv3.GenericSecret{Secret: newFileDataSource(ref.Name, ref.Key)},
+					},
+				})
+			}
+		}
+		if tunnel.Spec.TLS == nil {
+			continue
+		}
+		if ref := tunnel.Spec.TLS.CASecretRef; ref != nil {
+			if secret, ok := secrets[ref.Name]; ok && len(secret.Data[ktunnelsv1.TLSSecretKeyCA]) > 0 {
+				add(&tlsv3.Secret{
+					Name: sdsSecretNameOf(sdsSecretKindCA, ref.Name),
+					Type: &tlsv3.Secret_ValidationContext{
+						ValidationContext: &tlsv3.CertificateValidationContext{
+							TrustedCa:        newFileDataSource(ref.Name, ktunnelsv1.TLSSecretKeyCA),
+							WatchedDirectory: &corev3.WatchedDirectory{Path: secretMountPath},
+						},
+					},
+				})
+			}
+		}
+		if ref := tunnel.Spec.TLS.ClientCertificateSecretRef; ref != nil {
+			if secret, ok := secrets[ref.Name]; ok &&
+				len(secret.Data[ktunnelsv1.TLSSecretKeyCertificate]) > 0 &&
+				len(secret.Data[ktunnelsv1.TLSSecretKeyPrivateKey]) > 0 {
+				add(&tlsv3.Secret{
+					Name: sdsSecretNameOf(sdsSecretKindCertificate, ref.Name),
+					Type: &tlsv3.Secret_TlsCertificate{
+						TlsCertificate: &tlsv3.TlsCertificate{
+							CertificateChain: newFileDataSource(ref.Name, ktunnelsv1.TLSSecretKeyCertificate),
+							PrivateKey:       newFileDataSource(ref.Name, ktunnelsv1.TLSSecretKeyPrivateKey),
+							WatchedDirectory: &corev3.WatchedDirectory{Path: secretMountPath},
+						},
+					},
+				})
+			}
+		}
+	}
+	return sdsSecrets
+}
+
+func generateSDS(tunnels []*ktunnelsv1.Tunnel, secrets map[string]*corev1.Secret) (string, error) {
+	var resources []proto.Message
+	for _, sdsSecret := range newSdsSecrets(tunnels, secrets) {
+		resources = append(resources, sdsSecret)
+	}
+	return marshalDiscoveryResponse(resources)
+}
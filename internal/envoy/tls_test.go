@@ -0,0 +1,157 @@
+package envoy
+
+import (
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+
+	tlsv3 "github.com/envoyproxy/go-control-plane/envoy/extensions/transport_sockets/tls/v3"
+	"github.com/google/go-cmp/cmp"
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func Test_newUpstreamTransportSocket(t *testing.T) {
+	newTunnel := func(tlsSpec *ktunnelsv1.TunnelTLS) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "microservice-database.staging",
+				Port: 5432,
+				TLS:  tlsSpec,
+			},
+		}
+	}
+	unmarshal := func(t *testing.T, tunnel *ktunnelsv1.Tunnel) *tlsv3.UpstreamTlsContext {
+		t.Helper()
+		transportSocket, err := newUpstreamTransportSocket(tunnel)
+		if err != nil {
+			t.Fatalf("newUpstreamTransportSocket: %s", err)
+		}
+		var upstreamTlsContext tlsv3.UpstreamTlsContext
+		if err := transportSocket.GetTypedConfig().UnmarshalTo(&upstreamTlsContext); err != nil {
+			t.Fatalf("UnmarshalTo: %s", err)
+		}
+		return &upstreamTlsContext
+	}
+
+	t.Run("TLS is not enabled", func(t *testing.T) {
+		transportSocket, err := newUpstreamTransportSocket(newTunnel(nil))
+		if err != nil {
+			t.Fatalf("newUpstreamTransportSocket: %s", err)
+		}
+		if transportSocket != nil {
+			t.Errorf("transportSocket wants nil but got %v", transportSocket)
+		}
+	})
+	t.Run("system CA", func(t *testing.T) {
+		got := unmarshal(t, newTunnel(&ktunnelsv1.TunnelTLS{}))
+		if got.GetSni() != "microservice-database.staging" {
+			t.Errorf("sni wants the host but got %s", got.GetSni())
+		}
+		trustedCA := got.GetCommonTlsContext().GetValidationContext().GetTrustedCa().GetFilename()
+		if trustedCA != systemCAFile {
+			t.Errorf("trustedCa wants %s but got %s", systemCAFile, trustedCA)
+		}
+	})
+	t.Run("SDS", func(t *testing.T) {
+		got := unmarshal(t, newTunnel(&ktunnelsv1.TunnelTLS{
+			ServerName:                 "db.example.com",
+			CASecretRef:                &corev1.LocalObjectReference{Name: "database-ca"},
+			ClientCertificateSecretRef: &corev1.LocalObjectReference{Name: "database-client"},
+		}))
+		if got.GetSni() != "db.example.com" {
+			t.Errorf("sni wants db.example.com but got %s", got.GetSni())
+		}
+		caSecretName := got.GetCommonTlsContext().GetCombinedValidationContext().GetValidationContextSdsSecretConfig().GetName()
+		if want := "ca/database-ca"; caSecretName != want {
+			t.Errorf("validation context secret wants %s but got %s", want, caSecretName)
+		}
+		certificateSecretName := got.GetCommonTlsContext().GetTlsCertificateSdsSecretConfigs()[0].GetName()
+		if want := "certificate/database-client"; certificateSecretName != want {
+			t.Errorf("certificate secret wants %s but got %s", want, certificateSecretName)
+		}
+		sdsPath := got.GetCommonTlsContext().GetTlsCertificateSdsSecretConfigs()[0].GetSdsConfig().GetPathConfigSource().GetPath()
+		if want := "/etc/envoy-secrets/sds.json"; sdsPath != want {
+			t.Errorf("path of SDS wants %s but got %s", want, sdsPath)
+		}
+	})
+	t.Run("insecure", func(t *testing.T) {
+		got := unmarshal(t, newTunnel(&ktunnelsv1.TunnelTLS{InsecureSkipVerify: true}))
+		if got.GetCommonTlsContext().GetValidationContextType() != nil {
+			t.Errorf("validation context wants nil but got %v", got.GetCommonTlsContext().GetValidationContextType())
+		}
+	})
+}
+
+func Test_newSdsSecrets(t *testing.T) {
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "microservice-database.staging",
+				Port: 5432,
+				TLS: &ktunnelsv1.TunnelTLS{
+					CASecretRef:                &corev1.LocalObjectReference{Name: "database-ca"},
+					ClientCertificateSecretRef: &corev1.LocalObjectReference{Name: "missing"},
+				},
+			},
+		},
+	}
+	secrets := map[string]*corev1.Secret{
+		"database-ca": {Data: map[string][]byte{"ca.crt": []byte("CA")}},
+	}
+	got := newSdsSecrets(tunnels, secrets)
+	if len(got) != 1 {
+		t.Fatalf("len(secrets) wants 1 but got %d", len(got))
+	}
+	if got[0].GetName() != "ca/database-ca" {
+		t.Errorf("name wants ca/database-ca but got %s", got[0].GetName())
+	}
+	trustedCA := got[0].GetValidationContext().GetTrustedCa().GetFilename()
+	if want := "/etc/envoy-secrets/database-ca_ca.crt"; trustedCA != want {
+		t.Errorf("trustedCa wants %s but got %s", want, trustedCA)
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	tunnels := []*ktunnelsv1.Tunnel{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "default"},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host: "microservice-database.staging",
+				Port: 5432,
+				TLS: &ktunnelsv1.TunnelTLS{
+					CASecretRef:                &corev1.LocalObjectReference{Name: "database-ca"},
+					ClientCertificateSecretRef: &corev1.LocalObjectReference{Name: "missing"},
+				},
+			},
+		},
+	}
+	secrets := map[string]*corev1.Secret{
+		"database-ca": {Data: map[string][]byte{"ca.crt": []byte("CA"), "unused": []byte("UNUSED")}},
+	}
+	got, err := NewSecret(types.NamespacedName{Namespace: "default", Name: "ktunnels-proxy-example"}, tunnels, secrets)
+	if err != nil {
+		t.Fatalf("NewSecret: %s", err)
+	}
+	if got.Name != "ktunnels-proxy-example" {
+		t.Errorf("name wants ktunnels-proxy-example but got %s", got.Name)
+	}
+	if diff := cmp.Diff([]string{"database-ca_ca.crt", "sds.json"}, slices.Sorted(maps.Keys(got.Data))); diff != "" {
+		t.Errorf("keys mismatch (-want +got):\n%s", diff)
+	}
+	if value := string(got.Data["database-ca_ca.crt"]); value != "CA" {
+		t.Errorf("ca.crt wants CA but got %s", value)
+	}
+	sds := string(got.Data["sds.json"])
+	if !strings.Contains(sds, "/etc/envoy-secrets/database-ca_ca.crt") {
+		t.Errorf("sds wants the file of the secret but got %s", sds)
+	}
+	if strings.Contains(sds, "CA\"") {
+		t.Errorf("sds wants no secret value but got %s", sds)
+	}
+}
@@ -0,0 +1,253 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/utils/ptr"
+)
+
+// ErrDenied indicates that a destination of the tunnel is denied by the tunnel policies.
+var ErrDenied = errors.New("denied by tunnel policy")
+
+// DeniedError represents an error when a destination of the tunnel is denied.
+// It matches ErrDenied by errors.Is.
+type DeniedError struct {
+	// Destination is the host:port of the tunnel.
+	Destination string
+	// Policy is the name of the policy which denies the destination.
+	// It is empty if the destination does not match any allow rule.
+	Policy string
+	// Cause is set if the policy is invalid or the host cannot be resolved.
+	Cause error
+}
+
+func (e *DeniedError) Error() string {
+	switch {
+	case e.Cause != nil:
+		return fmt.Sprintf("destination %s is denied by TunnelPolicy %s: %s", e.Destination, e.Policy, e.Cause)
+	case e.Policy != "":
+		return fmt.Sprintf("destination %s is denied by TunnelPolicy %s", e.Destination, e.Policy)
+	}
+	return fmt.Sprintf("destination %s is not allowed by any TunnelPolicy", e.Destination)
+}
+
+func (e *DeniedError) Is(target error) bool {
+	return target == ErrDenied
+}
+
+// Resolver resolves a hostname to the IP addresses.
+// net.DefaultResolver implements this interface.
+type Resolver interface {
+	LookupNetIP(ctx context.Context, network, host string) ([]netip.Addr, error)
+}
+
+// resolveTimeout is the timeout to resolve a hostname of the destination.
+const resolveTimeout = 5 * time.Second
+
+// Check returns an error if any destination of the tunnel is denied by the policies.
+// Only the policies which select the namespace are evaluated.
+// A deny rule takes precedence over allow rules.
+// If no policy has allow rules, a destination is allowed unless it is denied.
+// A hostname is resolved by the resolver if a policy has CIDRs.
+// Since the address of a hostname may change, the caller should check the tunnel again periodically.
+func Check(ctx context.Context, resolver Resolver, policies []ktunnelsv1.TunnelPolicy, namespace corev1.Namespace, tunnel *ktunnelsv1.Tunnel) error {
+	var applied []*ktunnelsv1.TunnelPolicy
+	for i := range policies {
+		policy := &policies[i]
+		selected, err := selectsNamespace(policy, namespace)
+		if err != nil {
+			return &DeniedError{Destination: tunnel.GetDestinations()[0].Host, Policy: policy.Name, Cause: err}
+		}
+		if selected {
+			applied = append(applied, policy)
+		}
+	}
+	if len(applied) == 0 {
+		return nil
+	}
+	for _, destination := range tunnel.GetDestinations() {
+		for _, port := range tunnel.GetPorts() {
+			if err := checkDestination(ctx, resolver, applied, destination.Host, port.Port); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func selectsNamespace(policy *ktunnelsv1.TunnelPolicy, namespace corev1.Namespace) (bool, error) {
+	if policy.Spec.NamespaceSelector == nil {
+		return true, nil
+	}
+	selector, err := metav1.LabelSelectorAsSelector(policy.Spec.NamespaceSelector)
+	if err != nil {
+		return false, fmt.Errorf("invalid namespaceSelector: %w", err)
+	}
+	return selector.Matches(labels.Set(namespace.Labels)), nil
+}
+
+// HasCIDRs returns true if any policy has CIDRs.
+// The result of Check may change when the address of a hostname is changed.
+func HasCIDRs(policies []ktunnelsv1.TunnelPolicy) bool {
+	return slices.ContainsFunc(policies, func(policy ktunnelsv1.TunnelPolicy) bool {
+		return hasCIDRs(&policy)
+	})
+}
+
+func hasCIDRs(policy *ktunnelsv1.TunnelPolicy) bool {
+	hasRuleCIDRs := func(rule ktunnelsv1.TunnelPolicyRule) bool { return len(rule.CIDRs) > 0 }
+	return slices.ContainsFunc(policy.Spec.Allow, hasRuleCIDRs) || slices.ContainsFunc(policy.Spec.Deny, hasRuleCIDRs)
+}
+
+// addressesOf returns the IP addresses of the host.
+// A hostname is resolved only if a policy has CIDRs.
+func addressesOf(ctx context.Context, resolver Resolver, policies []*ktunnelsv1.TunnelPolicy, host string) ([]netip.Addr, error) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}, nil
+	}
+	if !slices.ContainsFunc(policies, hasCIDRs) {
+		return nil, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, resolveTimeout)
+	defer cancel()
+	addrs, err := resolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return nil, fmt.Errorf("unable to resolve the host: %w", err)
+	}
+	if len(addrs) == 0 {
+		return nil, fmt.Errorf("no address of the host")
+	}
+	for i := range addrs {
+		addrs[i] = addrs[i].Unmap()
+	}
+	return addrs, nil
+}
+
+func checkDestination(ctx context.Context, resolver Resolver, policies []*ktunnelsv1.TunnelPolicy, host string, port int32) error {
+	destination := net.JoinHostPort(host, fmt.Sprint(port))
+	addrs, resolveErr := addressesOf(ctx, resolver, policies, host)
+	var hasAllowRules, allowed bool
+	for _, policy := range policies {
+		for _, rule := range policy.Spec.Deny {
+			matched, err := matchRule(rule, host, addrs, port, true)
+			if err != nil {
+				return &DeniedError{Destination: destination, Policy: policy.Name, Cause: err}
+			}
+			if matched {
+				return &DeniedError{Destination: destination, Policy: policy.Name}
+			}
+			// deny the host if it cannot be determined whether the address matches the CIDRs
+			if resolveErr != nil && len(rule.CIDRs) > 0 && matchRulePort(rule, port) {
+				return &DeniedError{Destination: destination, Policy: policy.Name, Cause: resolveErr}
+			}
+		}
+		for _, rule := range policy.Spec.Allow {
+			hasAllowRules = true
+			matched, err := matchRule(rule, host, addrs, port, false)
+			if err != nil {
+				return &DeniedError{Destination: destination, Policy: policy.Name, Cause: err}
+			}
+			if matched {
+				allowed = true
+			}
+		}
+	}
+	if hasAllowRules && !allowed {
+		return &DeniedError{Destination: destination}
+	}
+	return nil
+}
+
+func matchRule(rule ktunnelsv1.TunnelPolicyRule, host string, addrs []netip.Addr, port int32, deny bool) (bool, error) {
+	hostMatched, err := matchRuleHost(rule, host, addrs, deny)
+	if err != nil {
+		return false, err
+	}
+	return hostMatched && matchRulePort(rule, port), nil
+}
+
+// matchRuleHost returns true if the host matches the hosts or the addresses match the CIDRs.
+// For a deny rule, it matches if any address is in the CIDRs.
+// For an allow rule, it matches only if all addresses are in the CIDRs.
+func matchRuleHost(rule ktunnelsv1.TunnelPolicyRule, host string, addrs []netip.Addr, deny bool) (bool, error) {
+	if len(rule.Hosts) == 0 && len(rule.CIDRs) == 0 {
+		return true, nil
+	}
+	var matched bool
+	for _, pattern := range rule.Hosts {
+		if matchHost(pattern, host) {
+			matched = true
+		}
+	}
+	// validate all CIDRs even if the host is not resolved
+	var prefixes []netip.Prefix
+	for _, cidr := range rule.CIDRs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return false, fmt.Errorf("invalid CIDR %q: %w", cidr, err)
+		}
+		prefixes = append(prefixes, prefix)
+	}
+	if len(prefixes) == 0 || len(addrs) == 0 {
+		return matched, nil
+	}
+	inPrefixes := func(addr netip.Addr) bool {
+		return slices.ContainsFunc(prefixes, func(prefix netip.Prefix) bool { return prefix.Contains(addr) })
+	}
+	if deny {
+		return matched || slices.ContainsFunc(addrs, inPrefixes), nil
+	}
+	notInPrefixes := func(addr netip.Addr) bool { return !inPrefixes(addr) }
+	return matched || !slices.ContainsFunc(addrs, notInPrefixes), nil
+}
+
+// matchHost returns true if the host matches the glob pattern.
+// A leading wildcard label such as *.example.com matches one or more labels.
+func matchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+		for i := strings.Index(host, "."); i >= 0; {
+			if matchLabels(suffix, host[i+1:]) {
+				return true
+			}
+			next := strings.Index(host[i+1:], ".")
+			if next < 0 {
+				break
+			}
+			i += next + 1
+		}
+		return false
+	}
+	return matchLabels(pattern, host)
+}
+
+// matchLabels matches the pattern label by label, so that a wildcard does not match a dot.
+func matchLabels(pattern, host string) bool {
+	matched, err := path.Match(strings.ReplaceAll(pattern, ".", "/"), strings.ReplaceAll(host, ".", "/"))
+	return err == nil && matched
+}
+
+func matchRulePort(rule ktunnelsv1.TunnelPolicyRule, port int32) bool {
+	if len(rule.Ports) == 0 {
+		return true
+	}
+	for _, r := range rule.Ports {
+		if r.Port <= port && port <= ptr.Deref(r.EndPort, r.Port) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,184 @@
+package policy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"testing"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+)
+
+type fakeResolver map[string][]netip.Addr
+
+func (r fakeResolver) LookupNetIP(_ context.Context, _, host string) ([]netip.Addr, error) {
+	addrs, ok := r[host]
+	if !ok {
+		return nil, fmt.Errorf("no such host %s", host)
+	}
+	return addrs, nil
+}
+
+func TestCheck(t *testing.T) {
+	resolver := fakeResolver{
+		"db.staging.example.com":       {netip.MustParseAddr("172.16.1.2")},
+		"internal.example.com":         {netip.MustParseAddr("10.1.2.4"), netip.MustParseAddr("10.1.2.5")},
+		"partial.example.com":          {netip.MustParseAddr("10.1.2.6"), netip.MustParseAddr("192.168.1.2")},
+		"metadata.attacker.example":    {netip.MustParseAddr("169.254.169.254")},
+		"metadata-v6.attacker.example": {netip.MustParseAddr("::ffff:169.254.169.254")},
+	}
+	newTunnel := func(host string, port int32) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Name: "microservice-database", Namespace: "staging"},
+			Spec:       ktunnelsv1.TunnelSpec{Host: host, Port: port},
+		}
+	}
+	namespace := corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "staging", Labels: map[string]string{"env": "staging"}},
+	}
+	policies := []ktunnelsv1.TunnelPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "staging"},
+			Spec: ktunnelsv1.TunnelPolicySpec{
+				Allow: []ktunnelsv1.TunnelPolicyRule{
+					{
+						Hosts: []string{"*.staging.example.com"},
+						Ports: []ktunnelsv1.TunnelPolicyPortRange{{Port: 5432}, {Port: 6379, EndPort: ptr.To[int32](6380)}},
+					},
+					{
+						CIDRs: []string{"10.0.0.0/8"},
+					},
+				},
+				Deny: []ktunnelsv1.TunnelPolicyRule{
+					{Hosts: []string{"secret.staging.example.com"}},
+				},
+			},
+		},
+	}
+
+	for _, c := range []struct {
+		host    string
+		port    int32
+		allowed bool
+	}{
+		{host: "db.staging.example.com", port: 5432, allowed: true},
+		{host: "db.shard1.staging.example.com", port: 5432, allowed: true},
+		{host: "DB.Staging.Example.com", port: 6380, allowed: true},
+		{host: "db.staging.example.com", port: 6381},
+		{host: "staging.example.com", port: 5432},
+		{host: "db.production.example.com", port: 5432},
+		{host: "secret.staging.example.com", port: 5432},
+		{host: "10.1.2.3", port: 22, allowed: true},
+		{host: "192.168.1.2", port: 5432},
+		{host: "internal.example.com", port: 22, allowed: true},
+		{host: "partial.example.com", port: 22},
+		{host: "unknown.example.com", port: 22},
+	} {
+		t.Run(c.host, func(t *testing.T) {
+			err := Check(context.TODO(), resolver, policies, namespace, newTunnel(c.host, c.port))
+			if c.allowed && err != nil {
+				t.Errorf("Check wants nil but got %s", err)
+			}
+			if !c.allowed && !errors.Is(err, ErrDenied) {
+				t.Errorf("Check wants ErrDenied but got %v", err)
+			}
+		})
+	}
+
+	t.Run("no policy", func(t *testing.T) {
+		if err := Check(context.TODO(), resolver, nil, namespace, newTunnel("db.production.example.com", 5432)); err != nil {
+			t.Errorf("Check wants nil but got %s", err)
+		}
+	})
+	t.Run("namespace is not selected", func(t *testing.T) {
+		policies := []ktunnelsv1.TunnelPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "production"},
+				Spec: ktunnelsv1.TunnelPolicySpec{
+					NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"env": "production"}},
+					Deny:              []ktunnelsv1.TunnelPolicyRule{{}},
+				},
+			},
+		}
+		if err := Check(context.TODO(), resolver, policies, namespace, newTunnel("db.staging.example.com", 5432)); err != nil {
+			t.Errorf("Check wants nil but got %s", err)
+		}
+	})
+	t.Run("deny only", func(t *testing.T) {
+		policies := []ktunnelsv1.TunnelPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "metadata"},
+				Spec: ktunnelsv1.TunnelPolicySpec{
+					Deny: []ktunnelsv1.TunnelPolicyRule{{CIDRs: []string{"169.254.169.254/32"}}},
+				},
+			},
+		}
+		if err := Check(context.TODO(), resolver, policies, namespace, newTunnel("db.staging.example.com", 5432)); err != nil {
+			t.Errorf("Check wants nil but got %s", err)
+		}
+		err := Check(context.TODO(), resolver, policies, namespace, newTunnel("169.254.169.254", 80))
+		var deniedError *DeniedError
+		if !errors.As(err, &deniedError) {
+			t.Fatalf("Check wants DeniedError but got %v", err)
+		}
+		if deniedError.Policy != "metadata" {
+			t.Errorf("Policy wants metadata but got %s", deniedError.Policy)
+		}
+		for _, host := range []string{"metadata.attacker.example", "metadata-v6.attacker.example"} {
+			if err := Check(context.TODO(), resolver, policies, namespace, newTunnel(host, 80)); !errors.Is(err, ErrDenied) {
+				t.Errorf("Check(%s) wants ErrDenied but got %v", host, err)
+			}
+		}
+		err = Check(context.TODO(), resolver, policies, namespace, newTunnel("unknown.example.com", 80))
+		if !errors.As(err, &deniedError) {
+			t.Fatalf("Check wants DeniedError but got %v", err)
+		}
+		if deniedError.Cause == nil {
+			t.Errorf("Cause wants the error of resolution but got nil")
+		}
+	})
+	t.Run("one of multiple ports is denied", func(t *testing.T) {
+		tunnel := newTunnel("db.staging.example.com", 0)
+		tunnel.Spec.Ports = []ktunnelsv1.TunnelPort{{Name: "postgres", Port: 5432}, {Name: "ssh", Port: 22}}
+		if err := Check(context.TODO(), resolver, policies, namespace, tunnel); !errors.Is(err, ErrDenied) {
+			t.Errorf("Check wants ErrDenied but got %v", err)
+		}
+	})
+	t.Run("one of multiple destinations is denied", func(t *testing.T) {
+		tunnel := newTunnel("", 5432)
+		tunnel.Spec.Destinations = []ktunnelsv1.TunnelDestination{
+			{Host: "db.staging.example.com"},
+			{Host: "db.production.example.com", Priority: 1},
+		}
+		err := Check(context.TODO(), resolver, policies, namespace, tunnel)
+		var deniedError *DeniedError
+		if !errors.As(err, &deniedError) {
+			t.Fatalf("Check wants DeniedError but got %v", err)
+		}
+		if deniedError.Destination != "db.production.example.com:5432" {
+			t.Errorf("Destination wants db.production.example.com:5432 but got %s", deniedError.Destination)
+		}
+	})
+	t.Run("invalid CIDR", func(t *testing.T) {
+		policies := []ktunnelsv1.TunnelPolicy{
+			{
+				ObjectMeta: metav1.ObjectMeta{Name: "invalid"},
+				Spec: ktunnelsv1.TunnelPolicySpec{
+					Deny: []ktunnelsv1.TunnelPolicyRule{{CIDRs: []string{"10.0.0.0"}}},
+				},
+			},
+		}
+		err := Check(context.TODO(), resolver, policies, namespace, newTunnel("db.staging.example.com", 5432))
+		var deniedError *DeniedError
+		if !errors.As(err, &deniedError) {
+			t.Fatalf("Check wants DeniedError but got %v", err)
+		}
+		if deniedError.Cause == nil {
+			t.Errorf("Cause wants non-nil but got nil")
+		}
+	})
+}
@@ -1,53 +1,408 @@
 package transit
 
 import (
+	"cmp"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"slices"
+	"strconv"
+	"strings"
+
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/utils/ptr"
 )
 
+// Options represents the options to allocate transit ports.
+type Options struct {
+	// Range of transit ports.
+	// Default to DefaultRange if zero.
+	Range Range
+
+	// Strategy to allocate a transit port.
+	// Default to random.
+	Strategy ktunnelsv1.TransitPortStrategy
+
+	// ReservedPorts are never allocated, such as the ports of Envoy admin.
+	// A tunnel which has a reserved port is allocated again.
+	ReservedPorts []int32
+}
+
+// Result represents the result of allocation.
+type Result struct {
+	// Changed is the tunnels which have been changed, in the given order.
+	Changed []*ktunnelsv1.Tunnel
+
+	// Errors is the errors of the tunnels which could not be allocated.
+	Errors map[*ktunnelsv1.Tunnel]error
+
+	// Allocated is the number of transit ports allocated in the range.
+	Allocated int32
+
+	// Capacity is the number of transit ports in the range, excluding the reserved ports.
+	Capacity int32
+}
+
+// CountErrors returns the number of tunnels which have the error matching the target.
+func (r Result) CountErrors(target error) int {
+	var n int
+	for _, err := range r.Errors {
+		if errors.Is(err, target) {
+			n++
+		}
+	}
+	return n
+}
+
+// ErrPortPoolExhausted indicates that no transit port is available in the range.
+var ErrPortPoolExhausted = errors.New("transit port pool is exhausted")
+
+// PortPoolExhaustedError represents an error when no transit port is available for a port.
+// It matches ErrPortPoolExhausted by errors.Is.
+type PortPoolExhaustedError struct {
+	PortName string
+	Range    Range
+}
+
+func (e *PortPoolExhaustedError) Error() string {
+	return fmt.Sprintf("no transit port is available in the range %s for the port %s", e.Range, e.PortName)
+}
+
+func (e *PortPoolExhaustedError) Is(target error) bool {
+	return target == ErrPortPoolExhausted
+}
+
+// ErrTransitPortConflict indicates that the requested transit port is requested by another tunnel.
+var ErrTransitPortConflict = errors.New("transit port conflict")
+
+// TransitPortConflictError represents an error when the requested transit port is requested by an older tunnel.
+// It matches ErrTransitPortConflict by errors.Is.
+type TransitPortConflictError struct {
+	PortName    string
+	TransitPort int32
+	// Tunnel is the namespace/name of the tunnel which owns the transit port.
+	Tunnel string
+}
+
+func (e *TransitPortConflictError) Error() string {
+	return fmt.Sprintf("transit port %d for the port %s is already requested by the tunnel %s", e.TransitPort, e.PortName, e.Tunnel)
+}
+
+func (e *TransitPortConflictError) Is(target error) bool {
+	return target == ErrTransitPortConflict
+}
+
+// ErrTransitPortOutOfRange indicates that the requested transit port is not available in the range.
+var ErrTransitPortOutOfRange = errors.New("transit port out of range")
+
+// TransitPortOutOfRangeError represents an error when the requested transit port is out of the range or reserved.
+// It matches ErrTransitPortOutOfRange by errors.Is.
+type TransitPortOutOfRangeError struct {
+	PortName    string
+	TransitPort int32
+	Range       Range
+}
+
+func (e *TransitPortOutOfRangeError) Error() string {
+	return fmt.Sprintf("transit port %d for the port %s is reserved or out of the range %s", e.TransitPort, e.PortName, e.Range)
+}
+
+func (e *TransitPortOutOfRangeError) Is(target error) bool {
+	return target == ErrTransitPortOutOfRange
+}
+
 // AllocatePort updates nil transit port(s) to available port(s).
-// It returns the items which has been changed.
+// A transit port is allocated for each destination port of a tunnel.
+// An allocated port out of the range is allocated again,
+// so that a NetworkPolicy opening the range does not block the tunnel after the range is changed.
+// If no port is available, the transit port is left nil and the error is returned for the tunnel.
+//
+// If a port requests a transit port, it takes precedence over an automatically allocated port.
+// If the requested port is out of the range or requested by an older tunnel,
+// the transit port is left nil and the error is returned for the tunnel.
+//
+// A TLS passthrough tunnel shares the TLS passthrough port of the proxy,
+// so that it is never allocated and the transit ports are released.
 // Given array will be changed.
-func AllocatePort(mutableTunnels []*ktunnelsv1.Tunnel) []*ktunnelsv1.Tunnel {
-	return allocatePort(mutableTunnels, rand.Intn)
+func AllocatePort(mutableTunnels []*ktunnelsv1.Tunnel, opts Options) Result {
+	return allocatePort(mutableTunnels, opts, rand.Intn)
 }
 
 type randIntnFunc func(int) int
 
-func allocatePort(mutableTunnels []*ktunnelsv1.Tunnel, randIntn randIntnFunc) []*ktunnelsv1.Tunnel {
-	var needToReconcile []*ktunnelsv1.Tunnel
+func allocatePort(mutableTunnels []*ktunnelsv1.Tunnel, opts Options, randIntn randIntnFunc) Result {
+	if opts.Range == (Range{}) {
+		opts.Range = DefaultRange
+	}
+	var result Result
+	type tunnelPort struct {
+		tunnel *ktunnelsv1.Tunnel
+		name   string
+	}
+	var needToAllocate []tunnelPort
+	var changed = make(map[*ktunnelsv1.Tunnel]struct{})
 	var portSet = make(map[int32]struct{})
+	var reservedPorts = make(map[int32]struct{})
+	for _, p := range opts.ReservedPorts {
+		portSet[p] = struct{}{}
+		reservedPorts[p] = struct{}{}
+	}
+	markChanged := func(item *ktunnelsv1.Tunnel) {
+		if _, exists := changed[item]; !exists {
+			changed[item] = struct{}{}
+			result.Changed = append(result.Changed, item)
+		}
+	}
+	addError := func(item *ktunnelsv1.Tunnel, err error) {
+		if result.Errors == nil {
+			result.Errors = make(map[*ktunnelsv1.Tunnel]error)
+		}
+		result.Errors[item] = errors.Join(result.Errors[item], err)
+	}
 
-	for _, item := range mutableTunnels {
-		// tunnel is not allocated
-		if item.Status.TransitPort == nil {
-			needToReconcile = append(needToReconcile, item)
+	// claim the requested ports in order of creation
+	requestErrors := make(map[tunnelPort]error)
+	requestedBy := make(map[int32]*ktunnelsv1.Tunnel)
+	for _, item := range sortByCreation(mutableTunnels) {
+		if item.Spec.TLSPassthrough {
 			continue
 		}
-		// dedupe
-		if _, exists := portSet[*item.Status.TransitPort]; exists {
-			needToReconcile = append(needToReconcile, item)
+		for _, port := range item.GetPorts() {
+			if port.TransitPort == nil {
+				continue
+			}
+			p := *port.TransitPort
+			if _, reserved := reservedPorts[p]; reserved || !opts.Range.contains(p) {
+				requestErrors[tunnelPort{item, port.Name}] = &TransitPortOutOfRangeError{
+					PortName: port.Name, TransitPort: p, Range: opts.Range}
+				continue
+			}
+			if owner, exists := requestedBy[p]; exists {
+				requestErrors[tunnelPort{item, port.Name}] = &TransitPortConflictError{
+					PortName: port.Name, TransitPort: p, Tunnel: fmt.Sprintf("%s/%s", owner.Namespace, owner.Name)}
+				continue
+			}
+			requestedBy[p] = item
+			portSet[p] = struct{}{}
+		}
+	}
+
+	for _, item := range mutableTunnels {
+		if item.Spec.TLSPassthrough {
+			if releaseTransitPorts(item) {
+				markChanged(item)
+			}
 			continue
 		}
-		portSet[*item.Status.TransitPort] = struct{}{}
+		if pruneTransitPorts(item) {
+			markChanged(item)
+		}
+		for _, port := range item.GetPorts() {
+			transitPort := item.GetTransitPort(port.Name)
+			// port is requested
+			if port.TransitPort != nil {
+				if err, exists := requestErrors[tunnelPort{item, port.Name}]; exists {
+					addError(item, err)
+					if transitPort != nil {
+						item.SetTransitPort(port.Name, nil)
+						markChanged(item)
+					}
+					continue
+				}
+				if transitPort == nil || *transitPort != *port.TransitPort {
+					item.SetTransitPort(port.Name, ptr.To(*port.TransitPort))
+					markChanged(item)
+				}
+				continue
+			}
+			// port is not allocated
+			if transitPort == nil {
+				needToAllocate = append(needToAllocate, tunnelPort{item, port.Name})
+				markChanged(item)
+				continue
+			}
+			// out of the range or dedupe
+			if _, exists := portSet[*transitPort]; exists || !opts.Range.contains(*transitPort) {
+				needToAllocate = append(needToAllocate, tunnelPort{item, port.Name})
+				markChanged(item)
+				continue
+			}
+			portSet[*transitPort] = struct{}{}
+		}
+	}
+
+	if opts.Strategy == ktunnelsv1.TransitPortStrategyHash {
+		// probe in order of the key, so that the result does not depend on the order of the list
+		slices.SortStableFunc(needToAllocate, func(a, b tunnelPort) int {
+			return cmp.Compare(hashKeyOf(a.tunnel, a.name), hashKeyOf(b.tunnel, b.name))
+		})
+	}
+	for _, item := range needToAllocate {
+		var p *int32
+		switch opts.Strategy {
+		case ktunnelsv1.TransitPortStrategyHash:
+			p = allocateHashPort(portSet, opts.Range, hashKeyOf(item.tunnel, item.name))
+		default:
+			p = allocateRandomPort(portSet, opts.Range, randIntn)
+		}
+		item.tunnel.SetTransitPort(item.name, p)
+		if p == nil {
+			addError(item.tunnel, &PortPoolExhaustedError{PortName: item.name, Range: opts.Range})
+		}
 	}
 
-	for _, item := range needToReconcile {
-		p := allocateAvailablePort(portSet, randIntn)
-		item.Status.TransitPort = p
+	var reservedInRange int
+	for p := range reservedPorts {
+		if opts.Range.contains(p) {
+			reservedInRange++
+		}
 	}
-	return needToReconcile
+	result.Capacity = int32(max(opts.Range.size()-reservedInRange, 0))
+	for p := range portSet {
+		if _, reserved := reservedPorts[p]; !reserved && opts.Range.contains(p) {
+			result.Allocated++
+		}
+	}
+	return result
 }
 
-const (
-	minPort       = 10000
-	maxPort       = 30000
-	allocationTry = maxPort - minPort
-)
+// sortByCreation returns a copy of the tunnels sorted by the creation timestamp and namespace/name.
+func sortByCreation(tunnels []*ktunnelsv1.Tunnel) []*ktunnelsv1.Tunnel {
+	sorted := slices.Clone(tunnels)
+	slices.SortStableFunc(sorted, func(a, b *ktunnelsv1.Tunnel) int {
+		if c := a.CreationTimestamp.Compare(b.CreationTimestamp.Time); c != 0 {
+			return c
+		}
+		return cmp.Or(cmp.Compare(a.Namespace, b.Namespace), cmp.Compare(a.Name, b.Name))
+	})
+	return sorted
+}
+
+// pruneTransitPorts removes the transit ports which are no longer used.
+// It returns true if the tunnel is changed.
+func pruneTransitPorts(item *ktunnelsv1.Tunnel) bool {
+	if !item.IsMultiPort() {
+		if item.Status.TransitPorts == nil {
+			return false
+		}
+		item.Status.TransitPorts = nil
+		return true
+	}
 
-func allocateAvailablePort(portSet map[int32]struct{}, randIntn randIntnFunc) *int32 {
-	for i := 0; i < allocationTry; i++ {
-		p := int32(minPort + randIntn(maxPort-minPort+1))
+	var changed bool
+	if item.Status.TransitPort != nil {
+		item.Status.TransitPort = nil
+		changed = true
+	}
+	portNames := make(map[string]struct{})
+	for _, port := range item.Spec.Ports {
+		portNames[port.Name] = struct{}{}
+	}
+	for _, transitPort := range item.Status.TransitPorts {
+		if _, exists := portNames[transitPort.Name]; !exists {
+			item.SetTransitPort(transitPort.Name, nil)
+			changed = true
+		}
+	}
+	return changed
+}
+
+// releaseTransitPorts removes all transit ports of the tunnel.
+// It returns true if the tunnel is changed.
+func releaseTransitPorts(item *ktunnelsv1.Tunnel) bool {
+	if item.Status.TransitPort == nil && item.Status.TransitPorts == nil {
+		return false
+	}
+	item.Status.TransitPort = nil
+	item.Status.TransitPorts = nil
+	return true
+}
+
+// Range represents a range of transit ports, inclusive.
+type Range struct {
+	Min int32
+	Max int32
+}
+
+// DefaultRange is the range of transit ports if not given.
+var DefaultRange = Range{Min: 10000, Max: 30000}
+
+// ParseRange parses the range in the form of MIN-MAX.
+func ParseRange(s string) (Range, error) {
+	minString, maxString, ok := strings.Cut(s, "-")
+	if !ok {
+		return Range{}, fmt.Errorf("invalid port range %q: must be MIN-MAX", s)
+	}
+	minPort, err := strconv.ParseInt(minString, 10, 32)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	maxPort, err := strconv.ParseInt(maxString, 10, 32)
+	if err != nil {
+		return Range{}, fmt.Errorf("invalid port range %q: %w", s, err)
+	}
+	r := Range{Min: int32(minPort), Max: int32(maxPort)}
+	if r.Min < 1 || r.Max > 65535 || r.Min > r.Max {
+		return Range{}, fmt.Errorf("invalid port range %q: must be 1 <= MIN <= MAX <= 65535", s)
+	}
+	return r, nil
+}
+
+func (r Range) String() string {
+	return fmt.Sprintf("%d-%d", r.Min, r.Max)
+}
+
+func (r Range) size() int {
+	return int(r.Max-r.Min) + 1
+}
+
+func (r Range) contains(p int32) bool {
+	return r.Min <= p && p <= r.Max
+}
+
+func allocateRandomPort(portSet map[int32]struct{}, portRange Range, randIntn randIntnFunc) *int32 {
+	size := portRange.size()
+	for i := 0; i < size; i++ {
+		p := portRange.Min + int32(randIntn(size))
+		if _, exists := portSet[p]; !exists {
+			portSet[p] = struct{}{}
+			return &p
+		}
+	}
+	// fall back to scan, because random may miss the last few available ports
+	for p := portRange.Min; p <= portRange.Max; p++ {
+		if _, exists := portSet[p]; !exists {
+			portSet[p] = struct{}{}
+			return &p
+		}
+	}
+	// no available port
+	return nil
+}
+
+// hashKeyOf returns the key to compute the hash of a port.
+// The port name is appended only for multiple ports,
+// so that the single port form and the multi-port form do not share the key.
+func hashKeyOf(tunnel *ktunnelsv1.Tunnel, portName string) string {
+	if !tunnel.IsMultiPort() {
+		return fmt.Sprintf("%s/%s", tunnel.Namespace, tunnel.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", tunnel.Namespace, tunnel.Name, portName)
+}
+
+// allocateHashPort returns the port from the hash of the key.
+// If the port is already used, it probes the next port linearly.
+func allocateHashPort(portSet map[int32]struct{}, portRange Range, key string) *int32 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	size := portRange.size()
+	if size <= 0 {
+		return nil
+	}
+	start := int(h.Sum32() % uint32(size))
+	for i := 0; i < size; i++ {
+		p := portRange.Min + int32((start+i)%size)
 		if _, exists := portSet[p]; !exists {
 			portSet[p] = struct{}{}
 			return &p
@@ -1,23 +1,26 @@
 package transit
 
 import (
+	"errors"
 	"k8s.io/utils/ptr"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 func Test_allocatePort(t *testing.T) {
 	t.Run("nil is given", func(t *testing.T) {
-		g := AllocatePort(nil)
+		g := AllocatePort(nil, Options{}).Changed
 		if g != nil {
 			t.Errorf("AllocatePort wants nil but was %v", g)
 		}
 	})
 	t.Run("empty is given", func(t *testing.T) {
-		g := AllocatePort([]*ktunnelsv1.Tunnel{})
+		g := AllocatePort([]*ktunnelsv1.Tunnel{}, Options{}).Changed
 		if g != nil {
 			t.Errorf("AllocatePort wants nil but was %v", g)
 		}
@@ -32,10 +35,10 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar"},
 				},
 				Status: ktunnelsv1.TunnelStatus{
-					TransitPort: ptr.To[int32](200),
+					TransitPort: ptr.To[int32](10200),
 				},
 			},
-		})
+		}, Options{}).Changed
 		if g != nil {
 			t.Errorf("AllocatePort wants nil but was %v", g)
 		}
@@ -49,7 +52,7 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar1"},
 				},
 				Status: ktunnelsv1.TunnelStatus{
-					TransitPort: ptr.To[int32](1000),
+					TransitPort: ptr.To[int32](11000),
 				},
 			},
 			{
@@ -59,10 +62,10 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar2"},
 				},
 				Status: ktunnelsv1.TunnelStatus{
-					TransitPort: ptr.To[int32](2000),
+					TransitPort: ptr.To[int32](12000),
 				},
 			},
-		})
+		}, Options{}).Changed
 		if g != nil {
 			t.Errorf("AllocatePort wants nil but was %v", g)
 		}
@@ -85,10 +88,10 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar2"},
 				},
 				Status: ktunnelsv1.TunnelStatus{
-					TransitPort: ptr.To[int32](2000),
+					TransitPort: ptr.To[int32](12000),
 				},
 			},
-		}, mockIntn)
+		}, Options{}, mockIntn).Changed
 		w := []*ktunnelsv1.Tunnel{
 			{
 				Spec: ktunnelsv1.TunnelSpec{
@@ -116,7 +119,7 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar1"},
 				},
 				Status: ktunnelsv1.TunnelStatus{
-					TransitPort: ptr.To[int32](1000),
+					TransitPort: ptr.To[int32](11000),
 				},
 			},
 			{
@@ -126,7 +129,7 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar2"},
 				},
 			},
-		}, mockIntn)
+		}, Options{}, mockIntn).Changed
 		w := []*ktunnelsv1.Tunnel{
 			{
 				Spec: ktunnelsv1.TunnelSpec{
@@ -163,7 +166,7 @@ func Test_allocatePort(t *testing.T) {
 					Proxy: corev1.LocalObjectReference{Name: "bar2"},
 				},
 			},
-		}, mockIntn)
+		}, Options{}, mockIntn).Changed
 		w := []*ktunnelsv1.Tunnel{
 			{
 				Spec: ktunnelsv1.TunnelSpec{
@@ -190,4 +193,478 @@ func Test_allocatePort(t *testing.T) {
 			t.Errorf("AllocatePort want != got:\n%s", diff)
 		}
 	})
+	t.Run("allocate multiple ports", func(t *testing.T) {
+		mockIntnCounter := 12345
+		mockIntn := func(int) int { mockIntnCounter++; return mockIntnCounter }
+
+		g := allocatePort([]*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "foo1",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+						{Name: "management", Port: 15672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPorts: []ktunnelsv1.TunnelTransitPort{
+						{Name: "amqp", TransitPort: 11000},
+					},
+				},
+			},
+		}, Options{}, mockIntn).Changed
+		w := []*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "foo1",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+						{Name: "management", Port: 15672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPorts: []ktunnelsv1.TunnelTransitPort{
+						{Name: "amqp", TransitPort: 11000},
+						{Name: "management", TransitPort: 22346},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(w, g); diff != "" {
+			t.Errorf("AllocatePort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("prune removed ports", func(t *testing.T) {
+		mockIntn := func(int) int { return 12345 }
+		g := allocatePort([]*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "foo1",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPort: ptr.To[int32](12000),
+					TransitPorts: []ktunnelsv1.TunnelTransitPort{
+						{Name: "amqp", TransitPort: 11000},
+						{Name: "management", TransitPort: 11001},
+					},
+				},
+			},
+		}, Options{}, mockIntn).Changed
+		w := []*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host: "foo1",
+					Ports: []ktunnelsv1.TunnelPort{
+						{Name: "amqp", Port: 5672},
+					},
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPorts: []ktunnelsv1.TunnelTransitPort{
+						{Name: "amqp", TransitPort: 11000},
+					},
+				},
+			},
+		}
+		if diff := cmp.Diff(w, g); diff != "" {
+			t.Errorf("AllocatePort want != got:\n%s", diff)
+		}
+	})
+	t.Run("allocate in the range", func(t *testing.T) {
+		mockIntn := func(n int) int { return n - 1 }
+		tunnel := &ktunnelsv1.Tunnel{
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  "foo1",
+				Port:  100,
+				Proxy: corev1.LocalObjectReference{Name: "bar1"},
+			},
+		}
+		allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{Range: Range{Min: 40000, Max: 40099}}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](40099), tunnel.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("range is exhausted", func(t *testing.T) {
+		mockIntn := func(int) int { return 0 }
+		tunnel := &ktunnelsv1.Tunnel{
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  "foo1",
+				Port:  100,
+				Proxy: corev1.LocalObjectReference{Name: "bar1"},
+			},
+		}
+		g := allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{
+			Range:         Range{Min: 9901, Max: 9901},
+			ReservedPorts: []int32{9901},
+		}, mockIntn)
+		if tunnel.Status.TransitPort != nil {
+			t.Errorf("transitPort wants nil but was %d", *tunnel.Status.TransitPort)
+		}
+		if !errors.Is(g.Errors[tunnel], ErrPortPoolExhausted) {
+			t.Errorf("error wants ErrPortPoolExhausted but was %v", g.Errors[tunnel])
+		}
+		if g.Capacity != 0 {
+			t.Errorf("capacity wants 0 but was %d", g.Capacity)
+		}
+	})
+
+	t.Run("usage of the range", func(t *testing.T) {
+		mockIntn := func(int) int { return 0 }
+		tunnels := []*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "foo1",
+					Port:  100,
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPort: ptr.To[int32](9899),
+				},
+			},
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "foo2",
+					Port:  200,
+					Proxy: corev1.LocalObjectReference{Name: "bar2"},
+				},
+			},
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "foo3",
+					Port:  300,
+					Proxy: corev1.LocalObjectReference{Name: "bar3"},
+				},
+			},
+		}
+		g := allocatePort(tunnels, Options{
+			Range:         Range{Min: 9899, Max: 9901},
+			ReservedPorts: []int32{9901, 19901},
+		}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](9900), tunnels[1].Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+		if tunnels[2].Status.TransitPort != nil {
+			t.Errorf("transitPort wants nil but was %d", *tunnels[2].Status.TransitPort)
+		}
+		if len(g.Errors) != 1 || !errors.Is(g.Errors[tunnels[2]], ErrPortPoolExhausted) {
+			t.Errorf("errors wants ErrPortPoolExhausted of foo3 but was %v", g.Errors)
+		}
+		if g.Allocated != 2 || g.Capacity != 2 {
+			t.Errorf("allocated/capacity wants 2/2 but was %d/%d", g.Allocated, g.Capacity)
+		}
+	})
+
+	t.Run("reallocate a port out of the narrowed range", func(t *testing.T) {
+		mockIntn := func(int) int { return 0 }
+		tunnels := []*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "foo1",
+					Port:  100,
+					Proxy: corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					// allocated in the previous range 10000-30000
+					TransitPort: ptr.To[int32](25000),
+				},
+			},
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:  "foo2",
+					Port:  200,
+					Proxy: corev1.LocalObjectReference{Name: "bar2"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPort: ptr.To[int32](20001),
+				},
+			},
+		}
+		g := allocatePort(tunnels, Options{Range: Range{Min: 20000, Max: 20099}}, mockIntn)
+		if diff := cmp.Diff(tunnels[:1], g.Changed); diff != "" {
+			t.Errorf("changed want != got:\n%s", diff)
+		}
+		if diff := cmp.Diff(ptr.To[int32](20000), tunnels[0].Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+		if diff := cmp.Diff(ptr.To[int32](20001), tunnels[1].Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("reallocate a reserved port", func(t *testing.T) {
+		mockIntn := func(int) int { return 12345 }
+		tunnel := &ktunnelsv1.Tunnel{
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  "foo1",
+				Port:  100,
+				Proxy: corev1.LocalObjectReference{Name: "bar1"},
+			},
+			Status: ktunnelsv1.TunnelStatus{
+				TransitPort: ptr.To[int32](9901),
+			},
+		}
+		allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{ReservedPorts: []int32{9901}}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](22345), tunnel.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("release the port of TLS passthrough", func(t *testing.T) {
+		mockIntn := func(int) int { return 12345 }
+		tunnels := []*ktunnelsv1.Tunnel{
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:           "foo1",
+					Port:           443,
+					TLSPassthrough: true,
+					Proxy:          corev1.LocalObjectReference{Name: "bar1"},
+				},
+				Status: ktunnelsv1.TunnelStatus{
+					TransitPort: ptr.To[int32](1000),
+				},
+			},
+			{
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:           "foo2",
+					Port:           443,
+					TLSPassthrough: true,
+					Proxy:          corev1.LocalObjectReference{Name: "bar1"},
+				},
+			},
+		}
+		g := allocatePort(tunnels, Options{}, mockIntn)
+		if diff := cmp.Diff(tunnels[:1], g.Changed); diff != "" {
+			t.Errorf("changed want != got:\n%s", diff)
+		}
+		if tunnels[0].Status.TransitPort != nil {
+			t.Errorf("transitPort wants nil but was %d", *tunnels[0].Status.TransitPort)
+		}
+		if tunnels[1].Status.TransitPort != nil {
+			t.Errorf("transitPort wants nil but was %d", *tunnels[1].Status.TransitPort)
+		}
+		if g.Errors != nil || g.Allocated != 0 {
+			t.Errorf("errors/allocated wants nil/0 but was %v/%d", g.Errors, g.Allocated)
+		}
+	})
+}
+
+func Test_allocatePort_hash(t *testing.T) {
+	newTunnel := func(name string) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  name,
+				Port:  100,
+				Proxy: corev1.LocalObjectReference{Name: "bar"},
+			},
+		}
+	}
+	opts := Options{Strategy: ktunnelsv1.TransitPortStrategyHash}
+
+	t.Run("same port for recreated tunnel", func(t *testing.T) {
+		first := newTunnel("foo1")
+		AllocatePort([]*ktunnelsv1.Tunnel{first}, opts)
+		recreated := newTunnel("foo1")
+		AllocatePort([]*ktunnelsv1.Tunnel{recreated}, opts)
+		if diff := cmp.Diff(ptr.To[int32](17112), first.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+		if diff := cmp.Diff(first.Status.TransitPort, recreated.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort of recreated tunnel want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("linear probing", func(t *testing.T) {
+		opts := Options{Strategy: ktunnelsv1.TransitPortStrategyHash, Range: Range{Min: 20000, Max: 20009}}
+		allocated := newTunnel("foo2")
+		allocated.Status.TransitPort = ptr.To[int32](20002)
+		tunnel := newTunnel("foo1")
+		AllocatePort([]*ktunnelsv1.Tunnel{allocated, tunnel}, opts)
+		// the hash of foo1 points to 20002, which is already used
+		if diff := cmp.Diff(ptr.To[int32](20003), tunnel.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("wrap around the range", func(t *testing.T) {
+		opts := Options{Strategy: ktunnelsv1.TransitPortStrategyHash, Range: Range{Min: 20000, Max: 20009}}
+		allocated := newTunnel("foo1")
+		allocated.Status.TransitPort = ptr.To[int32](20009)
+		tunnel := newTunnel("foo2")
+		AllocatePort([]*ktunnelsv1.Tunnel{allocated, tunnel}, opts)
+		// the hash of foo2 points to 20009, which is already used
+		if diff := cmp.Diff(ptr.To[int32](20000), tunnel.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("same ports for recreated tunnels in any order", func(t *testing.T) {
+		// the hashes of these tunnels point to 20009
+		opts := Options{Strategy: ktunnelsv1.TransitPortStrategyHash, Range: Range{Min: 20000, Max: 20009}}
+		names := []string{"foo2", "foo8", "foo11"}
+		allocate := func(names []string) map[string]int32 {
+			var tunnels []*ktunnelsv1.Tunnel
+			for _, name := range names {
+				tunnels = append(tunnels, newTunnel(name))
+			}
+			AllocatePort(tunnels, opts)
+			ports := make(map[string]int32)
+			for _, tunnel := range tunnels {
+				ports[tunnel.Name] = *tunnel.Status.TransitPort
+			}
+			return ports
+		}
+		want := allocate(names)
+		got := allocate([]string{"foo11", "foo8", "foo2"})
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("transitPorts want != got:\n%s", diff)
+		}
+
+		// delete foo2 and foo8, and recreate them in the reverse order
+		remaining := newTunnel("foo11")
+		remaining.Status.TransitPort = ptr.To(want["foo11"])
+		recreated := []*ktunnelsv1.Tunnel{newTunnel("foo8"), newTunnel("foo2")}
+		AllocatePort(append([]*ktunnelsv1.Tunnel{remaining}, recreated...), opts)
+		for _, tunnel := range recreated {
+			if diff := cmp.Diff(ptr.To(want[tunnel.Name]), tunnel.Status.TransitPort); diff != "" {
+				t.Errorf("transitPort of %s want != got:\n%s", tunnel.Name, diff)
+			}
+		}
+	})
+
+	t.Run("multiple ports", func(t *testing.T) {
+		tunnel := newTunnel("broker")
+		tunnel.Spec.Port = 0
+		tunnel.Spec.Ports = []ktunnelsv1.TunnelPort{{Name: "amqp", Port: 5672}}
+		AllocatePort([]*ktunnelsv1.Tunnel{tunnel}, opts)
+		want := []ktunnelsv1.TunnelTransitPort{{Name: "amqp", TransitPort: 15671}}
+		if diff := cmp.Diff(want, tunnel.Status.TransitPorts); diff != "" {
+			t.Errorf("transitPorts want != got:\n%s", diff)
+		}
+	})
+}
+
+func Test_allocatePort_requested(t *testing.T) {
+	mockIntn := func(int) int { return 12345 }
+	newTunnel := func(name string, created time.Time, requested *int32) *ktunnelsv1.Tunnel {
+		return &ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{Namespace: "default", Name: name, CreationTimestamp: metav1.NewTime(created)},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:        name,
+				Port:        100,
+				TransitPort: requested,
+				Proxy:       corev1.LocalObjectReference{Name: "bar"},
+			},
+		}
+	}
+	now := time.Now()
+
+	t.Run("requested port is allocated", func(t *testing.T) {
+		tunnel := newTunnel("foo1", now, ptr.To[int32](20000))
+		g := allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](20000), tunnel.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort want != got:\n%s", diff)
+		}
+		if len(g.Changed) != 1 || g.Errors != nil {
+			t.Errorf("changed wants 1 and errors wants nil but was %v, %v", g.Changed, g.Errors)
+		}
+	})
+
+	t.Run("requested port takes precedence over an allocated port", func(t *testing.T) {
+		allocated := newTunnel("foo1", now, nil)
+		allocated.Status.TransitPort = ptr.To[int32](20000)
+		requested := newTunnel("foo2", now.Add(time.Minute), ptr.To[int32](20000))
+		allocatePort([]*ktunnelsv1.Tunnel{allocated, requested}, Options{}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](20000), requested.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort of requested want != got:\n%s", diff)
+		}
+		if diff := cmp.Diff(ptr.To[int32](22345), allocated.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort of allocated want != got:\n%s", diff)
+		}
+	})
+
+	t.Run("older tunnel wins the conflict", func(t *testing.T) {
+		newer := newTunnel("foo1", now.Add(time.Minute), ptr.To[int32](20000))
+		newer.Status.TransitPort = ptr.To[int32](20000)
+		older := newTunnel("foo2", now, ptr.To[int32](20000))
+		g := allocatePort([]*ktunnelsv1.Tunnel{newer, older}, Options{}, mockIntn)
+		if diff := cmp.Diff(ptr.To[int32](20000), older.Status.TransitPort); diff != "" {
+			t.Errorf("transitPort of older want != got:\n%s", diff)
+		}
+		if newer.Status.TransitPort != nil {
+			t.Errorf("transitPort of newer wants nil but was %d", *newer.Status.TransitPort)
+		}
+		var conflictErr *TransitPortConflictError
+		if !errors.As(g.Errors[newer], &conflictErr) {
+			t.Fatalf("error wants TransitPortConflictError but was %v", g.Errors[newer])
+		}
+		if conflictErr.Tunnel != "default/foo2" {
+			t.Errorf("tunnel of conflict wants default/foo2 but was %s", conflictErr.Tunnel)
+		}
+	})
+
+	t.Run("requested port is out of the range", func(t *testing.T) {
+		tunnel := newTunnel("foo1", now, ptr.To[int32](5000))
+		g := allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{}, mockIntn)
+		if tunnel.Status.TransitPort != nil {
+			t.Errorf("transitPort wants nil but was %d", *tunnel.Status.TransitPort)
+		}
+		if !errors.Is(g.Errors[tunnel], ErrTransitPortOutOfRange) {
+			t.Errorf("error wants ErrTransitPortOutOfRange but was %v", g.Errors[tunnel])
+		}
+	})
+
+	t.Run("requested port is reserved", func(t *testing.T) {
+		tunnel := newTunnel("foo1", now, ptr.To[int32](9901))
+		g := allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{
+			Range:         Range{Min: 9000, Max: 9999},
+			ReservedPorts: []int32{9901},
+		}, mockIntn)
+		if !errors.Is(g.Errors[tunnel], ErrTransitPortOutOfRange) {
+			t.Errorf("error wants ErrTransitPortOutOfRange but was %v", g.Errors[tunnel])
+		}
+	})
+
+	t.Run("multiple ports", func(t *testing.T) {
+		tunnel := newTunnel("broker", now, nil)
+		tunnel.Spec.Port = 0
+		tunnel.Spec.Ports = []ktunnelsv1.TunnelPort{
+			{Name: "amqp", Port: 5672, TransitPort: ptr.To[int32](25672)},
+			{Name: "management", Port: 15672},
+		}
+		allocatePort([]*ktunnelsv1.Tunnel{tunnel}, Options{}, mockIntn)
+		want := []ktunnelsv1.TunnelTransitPort{
+			{Name: "amqp", TransitPort: 25672},
+			{Name: "management", TransitPort: 22345},
+		}
+		if diff := cmp.Diff(want, tunnel.Status.TransitPorts); diff != "" {
+			t.Errorf("transitPorts want != got:\n%s", diff)
+		}
+	})
+}
+
+func TestParseRange(t *testing.T) {
+	got, err := ParseRange("20000-20999")
+	if err != nil {
+		t.Fatalf("ParseRange: %s", err)
+	}
+	if diff := cmp.Diff(Range{Min: 20000, Max: 20999}, got); diff != "" {
+		t.Errorf("range want != got:\n%s", diff)
+	}
+
+	for _, s := range []string{"", "20000", "20000-", "0-100", "20999-20000", "20000-70000", "20000-20999abc", " 20000-20999", "20000-20999-21999"} {
+		t.Run(s, func(t *testing.T) {
+			if _, err := ParseRange(s); err == nil {
+				t.Errorf("ParseRange(%q) wants error but got nil", s)
+			}
+		})
+	}
 }
@@ -0,0 +1,142 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"github.com/int128/ktunnels/internal/envoy"
+)
+
+// log is for logging in this package.
+var proxylog = logf.Log.WithName("proxy-resource")
+
+// maxReplicas is the number of replicas which is considered to be a mistake.
+const maxReplicas = 10
+
+// SetupProxyWebhookWithManager registers the webhook for Proxy in the manager.
+func SetupProxyWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr, &ktunnelsv1.Proxy{}).
+		WithValidator(&ProxyCustomValidator{}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/validate-ktunnels-int128-github-io-v1-proxy,mutating=false,failurePolicy=fail,sideEffects=None,groups=ktunnels.int128.github.io,resources=proxies,verbs=create;update,versions=v1,name=vproxy-v1.kb.io,admissionReviewVersions=v1
+
+// ProxyCustomValidator struct is responsible for validating the Proxy resource
+// when it is created, updated, or deleted.
+type ProxyCustomValidator struct{}
+
+// ValidateCreate implements admission.Validator so a webhook will be registered for the type Proxy.
+func (v *ProxyCustomValidator) ValidateCreate(_ context.Context, proxy *ktunnelsv1.Proxy) (admission.Warnings, error) {
+	proxylog.Info("Validation for Proxy upon creation", "name", proxy.GetName())
+	return validateProxy(proxy)
+}
+
+// ValidateUpdate implements admission.Validator so a webhook will be registered for the type Proxy.
+func (v *ProxyCustomValidator) ValidateUpdate(_ context.Context, _, newProxy *ktunnelsv1.Proxy) (admission.Warnings, error) {
+	proxylog.Info("Validation for Proxy upon update", "name", newProxy.GetName())
+	return validateProxy(newProxy)
+}
+
+// ValidateDelete implements admission.Validator so a webhook will be registered for the type Proxy.
+func (v *ProxyCustomValidator) ValidateDelete(_ context.Context, _ *ktunnelsv1.Proxy) (admission.Warnings, error) {
+	return nil, nil
+}
+
+func validateProxy(proxy *ktunnelsv1.Proxy) (admission.Warnings, error) {
+	var warnings admission.Warnings
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if replicas := proxy.Spec.Replicas; replicas != nil {
+		switch {
+		case *replicas < 0:
+			errs = append(errs, field.Invalid(specPath.Child("replicas"), *replicas, "must be greater than or equal to 0"))
+		case *replicas == 0:
+			warnings = append(warnings, "replicas is 0, all tunnels of the proxy will be unavailable")
+		case *replicas > maxReplicas:
+			warnings = append(warnings, fmt.Sprintf("replicas is %d, a proxy usually does not need more than %d replicas", *replicas, maxReplicas))
+		}
+	}
+
+	envoyPath := specPath.Child("template", "spec", "envoy")
+	if image := proxy.Spec.Template.Spec.Envoy.Image; image != nil && *image == "" {
+		errs = append(errs, field.Invalid(envoyPath.Child("image"), *image, "must not be empty"))
+	}
+	if resources := proxy.Spec.Template.Spec.Envoy.Resources; resources != nil {
+		errs = append(errs, validateResources(envoyPath.Child("resources"), resources)...)
+	}
+
+	errs = append(errs, validateSourceRanges(specPath.Child("allowedSourceRanges"), proxy.Spec.AllowedSourceRanges)...)
+	errs = append(errs, validateAccessLog(specPath.Child("accessLog"), proxy.Spec.AccessLog)...)
+	errs = append(errs, validateTimeouts(specPath.Child("timeouts"), proxy.Spec.Timeouts)...)
+	errs = append(errs, validateKeepalive(specPath.Child("keepalive"), proxy.Spec.Keepalive)...)
+	errs = append(errs, validateDNS(specPath.Child("dns"), proxy.Spec.DNS)...)
+	for i, resolver := range proxy.Spec.DNSResolvers {
+		if _, err := envoy.ParseDNSResolver(resolver); err != nil {
+			errs = append(errs, field.Invalid(specPath.Child("dnsResolvers").Index(i), resolver, "must be an IP address or IP:port"))
+		}
+	}
+
+	transitPort := proxy.Spec.TransitPort
+	if minPort, maxPort := ptr.Deref(transitPort.Min, 0), ptr.Deref(transitPort.Max, 0); minPort > 0 && maxPort > 0 {
+		for _, p := range envoy.ReservedPorts() {
+			if minPort <= p && p <= maxPort {
+				warnings = append(warnings, fmt.Sprintf("transit port range contains the reserved port %d, which is never allocated", p))
+			}
+		}
+	}
+
+	if tlsPassthroughPort := proxy.Spec.TLSPassthrough.Port; tlsPassthroughPort != nil {
+		if slices.Contains(envoy.ReservedPorts(), *tlsPassthroughPort) {
+			errs = append(errs, field.Invalid(specPath.Child("tlsPassthrough", "port"), *tlsPassthroughPort, "must not be a reserved port"))
+		}
+	}
+
+	if len(errs) > 0 {
+		return warnings, apierrors.NewInvalid(ktunnelsv1.GroupVersion.WithKind("Proxy").GroupKind(), proxy.Name, errs)
+	}
+	return warnings, nil
+}
+
+// validateResources validates each request does not exceed the limit.
+func validateResources(fldPath *field.Path, resources *corev1.ResourceRequirements) field.ErrorList {
+	var errs field.ErrorList
+	for name, request := range resources.Requests {
+		limit, ok := resources.Limits[name]
+		if !ok {
+			continue
+		}
+		if request.Cmp(limit) > 0 {
+			errs = append(errs, field.Invalid(fldPath.Child("requests").Key(string(name)), request.String(),
+				fmt.Sprintf("must be less than or equal to the limit %s", limit.String())))
+		}
+	}
+	return errs
+}
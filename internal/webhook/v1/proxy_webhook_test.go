@@ -0,0 +1,107 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+)
+
+var _ = Describe("Proxy Webhook", func() {
+	var validator ProxyCustomValidator
+	var proxy ktunnelsv1.Proxy
+	BeforeEach(func() {
+		validator = ProxyCustomValidator{}
+		proxy = ktunnelsv1.Proxy{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "example-",
+				Namespace:    "default",
+			},
+		}
+	})
+
+	Context("When creating a Proxy", func() {
+		It("Should admit a valid proxy", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &proxy)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny negative replicas", func(ctx context.Context) {
+			proxy.Spec.Replicas = ptr.To[int32](-1)
+			_, err := validator.ValidateCreate(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.replicas")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should warn zero replicas", func(ctx context.Context) {
+			proxy.Spec.Replicas = ptr.To[int32](0)
+			warnings, err := validator.ValidateCreate(ctx, &proxy)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(ConsistOf(ContainSubstring("replicas is 0")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a request exceeding the limit", func(ctx context.Context) {
+			proxy.Spec.Template.Spec.Envoy.Resources = &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("6Gi")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("3Gi")},
+			}
+			err := k8sClient.Create(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.template.spec.envoy.resources.requests[memory]")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should warn the range containing a reserved port", func(ctx context.Context) {
+			proxy.Spec.TransitPort.Min = ptr.To[int32](9000)
+			proxy.Spec.TransitPort.Max = ptr.To[int32](9999)
+			warnings, err := validator.ValidateCreate(ctx, &proxy)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(ConsistOf(ContainSubstring("reserved port 9901")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an invalid source range", func(ctx context.Context) {
+			proxy.Spec.AllowedSourceRanges = []string{"10.0.0.0/8", "192.168.0.1"}
+			err := k8sClient.Create(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.allowedSourceRanges[1]")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an access log collector without port", func(ctx context.Context) {
+			proxy.Spec.AccessLog = &ktunnelsv1.AccessLog{
+				Collector: &ktunnelsv1.AccessLogCollector{Address: "otel-collector.monitoring.svc"},
+			}
+			err := k8sClient.Create(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.accessLog.collector.address")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a DNS resolver of hostname", func(ctx context.Context) {
+			proxy.Spec.DNSResolvers = []string{"10.0.0.2", "dns.corp.example.com:53"}
+			err := k8sClient.Create(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.dnsResolvers[1]")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny the TLS passthrough on the admin port", func(ctx context.Context) {
+			proxy.Spec.TLSPassthrough = ktunnelsv1.ProxyTLSPassthrough{Enabled: true, Port: ptr.To[int32](9901)}
+			err := k8sClient.Create(ctx, &proxy)
+			Expect(err).Should(MatchError(ContainSubstring("spec.tlsPassthrough.port")))
+		}, SpecTimeout(3*time.Second))
+	})
+})
@@ -0,0 +1,510 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/utils/ptr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+	"github.com/int128/ktunnels/internal/defaultproxy"
+	"github.com/int128/ktunnels/internal/envoy"
+	"github.com/int128/ktunnels/internal/policy"
+)
+
+// log is for logging in this package.
+var tunnellog = logf.Log.WithName("tunnel-resource")
+
+// SetupTunnelWebhookWithManager registers the webhook for Tunnel in the manager.
+// If defaultProxyName is set, it is used when neither the tunnel nor the namespace specifies the proxy.
+func SetupTunnelWebhookWithManager(mgr ctrl.Manager, defaultProxyName string) error {
+	return ctrl.NewWebhookManagedBy(mgr, &ktunnelsv1.Tunnel{}).
+		WithValidator(&TunnelCustomValidator{Client: mgr.GetClient(), APIReader: mgr.GetAPIReader(), Resolver: net.DefaultResolver}).
+		WithDefaulter(&TunnelCustomDefaulter{Client: mgr.GetAPIReader(), DefaultProxyName: defaultProxyName}).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-ktunnels-int128-github-io-v1-tunnel,mutating=true,failurePolicy=fail,sideEffects=None,groups=ktunnels.int128.github.io,resources=tunnels,verbs=create,versions=v1,name=mtunnel-v1.kb.io,admissionReviewVersions=v1
+
+// TunnelCustomDefaulter struct is responsible for setting default values on the Tunnel resource
+// when it is created.
+type TunnelCustomDefaulter struct {
+	// Client reads the Namespace of a tunnel.
+	// The controller does not cache Namespaces.
+	Client client.Reader
+
+	// DefaultProxyName is used if the namespace does not have the annotation.
+	DefaultProxyName string
+}
+
+// Default implements admission.Defaulter so a webhook will be registered for the type Tunnel.
+func (d *TunnelCustomDefaulter) Default(ctx context.Context, tunnel *ktunnelsv1.Tunnel) error {
+	tunnellog.Info("Defaulting for Tunnel", "name", tunnel.GetName())
+
+	if tunnel.Spec.Proxy.Name != "" {
+		return nil
+	}
+	name, err := defaultproxy.NameOf(ctx, d.Client, tunnel.Namespace, d.DefaultProxyName)
+	if err != nil {
+		return err
+	}
+	tunnel.Spec.Proxy.Name = name
+	return nil
+}
+
+// +kubebuilder:webhook:path=/validate-ktunnels-int128-github-io-v1-tunnel,mutating=false,failurePolicy=fail,sideEffects=None,groups=ktunnels.int128.github.io,resources=tunnels,verbs=create;update,versions=v1,name=vtunnel-v1.kb.io,admissionReviewVersions=v1
+
+// TunnelCustomValidator struct is responsible for validating the Tunnel resource
+// when it is created, updated, or deleted.
+type TunnelCustomValidator struct {
+	// Client reads the Proxy referenced by a tunnel and the TunnelPolicies.
+	Client client.Reader
+
+	// APIReader reads the Namespace of a tunnel.
+	// The controller does not cache Namespaces.
+	APIReader client.Reader
+
+	// Resolver resolves the hostname of a tunnel to check the CIDRs of the tunnel policies.
+	Resolver policy.Resolver
+}
+
+// ValidateCreate implements admission.Validator so a webhook will be registered for the type Tunnel.
+func (v *TunnelCustomValidator) ValidateCreate(ctx context.Context, tunnel *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	tunnellog.Info("Validation for Tunnel upon creation", "name", tunnel.GetName())
+
+	if errs := validateTunnelSpec(tunnel); len(errs) > 0 {
+		return nil, apierrors.NewInvalid(ktunnelsv1.GroupVersion.WithKind("Tunnel").GroupKind(), tunnel.Name, errs)
+	}
+	if err := v.checkTunnelPolicies(ctx, tunnel); err != nil {
+		return nil, err
+	}
+	return v.tunnelWarnings(ctx, tunnel)
+}
+
+// ValidateUpdate implements admission.Validator so a webhook will be registered for the type Tunnel.
+// The fields which re-key the state of the tunnel are immutable:
+// spec.proxy.name, spec.tlsPassthrough, and the form of spec.port or spec.ports,
+// because they change the transit ports and the names of the Service ports.
+// spec.proxy.name can be set once if it is empty, because the controller sets the default proxy.
+// The entries of spec.ports can be changed.
+func (v *TunnelCustomValidator) ValidateUpdate(ctx context.Context, oldTunnel, newTunnel *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	tunnellog.Info("Validation for Tunnel upon update", "name", newTunnel.GetName())
+
+	errs := validateTunnelSpec(newTunnel)
+	if oldTunnel.Spec.Proxy.Name != "" && oldTunnel.Spec.Proxy.Name != newTunnel.Spec.Proxy.Name {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "proxy", "name"),
+			"proxy is immutable, recreate the tunnel to move it to another proxy"))
+	}
+	if oldTunnel.Spec.TLSPassthrough != newTunnel.Spec.TLSPassthrough {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "tlsPassthrough"),
+			"tlsPassthrough is immutable, recreate the tunnel to change it"))
+	}
+	if oldTunnel.IsMultiPort() != newTunnel.IsMultiPort() {
+		errs = append(errs, field.Forbidden(field.NewPath("spec", "ports"),
+			"cannot switch between port and ports, recreate the tunnel to change it"))
+	}
+	if len(errs) > 0 {
+		return nil, apierrors.NewInvalid(ktunnelsv1.GroupVersion.WithKind("Tunnel").GroupKind(), newTunnel.Name, errs)
+	}
+	// check the policies on every update, because a policy or label of the namespace may be changed
+	if err := v.checkTunnelPolicies(ctx, newTunnel); err != nil {
+		return nil, err
+	}
+	return v.tunnelWarnings(ctx, newTunnel)
+}
+
+// ValidateDelete implements admission.Validator so a webhook will be registered for the type Tunnel.
+func (v *TunnelCustomValidator) ValidateDelete(_ context.Context, _ *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	return nil, nil
+}
+
+// checkTunnelPolicies returns a Forbidden error if the destination is denied by the tunnel policies.
+func (v *TunnelCustomValidator) checkTunnelPolicies(ctx context.Context, tunnel *ktunnelsv1.Tunnel) error {
+	var policyList ktunnelsv1.TunnelPolicyList
+	if err := v.Client.List(ctx, &policyList); err != nil {
+		return fmt.Errorf("unable to list the tunnel policies: %w", err)
+	}
+	if len(policyList.Items) == 0 {
+		return nil
+	}
+	var namespace corev1.Namespace
+	if err := v.APIReader.Get(ctx, types.NamespacedName{Name: tunnel.Namespace}, &namespace); err != nil {
+		return fmt.Errorf("unable to get the namespace: %w", err)
+	}
+	if err := policy.Check(ctx, v.Resolver, policyList.Items, namespace, tunnel); err != nil {
+		return apierrors.NewForbidden(ktunnelsv1.GroupVersion.WithResource("tunnels").GroupResource(), tunnel.Name, err)
+	}
+	return nil
+}
+
+// tunnelWarnings returns the warnings of the proxy and the server names of the tunnel.
+func (v *TunnelCustomValidator) tunnelWarnings(ctx context.Context, tunnel *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	proxyWarnings, err := v.proxyWarnings(ctx, tunnel)
+	if err != nil {
+		return nil, err
+	}
+	serverNameWarnings, err := v.serverNameWarnings(ctx, tunnel)
+	if err != nil {
+		return nil, err
+	}
+	return append(proxyWarnings, serverNameWarnings...), nil
+}
+
+// serverNameWarnings returns a warning if the TLS passthrough tunnel conflicts with another tunnel
+// of the same proxy by the server name.
+// The proxy routes the former in order of name, so this is not an error.
+func (v *TunnelCustomValidator) serverNameWarnings(ctx context.Context, tunnel *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	if !tunnel.Spec.TLSPassthrough {
+		return nil, nil
+	}
+	var tunnelList ktunnelsv1.TunnelList
+	if err := v.Client.List(ctx, &tunnelList, client.InNamespace(tunnel.Namespace)); err != nil {
+		return nil, fmt.Errorf("unable to list the tunnels: %w", err)
+	}
+	var otherTunnels []*ktunnelsv1.Tunnel
+	for i := range tunnelList.Items {
+		other := &tunnelList.Items[i]
+		if other.Name != tunnel.Name && other.Spec.Proxy.Name == tunnel.Spec.Proxy.Name {
+			otherTunnels = append(otherTunnels, other)
+		}
+	}
+	previousConflicts := envoy.TLSPassthroughConflictsOf(otherTunnels)
+	conflicts := envoy.TLSPassthroughConflictsOf(append(otherTunnels, tunnel))
+	var warnings admission.Warnings
+	if err := conflicts[tunnel]; err != nil {
+		warnings = append(warnings, fmt.Sprintf("the tunnel will not be routed by proxy %s: %s", tunnel.Spec.Proxy.Name, err))
+	}
+	for _, other := range otherTunnels {
+		if err := conflicts[other]; err != nil && previousConflicts[other] == nil {
+			warnings = append(warnings, fmt.Sprintf("tunnel %s will not be routed by proxy %s: %s", other.Name, tunnel.Spec.Proxy.Name, err))
+		}
+	}
+	return warnings, nil
+}
+
+// proxyWarnings returns a warning if the proxy does not exist,
+// or the image of the proxy does not support the protocol of the tunnel.
+// A tunnel can be created before the proxy, so this is not an error.
+func (v *TunnelCustomValidator) proxyWarnings(ctx context.Context, tunnel *ktunnelsv1.Tunnel) (admission.Warnings, error) {
+	proxyKey := types.NamespacedName{Namespace: tunnel.Namespace, Name: tunnel.Spec.Proxy.Name}
+	var proxy ktunnelsv1.Proxy
+	if err := v.Client.Get(ctx, proxyKey, &proxy); err != nil {
+		if apierrors.IsNotFound(err) {
+			return admission.Warnings{
+				fmt.Sprintf("proxy %s is not found in the namespace %s, the tunnel will not be ready until it is created",
+					proxyKey.Name, proxyKey.Namespace),
+			}, nil
+		}
+		return nil, fmt.Errorf("unable to get the proxy: %w", err)
+	}
+	switch tunnel.Spec.Protocol {
+	case ktunnelsv1.TunnelProtocolPostgreSQL, ktunnelsv1.TunnelProtocolMySQL:
+		if image := ptr.Deref(proxy.Spec.Template.Spec.Envoy.Image, envoy.DefaultImage); !strings.Contains(image, "envoy-contrib") {
+			return admission.Warnings{
+				fmt.Sprintf("protocol %s requires the contrib image of Envoy such as envoyproxy/envoy-contrib, but proxy %s uses %s",
+					tunnel.Spec.Protocol, proxyKey.Name, image),
+			}, nil
+		}
+	case ktunnelsv1.TunnelProtocolRedis:
+		timeouts := ptr.Deref(proxy.Spec.Timeouts, ktunnelsv1.TunnelTimeouts{})
+		if timeouts.Idle != nil || timeouts.MaxConnectionDuration != nil || proxy.Spec.Keepalive != nil {
+			return admission.Warnings{
+				fmt.Sprintf("protocol Redis does not support the idle timeout, max connection duration and keepalive, "+
+					"so the defaults of proxy %s are not applied", proxyKey.Name),
+			}, nil
+		}
+	}
+	return nil, nil
+}
+
+func validateTunnelSpec(tunnel *ktunnelsv1.Tunnel) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	if len(tunnel.Spec.Destinations) == 0 {
+		errs = append(errs, validateHost(specPath.Child("host"), tunnel.Spec.Host)...)
+	}
+	errs = append(errs, validateDestinations(specPath, tunnel)...)
+
+	if !tunnel.IsMultiPort() {
+		for _, msg := range validation.IsValidPortNum(int(tunnel.Spec.Port)) {
+			errs = append(errs, field.Invalid(specPath.Child("port"), tunnel.Spec.Port, msg))
+		}
+	}
+	for i, port := range tunnel.Spec.Ports {
+		for _, msg := range validation.IsValidPortNum(int(port.Port)) {
+			errs = append(errs, field.Invalid(specPath.Child("ports").Index(i).Child("port"), port.Port, msg))
+		}
+	}
+
+	errs = append(errs, validateSourceRanges(specPath.Child("allowedSourceRanges"), tunnel.Spec.AllowedSourceRanges)...)
+	errs = append(errs, validateAccessLog(specPath.Child("accessLog"), tunnel.Spec.AccessLog)...)
+	errs = append(errs, validateTimeouts(specPath.Child("timeouts"), tunnel.Spec.Timeouts)...)
+	errs = append(errs, validateKeepalive(specPath.Child("keepalive"), tunnel.Spec.Keepalive)...)
+	errs = append(errs, validateDNS(specPath.Child("dns"), tunnel.Spec.DNS)...)
+	errs = append(errs, validateHealthCheck(specPath.Child("healthCheck"), tunnel.Spec.HealthCheck)...)
+	errs = append(errs, validateHTTP(specPath, tunnel)...)
+	errs = append(errs, validateRedis(specPath, tunnel)...)
+	errs = append(errs, validateTLSPassthrough(specPath, tunnel)...)
+
+	if tunnel.Spec.TTL != nil && tunnel.Spec.TTL.Duration <= 0 {
+		errs = append(errs, field.Invalid(specPath.Child("ttl"), tunnel.Spec.TTL.Duration.String(), "ttl must be positive"))
+	}
+
+	if tunnel.Spec.Proxy.Name == "" {
+		errs = append(errs, field.Required(specPath.Child("proxy", "name"),
+			fmt.Sprintf("proxy must be set, or annotate the namespace with %s", ktunnelsv1.AnnotationKeyDefaultProxy)))
+	}
+	return errs
+}
+
+// validateHost validates the host is a DNS name or IP address.
+func validateHost(fldPath *field.Path, host string) field.ErrorList {
+	if host == "" {
+		return field.ErrorList{field.Required(fldPath, "host must be set")}
+	}
+	if net.ParseIP(host) != nil {
+		return nil
+	}
+	var errs field.ErrorList
+	// DNS names are case-insensitive
+	for _, msg := range validation.IsDNS1123Subdomain(strings.ToLower(host)) {
+		errs = append(errs, field.Invalid(fldPath, host, msg))
+	}
+	return errs
+}
+
+// validateDestinations validates the host of each destination.
+// If the destinations have different hostnames, the server name of TLS must be set,
+// because a single server name is verified for all destinations.
+func validateDestinations(specPath *field.Path, tunnel *ktunnelsv1.Tunnel) field.ErrorList {
+	var errs field.ErrorList
+	hosts := make(map[string]bool)
+	for i, destination := range tunnel.Spec.Destinations {
+		errs = append(errs, validateHost(specPath.Child("destinations").Index(i).Child("host"), destination.Host)...)
+		if net.ParseIP(destination.Host) == nil {
+			hosts[strings.ToLower(destination.Host)] = true
+		}
+	}
+	if tunnel.Spec.TLS != nil && tunnel.Spec.TLS.ServerName == "" && len(hosts) > 1 {
+		errs = append(errs, field.Required(specPath.Child("tls", "serverName"),
+			"serverName must be set if the destinations have different hostnames"))
+	}
+	return errs
+}
+
+// validateSourceRanges validates each source range is a CIDR.
+func validateSourceRanges(fldPath *field.Path, sourceRanges []string) field.ErrorList {
+	var errs field.ErrorList
+	for i, sourceRange := range sourceRanges {
+		if _, err := netip.ParsePrefix(sourceRange); err != nil {
+			errs = append(errs, field.Invalid(fldPath.Index(i), sourceRange, "must be a CIDR such as 127.0.0.1/32"))
+		}
+	}
+	return errs
+}
+
+// validateAccessLog validates the address of the collector is host:port.
+func validateAccessLog(fldPath *field.Path, accessLog *ktunnelsv1.AccessLog) field.ErrorList {
+	if accessLog == nil || accessLog.Collector == nil {
+		return nil
+	}
+	addressPath := fldPath.Child("collector", "address")
+	address := accessLog.Collector.Address
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return field.ErrorList{field.Invalid(addressPath, address, "must be host:port")}
+	}
+	errs := validateHost(addressPath, host)
+	portNum, err := strconv.Atoi(port)
+	if err != nil {
+		return append(errs, field.Invalid(addressPath, address, "port must be a number"))
+	}
+	for _, msg := range validation.IsValidPortNum(portNum) {
+		errs = append(errs, field.Invalid(addressPath, address, msg))
+	}
+	return errs
+}
+
+// validateTimeouts validates the timeouts are positive, except the idle timeout can be 0 to disable it.
+func validateTimeouts(fldPath *field.Path, timeouts *ktunnelsv1.TunnelTimeouts) field.ErrorList {
+	if timeouts == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if d := timeouts.Connect; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("connect"), d.Duration.String(), "must be positive"))
+	}
+	if d := timeouts.Idle; d != nil && d.Duration < 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("idle"), d.Duration.String(), "must not be negative"))
+	}
+	if d := timeouts.MaxConnectionDuration; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("maxConnectionDuration"), d.Duration.String(), "must be positive"))
+	}
+	return errs
+}
+
+// validateKeepalive validates the durations are 1s or longer, because TCP keepalive is configured in seconds.
+func validateKeepalive(fldPath *field.Path, keepalive *ktunnelsv1.TunnelKeepalive) field.ErrorList {
+	if keepalive == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if d := keepalive.Time; d != nil && d.Duration < time.Second {
+		errs = append(errs, field.Invalid(fldPath.Child("time"), d.Duration.String(), "must be 1s or longer"))
+	}
+	if d := keepalive.Interval; d != nil && d.Duration < time.Second {
+		errs = append(errs, field.Invalid(fldPath.Child("interval"), d.Duration.String(), "must be 1s or longer"))
+	}
+	return errs
+}
+
+// validateDNS validates the refresh rate is longer than 1ms, as required by Envoy.
+func validateDNS(fldPath *field.Path, dns *ktunnelsv1.TunnelDNS) field.ErrorList {
+	if dns == nil {
+		return nil
+	}
+	if d := dns.RefreshRate; d != nil && d.Duration <= time.Millisecond {
+		return field.ErrorList{field.Invalid(fldPath.Child("refreshRate"), d.Duration.String(), "must be longer than 1ms")}
+	}
+	return nil
+}
+
+// validateHealthCheck validates the durations are positive and the path is absolute.
+func validateHealthCheck(fldPath *field.Path, healthCheck *ktunnelsv1.TunnelHealthCheck) field.ErrorList {
+	if healthCheck == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	if d := healthCheck.Interval; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("interval"), d.Duration.String(), "must be positive"))
+	}
+	if d := healthCheck.Timeout; d != nil && d.Duration <= 0 {
+		errs = append(errs, field.Invalid(fldPath.Child("timeout"), d.Duration.String(), "must be positive"))
+	}
+	if p := healthCheck.HTTPPath; p != "" && !strings.HasPrefix(p, "/") {
+		errs = append(errs, field.Invalid(fldPath.Child("httpPath"), p, "must start with /"))
+	}
+	return errs
+}
+
+// validateTLSPassthrough validates the TLS passthrough tunnel forwards the TLS connection as-is.
+// A destination must be a hostname, because the proxy routes a connection by the server name.
+func validateTLSPassthrough(specPath *field.Path, tunnel *ktunnelsv1.Tunnel) field.ErrorList {
+	if !tunnel.Spec.TLSPassthrough {
+		return nil
+	}
+	var errs field.ErrorList
+	if tunnel.Spec.TLS != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("tls"), "tls cannot be set with tlsPassthrough"))
+	}
+	if tunnel.Spec.HTTP != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("http"), "http cannot be set with tlsPassthrough"))
+	}
+	switch tunnel.Spec.Protocol {
+	case "", ktunnelsv1.TunnelProtocolTCP:
+	default:
+		errs = append(errs, field.Forbidden(specPath.Child("protocol"), "protocol cannot be set with tlsPassthrough"))
+	}
+	if tunnel.IsMultiPort() {
+		errs = append(errs, field.Forbidden(specPath.Child("ports"), "ports cannot be set with tlsPassthrough"))
+	}
+	if tunnel.Spec.TransitPort != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("transitPort"), "transitPort cannot be set with tlsPassthrough"))
+	}
+	if len(tunnel.Spec.Destinations) == 0 {
+		if net.ParseIP(tunnel.Spec.Host) != nil {
+			errs = append(errs, field.Invalid(specPath.Child("host"), tunnel.Spec.Host, "must be a hostname with tlsPassthrough"))
+		}
+	}
+	for i, destination := range tunnel.Spec.Destinations {
+		if net.ParseIP(destination.Host) != nil {
+			errs = append(errs, field.Invalid(specPath.Child("destinations").Index(i).Child("host"), destination.Host,
+				"must be a hostname with tlsPassthrough"))
+		}
+	}
+	return errs
+}
+
+// validateRedis validates the fields which the Redis proxy does not support.
+// The Redis proxy replaces the TCP proxy, so the idle timeout, max connection duration and keepalive are not applied.
+// The connect timeout is applied to the cluster.
+func validateRedis(specPath *field.Path, tunnel *ktunnelsv1.Tunnel) field.ErrorList {
+	if tunnel.Spec.Protocol != ktunnelsv1.TunnelProtocolRedis {
+		return nil
+	}
+	var errs field.ErrorList
+	if timeouts := tunnel.Spec.Timeouts; timeouts != nil {
+		if timeouts.Idle != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("timeouts", "idle"), "idle timeout is not supported by the Redis protocol"))
+		}
+		if timeouts.MaxConnectionDuration != nil {
+			errs = append(errs, field.Forbidden(specPath.Child("timeouts", "maxConnectionDuration"),
+				"max connection duration is not supported by the Redis protocol"))
+		}
+	}
+	if tunnel.Spec.Keepalive != nil {
+		errs = append(errs, field.Forbidden(specPath.Child("keepalive"), "keepalive is not supported by the Redis protocol"))
+	}
+	return errs
+}
+
+// validateHTTP validates the request headers and the protocol in the HTTP mode.
+// The Host header is rewritten by hostRewrite, so it cannot be added.
+func validateHTTP(specPath *field.Path, tunnel *ktunnelsv1.Tunnel) field.ErrorList {
+	if tunnel.Spec.HTTP == nil {
+		return nil
+	}
+	var errs field.ErrorList
+	switch tunnel.Spec.Protocol {
+	case "", ktunnelsv1.TunnelProtocolTCP:
+	default:
+		errs = append(errs, field.Forbidden(specPath.Child("protocol"), "protocol cannot be set in the HTTP mode"))
+	}
+	for i, header := range tunnel.Spec.HTTP.RequestHeaders {
+		headerPath := specPath.Child("http", "requestHeaders").Index(i)
+		for _, msg := range validation.IsHTTPHeaderName(header.Name) {
+			errs = append(errs, field.Invalid(headerPath.Child("name"), header.Name, msg))
+		}
+		if strings.EqualFold(header.Name, "host") {
+			errs = append(errs, field.Forbidden(headerPath.Child("name"), "use hostRewrite instead"))
+		}
+		if (header.Value == "") == (header.SecretKeyRef == nil) {
+			errs = append(errs, field.Invalid(headerPath, header.Name, "either value or secretKeyRef must be set"))
+		}
+		if ref := header.SecretKeyRef; ref != nil && ref.Name == "" {
+			errs = append(errs, field.Required(headerPath.Child("secretKeyRef", "name"), "secret name must be set"))
+		}
+	}
+	return errs
+}
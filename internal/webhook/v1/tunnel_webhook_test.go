@@ -0,0 +1,398 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1
+
+import (
+	"context"
+	"net"
+	"time"
+
+	. "github.com/onsi/ginkgo/v2"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/ptr"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	ktunnelsv1 "github.com/int128/ktunnels/api/v1"
+)
+
+var _ = Describe("Tunnel Webhook", func() {
+	var validator TunnelCustomValidator
+	var proxy ktunnelsv1.Proxy
+	var tunnel ktunnelsv1.Tunnel
+	BeforeEach(func(ctx context.Context) {
+		validator = TunnelCustomValidator{Client: k8sClient, APIReader: k8sClient, Resolver: net.DefaultResolver}
+
+		By("Creating a Proxy")
+		proxy = ktunnelsv1.Proxy{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "example-",
+				Namespace:    "default",
+			},
+		}
+		Expect(k8sClient.Create(ctx, &proxy)).Should(Succeed())
+
+		tunnel = ktunnelsv1.Tunnel{
+			ObjectMeta: metav1.ObjectMeta{
+				GenerateName: "microservice-database-",
+				Namespace:    "default",
+			},
+			Spec: ktunnelsv1.TunnelSpec{
+				Host:  "microservice-database.staging",
+				Port:  5432,
+				Proxy: corev1.LocalObjectReference{Name: proxy.Name},
+			},
+		}
+	})
+
+	Context("When creating a Tunnel", func() {
+		It("Should admit a valid tunnel", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit an IP address", func(ctx context.Context) {
+			tunnel.Spec.Host = "192.168.1.2"
+			warnings, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(BeEmpty())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an empty host", func(ctx context.Context) {
+			tunnel.Spec.Host = ""
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.host")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an invalid host", func(ctx context.Context) {
+			tunnel.Spec.Host = "microservice_database:5432"
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.host")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit destinations without host", func(ctx context.Context) {
+			tunnel.Spec.Host = ""
+			tunnel.Spec.Destinations = []ktunnelsv1.TunnelDestination{
+				{Host: "old-database.staging", Weight: ptr.To[int32](90)},
+				{Host: "new-database.staging", Weight: ptr.To[int32](10)},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an invalid host of destination", func(ctx context.Context) {
+			tunnel.Spec.Destinations = []ktunnelsv1.TunnelDestination{{Host: "microservice_database"}}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.destinations[0].host")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny TLS without serverName for different hostnames", func(ctx context.Context) {
+			tunnel.Spec.Destinations = []ktunnelsv1.TunnelDestination{
+				{Host: "old-database.staging"},
+				{Host: "new-database.staging"},
+			}
+			tunnel.Spec.TLS = &ktunnelsv1.TunnelTLS{}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.tls.serverName")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny port 0", func(ctx context.Context) {
+			tunnel.Spec.Port = 0
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.port")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a negative ttl", func(ctx context.Context) {
+			tunnel.Spec.TTL = &metav1.Duration{Duration: -time.Hour}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.ttl")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny an invalid source range", func(ctx context.Context) {
+			tunnel.Spec.AllowedSourceRanges = []string{"127.0.0.1"}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.allowedSourceRanges[0]")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit disabling the idle timeout", func(ctx context.Context) {
+			tunnel.Spec.Timeouts = &ktunnelsv1.TunnelTimeouts{Idle: &metav1.Duration{}}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a zero connect timeout", func(ctx context.Context) {
+			tunnel.Spec.Timeouts = &ktunnelsv1.TunnelTimeouts{Connect: &metav1.Duration{}}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.timeouts.connect")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a keepalive time less than a second", func(ctx context.Context) {
+			tunnel.Spec.Keepalive = &ktunnelsv1.TunnelKeepalive{Time: &metav1.Duration{Duration: 500 * time.Millisecond}}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.keepalive.time")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a zero DNS refresh rate", func(ctx context.Context) {
+			tunnel.Spec.DNS = &ktunnelsv1.TunnelDNS{RefreshRate: &metav1.Duration{}}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.dns.refreshRate")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a relative health check path", func(ctx context.Context) {
+			tunnel.Spec.HealthCheck = &ktunnelsv1.TunnelHealthCheck{HTTPPath: "healthz"}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.healthCheck.httpPath")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit the HTTP mode with headers", func(ctx context.Context) {
+			tunnel.Spec.HTTP = &ktunnelsv1.TunnelHTTP{
+				RequestHeaders: []ktunnelsv1.TunnelHTTPHeader{
+					{Name: "X-Developer", Value: "alice"},
+					{
+						Name: "X-Api-Key",
+						SecretKeyRef: &corev1.SecretKeySelector{
+							LocalObjectReference: corev1.LocalObjectReference{Name: "payment-api"},
+							Key:                  "api-key",
+						},
+					},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a Host header in the HTTP mode", func(ctx context.Context) {
+			tunnel.Spec.HTTP = &ktunnelsv1.TunnelHTTP{
+				RequestHeaders: []ktunnelsv1.TunnelHTTPHeader{{Name: "Host", Value: "example.com"}},
+			}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.http.requestHeaders[0].name")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit the TLS passthrough", func(ctx context.Context) {
+			tunnel.Spec.Port = 443
+			tunnel.Spec.TLSPassthrough = true
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should warn if the server name conflicts with another tunnel", func(ctx context.Context) {
+			By("Creating a TLS passthrough tunnel")
+			existing := ktunnelsv1.Tunnel{
+				ObjectMeta: metav1.ObjectMeta{Name: "payment-api", Namespace: "default"},
+				Spec: ktunnelsv1.TunnelSpec{
+					Host:           "payment-api.staging",
+					Port:           443,
+					TLSPassthrough: true,
+					Proxy:          corev1.LocalObjectReference{Name: proxy.Name},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &existing)).Should(Succeed())
+			DeferCleanup(func(ctx context.Context) {
+				Expect(k8sClient.Delete(ctx, &existing)).Should(Succeed())
+			})
+
+			tunnel.Name = "payment-api-v2"
+			tunnel.Spec.Host = "payment-api.staging"
+			tunnel.Spec.Port = 443
+			tunnel.Spec.TLSPassthrough = true
+			warnings, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(ConsistOf(ContainSubstring("server name payment-api.staging is already routed to tunnel payment-api")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny the TLS passthrough to an IP address", func(ctx context.Context) {
+			tunnel.Spec.Host = "10.1.2.3"
+			tunnel.Spec.TLSPassthrough = true
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.host")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny the TLS passthrough with TLS origination", func(ctx context.Context) {
+			tunnel.Spec.TLSPassthrough = true
+			tunnel.Spec.TLS = &ktunnelsv1.TunnelTLS{}
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.tls")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit an access log collector", func(ctx context.Context) {
+			tunnel.Spec.AccessLog = &ktunnelsv1.AccessLog{
+				Stdout:    &ktunnelsv1.StdoutAccessLog{Format: ktunnelsv1.AccessLogFormatText},
+				Collector: &ktunnelsv1.AccessLogCollector{Address: "otel-collector.monitoring.svc:4317"},
+			}
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit multiple ports without port", func(ctx context.Context) {
+			tunnel.Spec.Port = 0
+			tunnel.Spec.Ports = []ktunnelsv1.TunnelPort{{Name: "amqp", Port: 5672}}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should warn if the proxy does not support the protocol", func(ctx context.Context) {
+			tunnel.Spec.Protocol = ktunnelsv1.TunnelProtocolPostgreSQL
+			warnings, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(ConsistOf(ContainSubstring("requires the contrib image of Envoy")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny the Redis protocol with the idle timeout", func(ctx context.Context) {
+			tunnel.Spec.Protocol = ktunnelsv1.TunnelProtocolRedis
+			tunnel.Spec.Timeouts = &ktunnelsv1.TunnelTimeouts{Idle: &metav1.Duration{Duration: time.Hour}}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.timeouts.idle")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny the Redis protocol with keepalive", func(ctx context.Context) {
+			tunnel.Spec.Protocol = ktunnelsv1.TunnelProtocolRedis
+			tunnel.Spec.Keepalive = &ktunnelsv1.TunnelKeepalive{Time: &metav1.Duration{Duration: time.Minute}}
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.keepalive")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should warn if the proxy does not exist", func(ctx context.Context) {
+			tunnel.Spec.Proxy.Name = "missing"
+			warnings, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+			Expect(warnings).Should(ConsistOf(ContainSubstring("proxy missing is not found")))
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When a TunnelPolicy exists", func() {
+		BeforeEach(func(ctx context.Context) {
+			By("Creating a TunnelPolicy")
+			tunnelPolicy := ktunnelsv1.TunnelPolicy{
+				ObjectMeta: metav1.ObjectMeta{GenerateName: "staging-"},
+				Spec: ktunnelsv1.TunnelPolicySpec{
+					Allow: []ktunnelsv1.TunnelPolicyRule{{Hosts: []string{"*.staging"}}},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &tunnelPolicy)).Should(Succeed())
+			DeferCleanup(func(ctx context.Context) {
+				Expect(k8sClient.Delete(ctx, &tunnelPolicy)).Should(Succeed())
+			})
+		})
+
+		It("Should admit an allowed destination", func(ctx context.Context) {
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny a destination not allowed", func(ctx context.Context) {
+			tunnel.Spec.Host = "microservice-database.production"
+			_, err := validator.ValidateCreate(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("is not allowed by any TunnelPolicy")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny changing the destination", func(ctx context.Context) {
+			newTunnel := tunnel.DeepCopy()
+			newTunnel.Spec.Host = "microservice-database.production"
+			_, err := validator.ValidateUpdate(ctx, &tunnel, newTunnel)
+			Expect(err).Should(MatchError(ContainSubstring("is not allowed by any TunnelPolicy")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny updating a tunnel to a denied destination", func(ctx context.Context) {
+			tunnel.Spec.Host = "microservice-database.production"
+			newTunnel := tunnel.DeepCopy()
+			newTunnel.Labels = map[string]string{"team": "payment"}
+			_, err := validator.ValidateUpdate(ctx, &tunnel, newTunnel)
+			Expect(err).Should(MatchError(ContainSubstring("is not allowed by any TunnelPolicy")))
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When creating a Tunnel without proxy", func() {
+		BeforeEach(func() {
+			tunnel.Spec.Proxy.Name = ""
+		})
+
+		It("Should set the proxy of the namespace annotation", func(ctx context.Context) {
+			By("Creating a Namespace with the annotation")
+			namespace := corev1.Namespace{
+				ObjectMeta: metav1.ObjectMeta{
+					GenerateName: "tunnel-webhook-",
+					Annotations:  map[string]string{ktunnelsv1.AnnotationKeyDefaultProxy: "shared"},
+				},
+			}
+			Expect(k8sClient.Create(ctx, &namespace)).Should(Succeed())
+
+			tunnel.Namespace = namespace.Name
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+			Expect(tunnel.Spec.Proxy.Name).Should(Equal("shared"))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should set the default proxy name", func(ctx context.Context) {
+			defaulter := TunnelCustomDefaulter{Client: k8sClient, DefaultProxyName: proxy.Name}
+			Expect(defaulter.Default(ctx, &tunnel)).Should(Succeed())
+			Expect(tunnel.Spec.Proxy.Name).Should(Equal(proxy.Name))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should keep the proxy if set", func(ctx context.Context) {
+			tunnel.Spec.Proxy.Name = "another"
+			defaulter := TunnelCustomDefaulter{Client: k8sClient, DefaultProxyName: proxy.Name}
+			Expect(defaulter.Default(ctx, &tunnel)).Should(Succeed())
+			Expect(tunnel.Spec.Proxy.Name).Should(Equal("another"))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny if no default is available", func(ctx context.Context) {
+			err := k8sClient.Create(ctx, &tunnel)
+			Expect(err).Should(MatchError(ContainSubstring("spec.proxy.name")))
+		}, SpecTimeout(3*time.Second))
+	})
+
+	Context("When updating a Tunnel", func() {
+		It("Should admit setting the proxy of a tunnel without proxy", func(ctx context.Context) {
+			oldTunnel := tunnel.DeepCopy()
+			oldTunnel.Spec.Proxy.Name = ""
+			_, err := validator.ValidateUpdate(ctx, oldTunnel, &tunnel)
+			Expect(err).ShouldNot(HaveOccurred())
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny changing the proxy", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+			tunnel.Spec.Proxy.Name = "another"
+			err := k8sClient.Patch(ctx, &tunnel, tunnelPatch)
+			Expect(err).Should(MatchError(ContainSubstring("spec.proxy.name")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny switching to multiple ports", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+			tunnel.Spec.Port = 0
+			tunnel.Spec.Ports = []ktunnelsv1.TunnelPort{{Name: "postgres", Port: 5432}}
+			err := k8sClient.Patch(ctx, &tunnel, tunnelPatch)
+			Expect(err).Should(MatchError(ContainSubstring("spec.ports")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should deny changing the TLS passthrough", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+			tunnel.Spec.TLSPassthrough = true
+			err := k8sClient.Patch(ctx, &tunnel, tunnelPatch)
+			Expect(err).Should(MatchError(ContainSubstring("spec.tlsPassthrough")))
+		}, SpecTimeout(3*time.Second))
+
+		It("Should admit changing the host", func(ctx context.Context) {
+			Expect(k8sClient.Create(ctx, &tunnel)).Should(Succeed())
+
+			tunnelPatch := client.MergeFrom(tunnel.DeepCopy())
+			tunnel.Spec.Host = "another-database.staging"
+			Expect(k8sClient.Patch(ctx, &tunnel, tunnelPatch)).Should(Succeed())
+		}, SpecTimeout(3*time.Second))
+	})
+})
@@ -0,0 +1,179 @@
+package xds
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+
+	corev3 "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discoveryv3 "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	cachev3 "github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	serverv3 "github.com/envoyproxy/go-control-plane/pkg/server/v3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/peer"
+	corev1 "k8s.io/api/core/v1"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+var log = ctrl.Log.WithName("xds")
+
+// Server serves the Envoy configuration of proxies via the aggregated discovery service (ADS).
+// It implements manager.Runnable.
+type Server struct {
+	// BindAddress is the address to listen on, e.g. :18000
+	BindAddress string
+	// AdvertiseAddress is the address which Envoy connects to, e.g. ktunnels-xds-service.ktunnels-system.svc:18000
+	AdvertiseAddress string
+	// Reader reads the pods of a proxy to authenticate the node.
+	Reader client.Reader
+	// PodLabelKey is the label of the pods which has the name of the proxy.
+	PodLabelKey string
+
+	cache cachev3.SnapshotCache
+	// streams has the state of the connected streams, keyed by the stream ID.
+	streams sync.Map
+}
+
+// stream represents the state of a connected stream.
+type stream struct {
+	peerAddr netip.Addr
+	// nodeID is set when the node is authenticated.
+	nodeID string
+}
+
+func NewServer(bindAddress, advertiseAddress string, reader client.Reader, podLabelKey string) *Server {
+	return &Server{
+		BindAddress:      bindAddress,
+		AdvertiseAddress: advertiseAddress,
+		Reader:           reader,
+		PodLabelKey:      podLabelKey,
+		cache:            cachev3.NewSnapshotCache(true, cachev3.IDHash{}, nil),
+	}
+}
+
+// SetSnapshot updates the configuration of the node.
+// The connected Envoy receives the configuration immediately.
+func (s *Server) SetSnapshot(ctx context.Context, nodeID string, snapshot *cachev3.Snapshot) error {
+	if err := s.cache.SetSnapshot(ctx, nodeID, snapshot); err != nil {
+		return fmt.Errorf("unable to set the snapshot: %w", err)
+	}
+	return nil
+}
+
+// ClearSnapshot removes the configuration of the node.
+func (s *Server) ClearSnapshot(nodeID string) {
+	s.cache.ClearSnapshot(nodeID)
+}
+
+// Start runs the gRPC server until the context is canceled.
+func (s *Server) Start(ctx context.Context) error {
+	l, err := net.Listen("tcp", s.BindAddress)
+	if err != nil {
+		return fmt.Errorf("unable to listen on %s: %w", s.BindAddress, err)
+	}
+	grpcServer := grpc.NewServer()
+	callbacks := serverv3.CallbackFuncs{
+		StreamOpenFunc: s.onStreamOpen,
+		StreamClosedFunc: func(streamID int64, _ *corev3.Node) {
+			s.streams.Delete(streamID)
+		},
+		StreamRequestFunc: func(streamID int64, req *discoveryv3.DiscoveryRequest) error {
+			return s.onStreamRequest(ctx, streamID, req)
+		},
+		DeltaStreamOpenFunc: func(context.Context, int64, string) error {
+			return errors.New("delta xDS is not supported")
+		},
+	}
+	discoveryv3.RegisterAggregatedDiscoveryServiceServer(grpcServer, serverv3.NewServer(ctx, s.cache, callbacks))
+
+	go func() {
+		<-ctx.Done()
+		grpcServer.GracefulStop()
+	}()
+	log.Info("Starting the xDS server", "address", l.Addr().String())
+	if err := grpcServer.Serve(l); err != nil {
+		return fmt.Errorf("gRPC server error: %w", err)
+	}
+	return nil
+}
+
+// onStreamOpen records the address of the peer.
+func (s *Server) onStreamOpen(ctx context.Context, streamID int64, _ string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return errors.New("no peer address")
+	}
+	tcpAddr, ok := p.Addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("peer address %s is not TCP", p.Addr)
+	}
+	peerAddr, ok := netip.AddrFromSlice(tcpAddr.IP)
+	if !ok {
+		return fmt.Errorf("invalid peer address %s", p.Addr)
+	}
+	s.streams.Store(streamID, &stream{peerAddr: peerAddr.Unmap()})
+	return nil
+}
+
+// onStreamRequest authenticates the node of the first request of the stream.
+// Otherwise, any pod which can reach the xDS server could receive the configuration of a proxy.
+func (s *Server) onStreamRequest(ctx context.Context, streamID int64, req *discoveryv3.DiscoveryRequest) error {
+	v, ok := s.streams.Load(streamID)
+	if !ok {
+		return fmt.Errorf("unknown stream %d", streamID)
+	}
+	st := v.(*stream)
+	nodeID := req.GetNode().GetId()
+	if st.nodeID != "" {
+		// Envoy may omit the node after the first request
+		if nodeID != "" && nodeID != st.nodeID {
+			return fmt.Errorf("node %s is different from the authenticated node %s", nodeID, st.nodeID)
+		}
+		return nil
+	}
+	if err := s.authenticate(ctx, nodeID, st.peerAddr); err != nil {
+		log.Info("rejected the stream", "node", nodeID, "peer", st.peerAddr.String(), "error", err.Error())
+		return err
+	}
+	st.nodeID = nodeID
+	log.Info("authenticated the node", "node", nodeID, "peer", st.peerAddr.String())
+	return nil
+}
+
+// authenticate checks if the peer is a running pod of the proxy of the node.
+// The node ID is in the form of namespace/name of the proxy.
+func (s *Server) authenticate(ctx context.Context, nodeID string, peerAddr netip.Addr) error {
+	namespace, name, ok := strings.Cut(nodeID, "/")
+	if !ok || namespace == "" || name == "" {
+		return fmt.Errorf("invalid node ID %q", nodeID)
+	}
+	var podList corev1.PodList
+	if err := s.Reader.List(ctx, &podList,
+		client.InNamespace(namespace),
+		client.MatchingLabels{s.PodLabelKey: name},
+	); err != nil {
+		return fmt.Errorf("unable to list the pods of the proxy: %w", err)
+	}
+	for _, pod := range podList.Items {
+		if pod.Status.Phase != corev1.PodRunning {
+			continue
+		}
+		for _, podIP := range pod.Status.PodIPs {
+			if addr, err := netip.ParseAddr(podIP.IP); err == nil && addr == peerAddr {
+				return nil
+			}
+		}
+	}
+	return fmt.Errorf("peer %s is not a running pod of the proxy %s", peerAddr, nodeID)
+}
+
+// NeedLeaderElection returns true, because only the leader reconciles the snapshots.
+// Envoy retries the connection until it reaches the leader.
+func (s *Server) NeedLeaderElection() bool {
+	return true
+}
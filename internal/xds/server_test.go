@@ -0,0 +1,55 @@
+package xds
+
+import (
+	"context"
+	"net/netip"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+func TestServer_authenticate(t *testing.T) {
+	const podLabelKey = "ktunnels.int128.github.io/proxy"
+	newPod := func(name, proxyName, podIP string, phase corev1.PodPhase) *corev1.Pod {
+		return &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: "default",
+				Labels:    map[string]string{podLabelKey: proxyName},
+			},
+			Status: corev1.PodStatus{
+				Phase:  phase,
+				PodIPs: []corev1.PodIP{{IP: podIP}},
+			},
+		}
+	}
+	c := fake.NewClientBuilder().WithObjects(
+		newPod("proxy-default-1", "default", "10.1.0.1", corev1.PodRunning),
+		newPod("proxy-default-2", "default", "10.1.0.2", corev1.PodPending),
+		newPod("proxy-other-1", "other", "10.1.0.3", corev1.PodRunning),
+	).Build()
+	s := NewServer(":18000", "xds:18000", c, podLabelKey)
+
+	t.Run("pod of the proxy", func(t *testing.T) {
+		if err := s.authenticate(context.TODO(), "default/default", netip.MustParseAddr("10.1.0.1")); err != nil {
+			t.Errorf("authenticate wants nil but got %s", err)
+		}
+	})
+	t.Run("pod of another proxy", func(t *testing.T) {
+		if err := s.authenticate(context.TODO(), "default/default", netip.MustParseAddr("10.1.0.3")); err == nil {
+			t.Errorf("authenticate wants an error but got nil")
+		}
+	})
+	t.Run("pod is not running", func(t *testing.T) {
+		if err := s.authenticate(context.TODO(), "default/default", netip.MustParseAddr("10.1.0.2")); err == nil {
+			t.Errorf("authenticate wants an error but got nil")
+		}
+	})
+	t.Run("invalid node ID", func(t *testing.T) {
+		if err := s.authenticate(context.TODO(), "default", netip.MustParseAddr("10.1.0.1")); err == nil {
+			t.Errorf("authenticate wants an error but got nil")
+		}
+	})
+}